@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// templateFS holds the HTML templates of the admin UI. Each page defines the
+// "content" template rendered in templates/layout.html.
+//
+//go:embed templates/*.html
+var templateFS embed.FS
+
+// staticFS holds the static assets of the admin UI served under /admin/static/.
+// They are plain files, so there is no build step.
+//
+//go:embed static
+var staticFS embed.FS
+
+// Default configuration values of the admin server. It listens on the
+// loopback interface only, apart from the public allowlist server.
+const (
+	adminPortDefault = "5964"
+	adminHostDefault = "127.0.0.1"
+)
+
+// Names of the HTTP servers. The admin name is also the LISTEN_FDNAMES name of
+// the systemd socket for the admin server.
+const (
+	serverPublic = "public"
+	serverAdmin  = "admin"
+)
+
+// DefaultAdminServerConfig returns the default admin server configuration.
+func DefaultAdminServerConfig() ServerConfig {
+	conf := DefaultServerConfig()
+	conf.Host = adminHostDefault
+	conf.Port = adminPortDefault
+
+	return conf
+}
+
+// ============================================================================
+//  Admin Handlers
+// ============================================================================
+
+// statusReporter is implemented by providers that report their reload status.
+type statusReporter interface {
+	Status() ReloadStatus
+}
+
+// adminStatus is the JSON response of GET /admin/status.
+type adminStatus struct {
+	ETag        string     `json:"etag,omitempty"`
+	Reloading   bool       `json:"reloading"`
+	LastAttempt *time.Time `json:"lastAttempt,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	Diagnostics []string   `json:"diagnostics,omitempty"`
+	// QueryLog is the state of the query log reader, if enabled.
+	QueryLog *queryLogStatus `json:"queryLog,omitempty"`
+	// Blocky is the state of the Blocky instances, if any.
+	Blocky []blockyStatus `json:"blocky,omitempty"`
+	// Lists is the state of the named allowlists, if any.
+	Lists []listStatus `json:"lists,omitempty"`
+}
+
+// listStatus is a named allowlist in GET /admin/status.
+type listStatus struct {
+	Name string `json:"name"`
+	// ETag is the ETag of the served list, including all its inputs.
+	ETag        string         `json:"etag,omitempty"`
+	Include     []string       `json:"include,omitempty"`
+	Exclude     []string       `json:"exclude,omitempty"`
+	Sources     []sourceStatus `json:"sources,omitempty"`
+	LastSuccess *time.Time     `json:"lastSuccess,omitempty"`
+	LastError   string         `json:"lastError,omitempty"`
+}
+
+// sourceStatus is a remote source of a named allowlist in GET /admin/status.
+type sourceStatus struct {
+	URL         string     `json:"url"`
+	ETag        string     `json:"etag,omitempty"`
+	LastAttempt *time.Time `json:"lastAttempt,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+}
+
+// queryLogStatus is the query log part of GET /admin/status.
+type queryLogStatus struct {
+	LastPoll       *time.Time `json:"lastPoll,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	Entries        int        `json:"entries"`
+	Invalid        int        `json:"invalid"`
+	BlockedDomains int        `json:"blockedDomains"`
+}
+
+// blockyStatus is a Blocky instance in GET /admin/status.
+type blockyStatus struct {
+	URL             string      `json:"url"`
+	State           BlockyState `json:"state"`
+	BlockingEnabled bool        `json:"blockingEnabled"`
+	AutoEnable      *time.Time  `json:"autoEnable,omitempty"`
+	LastProbe       *time.Time  `json:"lastProbe,omitempty"`
+	LastReachable   *time.Time  `json:"lastReachable,omitempty"`
+	NextProbe       *time.Time  `json:"nextProbe,omitempty"`
+	Failures        int         `json:"failures"`
+	LastError       string      `json:"lastError,omitempty"`
+	// Pending and Confirmed are the state of the latest list refresh.
+	Pending       bool       `json:"pending"`
+	Confirmed     bool       `json:"confirmed"`
+	LastRequest   *time.Time `json:"lastRequest,omitempty"`
+	LastConfirmed *time.Time `json:"lastConfirmed,omitempty"`
+}
+
+// adminServices holds the state shared by the admin handlers.
+type adminServices struct {
+	authn    *Authenticator
+	sessions *SessionStore
+	limiter  *LoginLimiter
+	// blocked is the index of the domains blocked by Blocky.
+	blocked *BlockedIndex
+	// queryLog reads the query log into blocked. Nil if disabled.
+	queryLog QueryLogReader
+	// dismissals are the groups of blocked domains dismissed by the users.
+	dismissals *DismissalStore
+	// blocky refreshes the lists of Blocky after allowlist changes.
+	blocky *BlockyClient
+	// maxPause is the longest pause of blocking allowed.
+	maxPause time.Duration
+	// audit is the audit trail of the actions of the users.
+	audit *AuditLog
+	// lists are the named allowlists served at /lists/{name}.txt.
+	lists *ListSet
+	// blockyConfig is the blocking section of the Blocky config serving the
+	// lists.
+	blockyConfig []byte
+	// now returns the current time. Replaced in tests.
+	now func() time.Time
+}
+
+// newAdminServices returns the admin services for the configuration loaded
+// from the config file at configPath.
+func newAdminServices(configPath string, conf Config) (*adminServices, error) {
+	sessions, err := NewSessionStore(conf.Auth.SessionsPath,
+		time.Duration(conf.Auth.SessionIdleTimeout), time.Duration(conf.Auth.SessionMaxAge))
+	if err != nil {
+		return nil, err
+	}
+
+	blocked := NewBlockedIndex(time.Duration(conf.QueryLog.Retention))
+
+	queryLog, err := newQueryLogReader(conf.QueryLog, blocked)
+	if err != nil {
+		return nil, err
+	}
+
+	dismissals, err := NewDismissalStore(conf.QueryLog.DismissedPath, conf.QueryLog.DismissThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	audit, err := NewAuditLog(conf.AuditLogPath)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := new(adminServices)
+	svc.authn = NewAuthenticator(configPath, conf.Auth)
+	svc.sessions = sessions
+	svc.limiter = NewLoginLimiter()
+	svc.blocked = blocked
+	svc.queryLog = queryLog
+	svc.dismissals = dismissals
+	svc.blocky = NewBlockyClient(conf.Blocky)
+	svc.maxPause = time.Duration(conf.Blocky.MaxPause)
+	svc.audit = audit
+	svc.lists = NewListSet(conf.Lists, conf.Remote)
+	svc.blockyConfig = BlockyBlockingConfig(conf)
+	svc.now = time.Now
+
+	return svc, nil
+}
+
+// newAdminHandler returns the handler of the admin server. All the pages but
+// the sign-in and enrollment ones require a session of a user with the role
+// of the page.
+//
+// Cross-origin state-changing requests are rejected, which also protects the
+// sign-in form that has no session and CSRF token yet.
+func newAdminHandler(prov AllowlistProvider, svc *adminServices) http.Handler {
+	pages := newPageRenderer()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/status", svc.requireSession(RoleViewer, newAdminStatusHandler(prov, svc)))
+	registerAuthHandlers(mux, svc, pages)
+	registerUserHandlers(mux, svc, pages)
+	editor := NewAllowlistEditor(prov)
+	registerAllowlistHandlers(mux, svc, pages, editor)
+	registerBlockedHandlers(mux, svc, pages, editor)
+	registerBlockingHandlers(mux, svc, pages)
+	registerListsHandlers(mux, svc, pages)
+	mux.Handle("GET /admin/static/", http.StripPrefix("/admin", http.FileServerFS(staticFS)))
+
+	return http.NewCrossOriginProtection().Handler(mux)
+}
+
+// newAdminStatusHandler returns the handler reporting the state of the served
+// allowlist and of the query log reader.
+func newAdminStatusHandler(prov AllowlistProvider, svc *adminServices) http.HandlerFunc {
+	return func(resWriter http.ResponseWriter, req *http.Request) {
+		status := new(adminStatus)
+
+		if reporter, ok := prov.(statusReporter); ok {
+			reload := reporter.Status()
+
+			status.Reloading = true
+			status.ETag = reload.ETag
+			status.LastAttempt = timeOrNil(reload.LastAttempt)
+			status.LastSuccess = timeOrNil(reload.LastSuccess)
+
+			if reload.LastError != nil {
+				status.LastError = reload.LastError.Error()
+			}
+
+			for _, diag := range reload.Diagnostics {
+				status.Diagnostics = append(status.Diagnostics, diag.String())
+			}
+		} else {
+			snap, err := prov.Snapshot(req.Context())
+			if err != nil {
+				status.LastError = err.Error()
+			}
+
+			status.ETag = snap.ETag
+		}
+
+		if svc.queryLog != nil {
+			status.QueryLog = newQueryLogStatus(svc.queryLog.Status(), svc.blocked.Len())
+		}
+
+		for _, refresh := range svc.blocky.Status() {
+			status.Blocky = append(status.Blocky, newBlockyStatus(refresh))
+		}
+
+		for _, list := range svc.lists.Lists() {
+			status.Lists = append(status.Lists, newListStatus(req.Context(), list))
+		}
+
+		resWriter.Header().Set("Content-Type", "application/json")
+		resWriter.Header().Set("Cache-Control", "no-store")
+
+		err := json.NewEncoder(resWriter).Encode(status)
+		if err != nil {
+			slog.Error("failed to write admin status", "error", err)
+		}
+	}
+}
+
+// newQueryLogStatus returns the JSON form of the query log status.
+func newQueryLogStatus(status QueryLogStatus, blocked int) *queryLogStatus {
+	result := &queryLogStatus{
+		LastPoll: timeOrNil(status.LastPoll), LastError: "", Entries: status.Entries, Invalid: status.Invalid,
+		BlockedDomains: blocked,
+	}
+
+	if status.LastError != nil {
+		result.LastError = status.LastError.Error()
+	}
+
+	return result
+}
+
+// newBlockyStatus returns the JSON form of the state of a Blocky instance.
+func newBlockyStatus(status BlockyStatus) blockyStatus {
+	result := blockyStatus{
+		URL: status.URL, State: status.State, BlockingEnabled: status.BlockingEnabled,
+		LastProbe: timeOrNil(status.LastProbe), LastReachable: timeOrNil(status.LastReachable),
+		NextProbe: timeOrNil(status.NextProbe), Failures: status.Failures, LastError: "",
+		Pending: status.Pending, Confirmed: status.Confirmed(), LastRequest: timeOrNil(status.LastRequest),
+		LastConfirmed: timeOrNil(status.LastConfirmed), AutoEnable: timeOrNil(status.AutoEnable),
+	}
+
+	if status.LastError != nil {
+		result.LastError = status.LastError.Error()
+	}
+
+	return result
+}
+
+// newListStatus returns the JSON form of the state of a named allowlist. The
+// error is of the served list if it cannot be built, or else of the latest
+// reload of its file.
+func newListStatus(ctx context.Context, list *NamedList) listStatus {
+	// The snapshot loads the files never read yet
+	snap, err := list.Snapshot(ctx)
+	result := listStatus{
+		Name: list.Name(), ETag: snap.ETag, Include: list.Includes(), Exclude: list.Excludes(), Sources: nil,
+		LastSuccess: nil, LastError: "",
+	}
+
+	if file := list.File(); file != nil {
+		reload := file.Status()
+		result.LastSuccess = timeOrNil(reload.LastSuccess)
+
+		if err == nil {
+			err = reload.LastError
+		}
+	}
+
+	if err != nil {
+		result.LastError = err.Error()
+	}
+
+	for _, source := range list.Sources() {
+		fetch := source.Status()
+		status := sourceStatus{
+			URL: source.URL(), ETag: fetch.ETag, LastAttempt: timeOrNil(fetch.LastAttempt),
+			LastSuccess: timeOrNil(fetch.LastSuccess), LastError: "",
+		}
+
+		if fetch.LastError != nil {
+			status.LastError = fetch.LastError.Error()
+		}
+
+		result.Sources = append(result.Sources, status)
+	}
+
+	return result
+}
+
+// timeOrNil returns nil for the zero time so that it is omitted in JSON.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+// ============================================================================
+//  Pages
+// ============================================================================
+
+// page is the data passed to the page templates.
+type page struct {
+	Title    string
+	Error    string
+	Notice   string
+	Username string
+	// Role is the role of the signed-in user.
+	Role Role
+	// CSRFToken is the token of the session to put in the forms.
+	CSRFToken string
+	// Lockouts are the usernames and addresses locked out of login.
+	Lockouts []Lockout
+	// RecoveryCodesLeft is the number of unused recovery codes.
+	RecoveryCodesLeft int
+	// Enrollment only
+	Secret string
+	URI    string
+	// RecoveryCodes are shown once after the enrollment.
+	RecoveryCodes []string
+	// Users page only
+	Users []UserInfo
+	Roles []Role
+	// QRCode is the inline SVG QR code of a new user.
+	QRCode template.HTML
+	// Allowlist is the data of the allowlist page.
+	Allowlist *allowlistPage
+	// Blocked is the data of the blocked domains page.
+	Blocked *blockedPage
+	// Blocking is the data of the blocking page.
+	Blocking *blockingPage
+	// Lists is the data of the lists pages.
+	Lists *listsPage
+	// Blocky is the state of the Blocky instances.
+	Blocky []BlockyStatus
+}
+
+// pageRenderer renders the HTML pages of the admin UI.
+type pageRenderer struct {
+	pages map[string]*template.Template
+}
+
+// newPageRenderer parses the embedded templates. It panics on a broken
+// template since they are part of the binary.
+func newPageRenderer() *pageRenderer {
+	names, err := templateFS.ReadDir("templates")
+	if err != nil {
+		panic(err)
+	}
+
+	renderer := new(pageRenderer)
+	renderer.pages = make(map[string]*template.Template, len(names))
+
+	for _, entry := range names {
+		if entry.Name() == "layout.html" {
+			continue
+		}
+
+		renderer.pages[entry.Name()] = template.Must(
+			template.ParseFS(templateFS, "templates/layout.html", "templates/"+entry.Name()))
+	}
+
+	return renderer
+}
+
+// render writes the page with the status code. Pages are never cached nor
+// framed by other sites.
+func (r *pageRenderer) render(resWriter http.ResponseWriter, status int, name string, data page) {
+	tmpl, ok := r.pages[name]
+	if !ok {
+		slog.Error("unknown page template", "name", name)
+		http.Error(resWriter, "Internal Server Error", http.StatusInternalServerError)
+
+		return
+	}
+
+	header := resWriter.Header()
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	header.Set("Cache-Control", "no-store")
+	header.Set("X-Frame-Options", "DENY")
+	header.Set("Content-Security-Policy", "default-src 'self'; style-src 'self' 'unsafe-inline'; frame-ancestors 'none'")
+	resWriter.WriteHeader(status)
+
+	err := tmpl.ExecuteTemplate(resWriter, "layout", data)
+	if err != nil {
+		slog.Error("failed to render page", "name", name, "error", err)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for admin handlers
+// ============================================================================
+
+func TestAdminStatus_reloading_provider(t *testing.T) {
+	t.Parallel()
+
+	prov := NewReloadingAllowlistProvider(NewFileAllowlistProvider(writeTempFile(t, "example.com\nbad..com\n")), nil)
+	require.NoError(t, prov.Reload(context.Background()))
+
+	status := getAdminStatus(t, prov, testAdminServices(t, "", testAdminConfig("alice")))
+
+	assert.True(t, status.Reloading)
+	assert.Equal(t, fastHash("example.com\n"), status.ETag)
+	assert.NotNil(t, status.LastSuccess)
+	assert.Empty(t, status.LastError)
+	require.Len(t, status.Diagnostics, 1)
+	assert.Contains(t, status.Diagnostics[0], "line 2")
+}
+
+func TestAdminStatus_static_provider(t *testing.T) {
+	t.Parallel()
+
+	status := getAdminStatus(t, new(StaticAllowlistProvider), testAdminServices(t, "", testAdminConfig("alice")))
+
+	assert.False(t, status.Reloading)
+	assert.Equal(t, fastHash(allowlist), status.ETag)
+	assert.Nil(t, status.LastSuccess)
+	assert.Nil(t, status.QueryLog)
+}
+
+func TestAdminStatus_query_log(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeQueryLog(t, dir, time.Now().Format(time.DateOnly)+"_ALL.log",
+		queryLogLine(time.Now(), "ads.example.com.", "BLOCKED")+"broken\n")
+
+	conf := testAdminConfig("alice")
+	conf.QueryLog.Type = queryLogCSV
+	conf.QueryLog.Target = dir
+
+	svc := testAdminServices(t, "", conf)
+	require.NoError(t, svc.queryLog.Poll(context.Background()))
+
+	status := getAdminStatus(t, new(StaticAllowlistProvider), svc)
+
+	require.NotNil(t, status.QueryLog)
+	assert.NotNil(t, status.QueryLog.LastPoll)
+	assert.Empty(t, status.QueryLog.LastError)
+	assert.Equal(t, 1, status.QueryLog.Entries)
+	assert.Equal(t, 1, status.QueryLog.Invalid)
+	assert.Equal(t, 1, status.QueryLog.BlockedDomains)
+}
+
+func TestAdminStatus_blocky(t *testing.T) {
+	t.Parallel()
+
+	blocky := newFakeBlocky(t, 0)
+
+	conf := testAdminConfig("alice")
+	conf.Blocky.URLs = []string{blocky.URL, "http://127.0.0.1:1"}
+	conf.Blocky.CanaryDomain = ""
+
+	svc := testAdminServices(t, "", conf)
+	require.NoError(t, svc.blocky.Probe(context.Background(), 0))
+	require.Error(t, svc.blocky.Probe(context.Background(), 1))
+
+	status := getAdminStatus(t, new(StaticAllowlistProvider), svc)
+
+	require.Len(t, status.Blocky, 2)
+	assert.Equal(t, BlockyReachable, status.Blocky[0].State)
+	assert.True(t, status.Blocky[0].BlockingEnabled)
+	assert.NotNil(t, status.Blocky[0].LastReachable)
+	assert.Equal(t, BlockyUnreachable, status.Blocky[1].State)
+	assert.Equal(t, 1, status.Blocky[1].Failures)
+	assert.NotEmpty(t, status.Blocky[1].LastError)
+
+	rec := serveAdmin(newAdminHandler(new(StaticAllowlistProvider), svc), http.MethodGet, "/admin/", nil,
+		testSignIn(t, svc, "alice"))
+	assert.Contains(t, rec.Body.String(), "<h2>Blocky</h2>")
+	assert.Contains(t, rec.Body.String(), "unreachable (1 failures)")
+}
+
+func TestAdminStatus_lists(t *testing.T) {
+	t.Parallel()
+
+	conf := testAdminConfig("alice")
+	conf.Lists = []ListConfig{
+		testListConfig("base", writeTempFile(t, "example.com\n")),
+		testListConfig("kids", writeTempFile(t, "example.org\n"), "base"),
+		testListConfig("iot", writeTempFile(t, "# empty\n")),
+	}
+
+	status := getAdminStatus(t, new(StaticAllowlistProvider), testAdminServices(t, "", conf))
+
+	require.Len(t, status.Lists, 3)
+	assert.Equal(t, "kids", status.Lists[1].Name)
+	assert.Equal(t, []string{"base"}, status.Lists[1].Include)
+	assert.NotEmpty(t, status.Lists[1].ETag)
+	assert.NotNil(t, status.Lists[1].LastSuccess)
+	assert.Empty(t, status.Lists[1].LastError)
+	assert.Empty(t, status.Lists[2].ETag)
+	assert.Contains(t, status.Lists[2].LastError, ErrAllowlistEmpty.Error())
+}
+
+func getAdminStatus(t *testing.T, prov AllowlistProvider, svc *adminServices) adminStatus {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/status", nil)
+	req.AddCookie(testSignIn(t, svc, "alice"))
+
+	rec := httptest.NewRecorder()
+
+	newAdminHandler(prov, svc).ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var status adminStatus
+
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+
+	return status
+}
+
+// ============================================================================
+//  Tests for public and admin servers
+// ============================================================================
+
+func TestRun_public_and_admin_servers(t *testing.T) {
+	t.Parallel()
+
+	conf := DefaultConfig()
+	conf.Server.UnixSocket = shortSocketPath(t)
+	conf.Server.ShutdownTimeout = 1 * time.Second
+	conf.Admin.UnixSocket = shortSocketPath(t)
+	conf.Admin.ShutdownTimeout = 1 * time.Second
+	conf.Lists = []ListConfig{testListConfig("kids", writeTempFile(t, "example.com\n"))}
+
+	quit := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- run(new(StaticAllowlistProvider), testAdminServices(t, "", conf), conf, quit)
+	}()
+
+	public, admin := unixClient(conf.Server.UnixSocket), unixClient(conf.Admin.UnixSocket)
+
+	require.Eventually(t, func() bool {
+		return fileExists(conf.Server.UnixSocket) && fileExists(conf.Admin.UnixSocket)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tests := []struct {
+		name   string
+		client *http.Client
+		path   string
+		expect int
+	}{
+		{name: "public allowlist", client: public, path: "/allowlist.txt", expect: http.StatusOK},
+		{name: "public named list", client: public, path: "/lists/kids.txt", expect: http.StatusOK},
+		{name: "public unknown list", client: public, path: "/lists/adults.txt", expect: http.StatusNotFound},
+		{name: "public has no admin", client: public, path: "/admin/status", expect: http.StatusNotFound},
+		{name: "admin status requires login", client: admin, path: "/admin/status", expect: http.StatusUnauthorized},
+		{name: "admin login", client: admin, path: "/admin/login", expect: http.StatusOK},
+		{name: "admin has no allowlist", client: admin, path: "/allowlist.txt", expect: http.StatusNotFound},
+		{name: "admin has no lists", client: admin, path: "/lists/kids.txt", expect: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://alotame"+test.path, nil)
+		require.NoError(t, err)
+
+		resp, err := test.client.Do(req)
+		require.NoError(t, err, test.name)
+		require.NoError(t, resp.Body.Close())
+
+		assert.Equal(t, test.expect, resp.StatusCode, test.name)
+	}
+
+	quit <- os.Interrupt
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return in time")
+	}
+
+	assert.False(t, fileExists(conf.Server.UnixSocket), "public socket should be closed")
+	assert.False(t, fileExists(conf.Admin.UnixSocket), "admin socket should be closed")
+}
+
+func TestRun_admin_listen_failure(t *testing.T) {
+	t.Parallel()
+
+	// Occupy the admin port
+	occupied, err := new(net.ListenConfig).Listen(context.Background(), "tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer occupied.Close()
+
+	_, port, err := net.SplitHostPort(occupied.Addr().String())
+	require.NoError(t, err)
+
+	conf := DefaultConfig()
+	conf.Server.UnixSocket = shortSocketPath(t)
+	conf.Server.ShutdownTimeout = 1 * time.Second
+	conf.Admin.Port = port
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- run(new(StaticAllowlistProvider), testAdminServices(t, "", conf), conf, make(chan os.Signal))
+	}()
+
+	select {
+	case err := <-done:
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "admin server")
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return in time")
+	}
+
+	// The listener is closed once the public server goroutine notices the shutdown
+	assert.Eventually(t, func() bool {
+		return !fileExists(conf.Server.UnixSocket)
+	}, 5*time.Second, 10*time.Millisecond, "public server should be shut down")
+}
+
+func TestGroupListeners(t *testing.T) {
+	t.Parallel()
+
+	listeners := make([]net.Listener, 3)
+	for idx := range listeners {
+		listeners[idx] = new(net.TCPListener)
+	}
+
+	grouped := groupListeners(listeners, []string{"http", serverAdmin})
+
+	assert.Len(t, grouped[serverPublic], 2)
+	assert.Len(t, grouped[serverAdmin], 1)
+	assert.Same(t, listeners[1], grouped[serverAdmin][0])
+}
+
+func unixClient(path string) *http.Client {
+	transport := new(http.Transport)
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return new(net.Dialer).DialContext(ctx, "unix", path)
+	}
+
+	client := new(http.Client)
+	client.Transport = transport
+
+	return client
+}
+
+// testAdminServices returns the admin services of the configuration.
+func testAdminServices(t *testing.T, configPath string, conf Config) *adminServices {
+	t.Helper()
+
+	svc, err := newAdminServices(configPath, conf)
+	require.NoError(t, err)
+
+	return svc
+}
+
+// testSignIn creates a session of the user and returns its cookie.
+func testSignIn(t *testing.T, svc *adminServices, username string) *http.Cookie {
+	t.Helper()
+
+	sessionID, _, err := svc.sessions.Create(username, svc.now())
+	require.NoError(t, err)
+
+	return &http.Cookie{Name: sessionCookieName, Value: sessionID} //nolint:exhaustruct // only name and value are sent
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Actions of an AllowlistChange.
+const (
+	changeAdd    = "add"
+	changeEdit   = "edit"
+	changeDelete = "delete"
+)
+
+// diffContext is the number of unchanged lines shown around a change.
+const diffContext = 3
+
+// Errors of allowlist editing.
+var (
+	ErrAllowlistNotEditable = errors.New("the allowlist is not editable: set allowlistPath to a file")
+	ErrAllowlistChanged     = errors.New("the allowlist file was changed by someone else. Review the change again")
+	// ErrInvalidChange wraps the errors of the change made by the user.
+	ErrInvalidChange  = errors.New("invalid change")
+	ErrChangeAction   = errors.New("unknown change action")
+	ErrChangeLine     = errors.New("the line has no entry")
+	ErrCommentInvalid = errors.New("comment must be a single line")
+	ErrChangeNoEffect = errors.New("the change has no effect on the allowlist")
+)
+
+// ============================================================================
+//  Changes
+// ============================================================================
+
+// AllowlistChange is an edit of a line of the allowlist source file. Lines
+// other than the changed one, including comments, are kept as they are.
+type AllowlistChange struct {
+	// Action is "add", "edit" or "delete".
+	Action string
+	// Line is the 1-based line number to edit or delete.
+	Line int
+	// Entry is the domain, wildcard or regex to add or to replace with.
+	Entry string
+	// Comment is the trailing comment of the entry.
+	Comment string
+}
+
+// Apply returns the source with the change applied. The new entry must parse
+// without any diagnostic, so that it is served as written.
+func (c AllowlistChange) Apply(source []byte) ([]byte, error) {
+	lines := strings.Split(string(source), "\n")
+	// A trailing newline leaves an empty last element, which is kept so that
+	// the file still ends with a newline.
+	lastIdx := len(lines) - 1
+	if lines[lastIdx] != "" {
+		lines = append(lines, "")
+		lastIdx++
+	}
+
+	lineNum := c.Line
+
+	switch c.Action {
+	case changeAdd:
+		line, err := c.format()
+		if err != nil {
+			return nil, err
+		}
+
+		lines = append(lines[:lastIdx], line, "")
+		lineNum = lastIdx + 1
+	case changeEdit, changeDelete:
+		if c.Line < 1 || c.Line > lastIdx {
+			return nil, ErrChangeLine
+		}
+
+		if _, ok, err := parseLine(lines[c.Line-1]); !ok || err != nil {
+			return nil, ErrChangeLine
+		}
+
+		if c.Action == changeDelete {
+			lines = append(lines[:c.Line-1], lines[c.Line:]...)
+
+			break
+		}
+
+		line, err := c.format()
+		if err != nil {
+			return nil, err
+		}
+
+		lines[c.Line-1] = line
+	default:
+		return nil, ErrChangeAction
+	}
+
+	updated := []byte(strings.Join(lines, "\n"))
+
+	if c.Action == changeDelete {
+		return updated, nil
+	}
+
+	// The new line must be served as written, not dropped as a duplicate or
+	// a confusable name.
+	for _, diag := range ParseAllowlist(updated).Diagnostics {
+		if diag.Line == lineNum {
+			return nil, diag.Err
+		}
+	}
+
+	return updated, nil
+}
+
+// format returns the source line of the entry and the comment.
+func (c AllowlistChange) format() (string, error) {
+	if strings.ContainsAny(c.Comment, "\r\n") {
+		return "", ErrCommentInvalid
+	}
+
+	entry, comment := strings.TrimSpace(c.Entry), strings.TrimSpace(c.Comment)
+
+	_, err := parseEntry(entry)
+	if err != nil {
+		return "", err
+	}
+
+	if comment == "" {
+		return entry, nil
+	}
+
+	return entry + " # " + comment, nil
+}
+
+// parseEntry parses the entry typed in a form. Unlike a line of the file, it
+// must not have a comment.
+func parseEntry(raw string) (AllowlistEntry, error) {
+	raw = strings.TrimSpace(raw)
+
+	entry, ok, err := parseLine(raw)
+	if err != nil {
+		return AllowlistEntry{}, err
+	}
+
+	if !ok {
+		return AllowlistEntry{}, ErrDomainEmpty
+	}
+
+	if entry.Raw != raw {
+		return AllowlistEntry{}, ErrDomainTrailingGarbage
+	}
+
+	return entry, nil
+}
+
+// ============================================================================
+//  Editor
+// ============================================================================
+
+// editableAllowlist is implemented by providers that serve a file that can be
+// edited from the admin UI.
+type editableAllowlist interface {
+	Path() string
+	Reload(ctx context.Context) error
+}
+
+// AllowlistEditor edits the allowlist source file of a provider.
+//
+// Changes are based on a version of the file identified by its hash, so that
+// a change previewed on an older version is not saved over a newer one.
+type AllowlistEditor struct {
+	prov editableAllowlist
+	// mu serializes saves.
+	mu sync.Mutex
+}
+
+// NewAllowlistEditor returns the editor of the provider. The editor is
+// read-only if the provider is not backed by a file.
+func NewAllowlistEditor(prov AllowlistProvider) *AllowlistEditor {
+	editor := new(AllowlistEditor)
+
+	if editable, ok := prov.(editableAllowlist); ok {
+		editor.prov = editable
+	}
+
+	return editor
+}
+
+// Editable returns true if the allowlist can be edited.
+func (e *AllowlistEditor) Editable() bool {
+	return e.prov != nil
+}
+
+// Source returns the content of the allowlist source file and its version.
+func (e *AllowlistEditor) Source() ([]byte, string, error) {
+	if e.prov == nil {
+		return []byte(allowlist), fastHash(allowlist), nil
+	}
+
+	data, err := os.ReadFile(e.prov.Path())
+	if err != nil {
+		return nil, "", wrapError(err, "failed to read allowlist file")
+	}
+
+	return data, fastHash(string(data)), nil
+}
+
+// Check validates the entry for the live validation of the forms. The entry
+// must not be a duplicate of the entries of the source other than the line
+// being edited.
+func (e *AllowlistEditor) Check(raw string, editLine int) (AllowlistEntry, error) {
+	entry, err := parseEntry(raw)
+	if err != nil {
+		return AllowlistEntry{}, err
+	}
+
+	source, _, err := e.Source()
+	if err != nil {
+		return AllowlistEntry{}, err
+	}
+
+	for _, existing := range ParseAllowlist(source).Entries {
+		if existing.Domain == entry.Domain && existing.Line != editLine {
+			return AllowlistEntry{}, fmt.Errorf("%w of line %d", ErrDomainDuplicate, existing.Line)
+		}
+	}
+
+	return entry, nil
+}
+
+// AllowlistPreview is a change applied to a version of the allowlist, not
+// saved yet.
+type AllowlistPreview struct {
+	Change AllowlistChange
+	// Version is the hash of the source the change is based on.
+	Version string
+	// Diff is the difference of the served /allowlist.txt.
+	Diff []DiffLine
+	// Updated is the source with the change applied.
+	Updated []byte
+}
+
+// Preview applies the change to the current source without saving it.
+func (e *AllowlistEditor) Preview(change AllowlistChange) (AllowlistPreview, error) {
+	if e.prov == nil {
+		return AllowlistPreview{}, ErrAllowlistNotEditable
+	}
+
+	source, version, err := e.Source()
+	if err != nil {
+		return AllowlistPreview{}, err
+	}
+
+	updated, err := change.Apply(source)
+	if err != nil {
+		return AllowlistPreview{}, fmt.Errorf("%w: %w", ErrInvalidChange, err)
+	}
+
+	parsed := ParseAllowlist(updated)
+	if len(parsed.Entries) == 0 {
+		return AllowlistPreview{}, fmt.Errorf("%w: %w", ErrInvalidChange, ErrAllowlistEmpty)
+	}
+
+	diff := diffLines(servedLines(ParseAllowlist(source)), servedLines(parsed))
+	if len(diff) == 0 {
+		return AllowlistPreview{}, fmt.Errorf("%w: %w", ErrInvalidChange, ErrChangeNoEffect)
+	}
+
+	return AllowlistPreview{Change: change, Version: version, Diff: diff, Updated: updated}, nil
+}
+
+// Save applies the change to the source of the version and reloads the
+// provider. It fails with ErrAllowlistChanged if the file has changed since.
+func (e *AllowlistEditor) Save(ctx context.Context, change AllowlistChange, version string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Check the version first, since the change may no longer apply to a
+	// changed file
+	_, current, err := e.Source()
+	if err != nil {
+		return err
+	}
+
+	if current != version {
+		return ErrAllowlistChanged
+	}
+
+	preview, err := e.Preview(change)
+	if err != nil {
+		return err
+	}
+
+	path := e.prov.Path()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return wrapError(err, "failed to stat allowlist file")
+	}
+
+	err = writeFileAtomic(path, preview.Updated, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	return e.prov.Reload(ctx)
+}
+
+// Add adds the entry with the comment to the current allowlist without a
+// preview, for the actions that add a single known entry.
+func (e *AllowlistEditor) Add(ctx context.Context, entry, comment string) error {
+	_, version, err := e.Source()
+	if err != nil {
+		return err
+	}
+
+	return e.Save(ctx, AllowlistChange{Action: changeAdd, Line: 0, Entry: entry, Comment: comment}, version)
+}
+
+// ============================================================================
+//  Diff
+// ============================================================================
+
+// DiffLine is a line of a unified diff.
+type DiffLine struct {
+	// Op is "+" for an added line, "-" for a removed line, " " for an
+	// unchanged line and "@" for a hunk header.
+	Op   string
+	Text string
+}
+
+// servedLines returns the lines of the allowlist as served.
+func servedLines(parsed ParsedAllowlist) []string {
+	lines := make([]string, 0, len(parsed.Entries))
+	for _, entry := range parsed.Entries {
+		lines = append(lines, entry.Domain)
+	}
+
+	return lines
+}
+
+// diffLines returns the unified diff of the lines with diffContext lines of
+// context. It returns nil if they are the same.
+//
+// Only the part between the common prefix and suffix is compared, which is
+// small for the edits of a single line.
+func diffLines(before, after []string) []DiffLine {
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix &&
+		before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+
+	removed, added := before[prefix:len(before)-suffix], after[prefix:len(after)-suffix]
+	if len(removed) == 0 && len(added) == 0 {
+		return nil
+	}
+
+	start := max(prefix-diffContext, 0)
+	tail := min(suffix, diffContext)
+
+	diff := make([]DiffLine, 0, len(removed)+len(added)+prefix-start+tail+1)
+	diff = append(diff, DiffLine{Op: "@", Text: fmt.Sprintf("@@ -%d,%d +%d,%d @@",
+		start+1, prefix-start+len(removed)+tail, start+1, prefix-start+len(added)+tail)})
+
+	for _, line := range before[start:prefix] {
+		diff = append(diff, DiffLine{Op: " ", Text: line})
+	}
+
+	for _, line := range removed {
+		diff = append(diff, DiffLine{Op: "-", Text: line})
+	}
+
+	for _, line := range added {
+		diff = append(diff, DiffLine{Op: "+", Text: line})
+	}
+
+	for _, line := range after[len(after)-suffix : len(after)-suffix+tail] {
+		diff = append(diff, DiffLine{Op: " ", Text: line})
+	}
+
+	return diff
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for AllowlistChange
+// ============================================================================
+
+func TestAllowlistChange_Apply(t *testing.T) {
+	t.Parallel()
+
+	source := "# school\nexample.com # homework\n*.example.org\n"
+
+	for _, test := range []struct {
+		name   string
+		change AllowlistChange
+		expect string
+		err    error
+	}{
+		{
+			name:   "add",
+			change: AllowlistChange{Action: changeAdd, Line: 0, Entry: " example.net ", Comment: " docs "},
+			expect: source + "example.net # docs\n",
+			err:    nil,
+		},
+		{
+			name:   "edit keeps other lines",
+			change: AllowlistChange{Action: changeEdit, Line: 2, Entry: "example.net", Comment: ""},
+			expect: "# school\nexample.net\n*.example.org\n",
+			err:    nil,
+		},
+		{
+			name:   "delete",
+			change: AllowlistChange{Action: changeDelete, Line: 3, Entry: "", Comment: ""},
+			expect: "# school\nexample.com # homework\n",
+			err:    nil,
+		},
+		{
+			name:   "comment line is not an entry",
+			change: AllowlistChange{Action: changeDelete, Line: 1, Entry: "", Comment: ""},
+			expect: "",
+			err:    ErrChangeLine,
+		},
+		{
+			name:   "line out of range",
+			change: AllowlistChange{Action: changeEdit, Line: 9, Entry: "example.net", Comment: ""},
+			expect: "",
+			err:    ErrChangeLine,
+		},
+		{
+			name:   "duplicate",
+			change: AllowlistChange{Action: changeAdd, Line: 0, Entry: "EXAMPLE.com", Comment: ""},
+			expect: "",
+			err:    ErrDomainDuplicate,
+		},
+		{
+			name:   "comment in entry",
+			change: AllowlistChange{Action: changeAdd, Line: 0, Entry: "example.net # x", Comment: ""},
+			expect: "",
+			err:    ErrDomainTrailingGarbage,
+		},
+		{
+			name:   "multi-line comment",
+			change: AllowlistChange{Action: changeAdd, Line: 0, Entry: "example.net", Comment: "a\nb.com"},
+			expect: "",
+			err:    ErrCommentInvalid,
+		},
+		{
+			name:   "unknown action",
+			change: AllowlistChange{Action: "rename", Line: 2, Entry: "example.net", Comment: ""},
+			expect: "",
+			err:    ErrChangeAction,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			updated, err := test.change.Apply([]byte(source))
+
+			if test.err != nil {
+				require.ErrorIs(t, err, test.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, string(updated))
+		})
+	}
+}
+
+func TestAllowlistChange_Apply_no_trailing_newline(t *testing.T) {
+	t.Parallel()
+
+	change := AllowlistChange{Action: changeAdd, Line: 0, Entry: "example.net", Comment: ""}
+
+	updated, err := change.Apply([]byte("example.com"))
+
+	require.NoError(t, err)
+	assert.Equal(t, "example.com\nexample.net\n", string(updated))
+}
+
+// ============================================================================
+//  Tests for AllowlistEditor
+// ============================================================================
+
+func TestAllowlistEditor_Save(t *testing.T) {
+	t.Parallel()
+
+	path := writeTempFile(t, "example.com\nexample.org\n")
+	prov := NewReloadingAllowlistProvider(NewFileAllowlistProvider(path), nil)
+	editor := NewAllowlistEditor(prov)
+	change := AllowlistChange{Action: changeAdd, Line: 0, Entry: "example.net", Comment: "added"}
+
+	require.True(t, editor.Editable())
+
+	preview, err := editor.Preview(change)
+	require.NoError(t, err)
+	assert.Equal(t, []DiffLine{
+		{Op: "@", Text: "@@ -1,2 +1,3 @@"},
+		{Op: " ", Text: "example.com"},
+		{Op: " ", Text: "example.org"},
+		{Op: "+", Text: "example.net"},
+	}, preview.Diff)
+
+	// Someone else changes the file after the preview
+	require.NoError(t, os.WriteFile(path, []byte("example.com\n"), 0o600))
+
+	err = editor.Save(context.Background(), change, preview.Version)
+	require.ErrorIs(t, err, ErrAllowlistChanged)
+
+	preview, err = editor.Preview(change)
+	require.NoError(t, err)
+	require.NoError(t, editor.Save(context.Background(), change, preview.Version))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com\nexample.net # added\n", string(data))
+
+	// The provider serves the saved file
+	snap, err := prov.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "example.com\nexample.net\n", string(snap.Data))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestAllowlistEditor_Preview_errors(t *testing.T) {
+	t.Parallel()
+
+	editor := NewAllowlistEditor(NewReloadingAllowlistProvider(
+		NewFileAllowlistProvider(writeTempFile(t, "example.com # only\n")), nil))
+
+	_, err := editor.Preview(AllowlistChange{Action: changeDelete, Line: 1, Entry: "", Comment: ""})
+	require.ErrorIs(t, err, ErrInvalidChange)
+	require.ErrorIs(t, err, ErrAllowlistEmpty)
+
+	// Only the comment changes, which is not served
+	_, err = editor.Preview(AllowlistChange{Action: changeEdit, Line: 1, Entry: "example.com", Comment: "x"})
+	require.ErrorIs(t, err, ErrChangeNoEffect)
+
+	_, err = NewAllowlistEditor(new(StaticAllowlistProvider)).Preview(
+		AllowlistChange{Action: changeAdd, Line: 0, Entry: "example.net", Comment: ""})
+	require.ErrorIs(t, err, ErrAllowlistNotEditable)
+}
+
+func TestAllowlistEditor_Check(t *testing.T) {
+	t.Parallel()
+
+	editor := NewAllowlistEditor(NewReloadingAllowlistProvider(
+		NewFileAllowlistProvider(writeTempFile(t, "example.com\n")), nil))
+
+	entry, err := editor.Check("*.Example.org", 0)
+	require.NoError(t, err)
+	assert.Equal(t, EntryWildcard, entry.Kind)
+
+	_, err = editor.Check("example.com", 0)
+	require.ErrorIs(t, err, ErrDomainDuplicate)
+
+	// Not a duplicate of the line being edited
+	_, err = editor.Check("example.com", 1)
+	require.NoError(t, err)
+
+	_, err = editor.Check("", 0)
+	require.ErrorIs(t, err, ErrDomainEmpty)
+}
+
+// ============================================================================
+//  Tests for diffLines
+// ============================================================================
+
+func TestDiffLines(t *testing.T) {
+	t.Parallel()
+
+	before := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"}
+
+	assert.Nil(t, diffLines(before, before))
+	assert.Equal(t, []DiffLine{
+		{Op: "@", Text: "@@ -2,7 +2,7 @@"},
+		{Op: " ", Text: "b"},
+		{Op: " ", Text: "c"},
+		{Op: " ", Text: "d"},
+		{Op: "-", Text: "e"},
+		{Op: "+", Text: "x"},
+		{Op: " ", Text: "f"},
+		{Op: " ", Text: "g"},
+		{Op: " ", Text: "h"},
+	}, diffLines(before, []string{"a", "b", "c", "d", "x", "f", "g", "h", "i"}))
+	assert.Equal(t, []DiffLine{
+		{Op: "@", Text: "@@ -1,2 +1,1 @@"},
+		{Op: "-", Text: "a"},
+		{Op: " ", Text: "b"},
+	}, diffLines([]string{"a", "b"}, []string{"b"}))
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Title of the allowlist pages.
+const titleAllowlist = "Allowlist"
+
+// ============================================================================
+//  Allowlist Handlers
+// ============================================================================
+
+// allowlistPage is the data of the allowlist page.
+type allowlistPage struct {
+	// List is the name of the named list shown. Empty for the main allowlist.
+	List string
+	// Lists are the names of the named lists with a file to edit.
+	Lists []string
+	// ServedPath is the path the list is served at on the public server.
+	ServedPath string
+	// Includes are the names of the lists served with the list.
+	Includes []string
+	// Editable is true if the allowlist is a file and the user is an admin.
+	Editable bool
+	// Query and Kind are the search filter.
+	Query string
+	Kind  EntryKind
+	Kinds []EntryKind
+	// Entries are the entries of the source file matching the filter.
+	Entries []AllowlistEntry
+	Total   int
+	// Diagnostics are the problems of the lines of the source file.
+	Diagnostics []Diagnostic
+	// Form is the change in the add or edit form.
+	Form AllowlistChange
+	// Preview is the change to confirm before saving.
+	Preview *AllowlistPreview
+}
+
+// entryValidation is the JSON response of GET /admin/allowlist/validate.
+type entryValidation struct {
+	Valid   bool      `json:"valid"`
+	Kind    EntryKind `json:"kind,omitempty"`
+	Domain  string    `json:"domain,omitempty"`
+	Unicode string    `json:"unicode,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// allowlistHandlers serves the allowlist management pages.
+type allowlistHandlers struct {
+	// editor edits the main allowlist, and editors the named lists.
+	editor  *AllowlistEditor
+	editors map[string]*AllowlistEditor
+	lists   *ListSet
+	blocky  *BlockyClient
+	pages   *pageRenderer
+}
+
+// registerAllowlistHandlers registers the allowlist pages to the mux. Anyone
+// signed in can view the allowlist and only admins can change it.
+//
+// The "list" parameter selects a named list instead of the main allowlist.
+// Changes go through a preview showing the diff of the served allowlist, and
+// are saved only if the file has not changed since the preview. Blocky is
+// asked to refresh its lists after each save.
+func registerAllowlistHandlers(mux *http.ServeMux, svc *adminServices, pages *pageRenderer, editor *AllowlistEditor) {
+	handlers := &allowlistHandlers{
+		editor: editor, editors: make(map[string]*AllowlistEditor), lists: svc.lists, blocky: svc.blocky, pages: pages,
+	}
+
+	for _, list := range svc.lists.Lists() {
+		if list.File() != nil {
+			handlers.editors[list.Name()] = NewAllowlistEditor(list.File())
+		}
+	}
+
+	mux.HandleFunc("GET /admin/allowlist", svc.requireSession(RoleViewer, handlers.getAllowlist))
+	mux.HandleFunc("GET /admin/allowlist/validate", svc.requireSession(RoleViewer, handlers.getValidate))
+	mux.HandleFunc("POST /admin/allowlist/preview", svc.requireSession(RoleAdmin, handlers.postPreview))
+	mux.HandleFunc("POST /admin/allowlist/save", svc.requireSession(RoleAdmin, handlers.postSave))
+}
+
+// getAllowlist lists the entries matching the "q" and "kind" parameters. With
+// the "edit" parameter, the form is filled with the entry of the line.
+func (h *allowlistHandlers) getAllowlist(resWriter http.ResponseWriter, req *http.Request) {
+	form := AllowlistChange{Action: changeAdd, Line: 0, Entry: "", Comment: ""}
+
+	if line, err := strconv.Atoi(req.FormValue("edit")); err == nil {
+		form.Action = changeEdit
+		form.Line = line
+	}
+
+	notice := ""
+	if req.FormValue("saved") != "" {
+		notice = "The allowlist is saved."
+	}
+
+	list, _, err := h.listEditor(req)
+	if err != nil {
+		h.renderError(resWriter, req, list, form, err)
+
+		return
+	}
+
+	h.render(resWriter, req, http.StatusOK, "", notice, list, form, nil)
+}
+
+// getValidate checks the "entry" parameter for the live validation of the
+// forms. The "line" parameter is the line being edited, which is not a
+// duplicate of itself.
+func (h *allowlistHandlers) getValidate(resWriter http.ResponseWriter, req *http.Request) {
+	line, _ := strconv.Atoi(req.FormValue("line"))
+	result := entryValidation{Valid: true, Kind: "", Domain: "", Unicode: "", Error: ""}
+
+	_, editor, err := h.listEditor(req)
+
+	var entry AllowlistEntry
+	if err == nil {
+		entry, err = editor.Check(req.FormValue("entry"), line)
+	}
+
+	if err != nil {
+		result.Valid = false
+		result.Error = err.Error()
+	} else {
+		result.Kind, result.Domain, result.Unicode = entry.Kind, entry.Domain, entry.Unicode
+	}
+
+	resWriter.Header().Set("Content-Type", "application/json")
+	resWriter.Header().Set("Cache-Control", "no-store")
+
+	err = json.NewEncoder(resWriter).Encode(result)
+	if err != nil {
+		slog.Error("failed to write validation", "error", err)
+	}
+}
+
+// postPreview shows the diff of the served allowlist the change makes.
+func (h *allowlistHandlers) postPreview(resWriter http.ResponseWriter, req *http.Request) {
+	change := changeFromForm(req)
+
+	list, editor, err := h.listEditor(req)
+	if err != nil {
+		h.renderError(resWriter, req, list, change, err)
+
+		return
+	}
+
+	preview, err := editor.Preview(change)
+	if err != nil {
+		h.renderError(resWriter, req, list, change, err)
+
+		return
+	}
+
+	h.render(resWriter, req, http.StatusOK, "", "", list, change, &preview)
+}
+
+// postSave saves the change previewed on the version in the form.
+func (h *allowlistHandlers) postSave(resWriter http.ResponseWriter, req *http.Request) {
+	change := changeFromForm(req)
+
+	list, editor, err := h.listEditor(req)
+	if err == nil {
+		err = editor.Save(req.Context(), change, req.PostFormValue("version"))
+	}
+
+	if err != nil {
+		h.renderError(resWriter, req, list, change, err)
+
+		return
+	}
+
+	sess, _ := sessionFromContext(req.Context())
+	slog.Info("allowlist changed", "username", sess.Username, "list", list, "action", change.Action,
+		"line", change.Line, "entry", change.Entry)
+	h.blocky.RequestRefresh()
+
+	query := url.Values{"saved": {"1"}}
+	if list != "" {
+		query.Set("list", list)
+	}
+
+	http.Redirect(resWriter, req, "/admin/allowlist?"+query.Encode(), http.StatusSeeOther)
+}
+
+// listEditor returns the name and the editor of the list of the "list"
+// parameter. The empty name is the main allowlist, which is also returned
+// with the error of an unknown list.
+func (h *allowlistHandlers) listEditor(req *http.Request) (string, *AllowlistEditor, error) {
+	name := req.FormValue("list")
+	if name == "" {
+		return "", h.editor, nil
+	}
+
+	editor, ok := h.editors[name]
+	if !ok {
+		return "", h.editor, fmt.Errorf("%w: %q", ErrListNotFound, name)
+	}
+
+	return name, editor, nil
+}
+
+// renderError renders the allowlist page with the error of the change and the
+// form filled with it.
+func (h *allowlistHandlers) renderError(
+	resWriter http.ResponseWriter, req *http.Request, list string, change AllowlistChange, err error,
+) {
+	status := http.StatusBadRequest
+
+	switch {
+	case errors.Is(err, ErrListNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrAllowlistChanged):
+		status = http.StatusConflict
+	case errors.Is(err, ErrAllowlistNotEditable):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, ErrInvalidChange):
+	default:
+		slog.Error("failed to change allowlist", "error", err)
+
+		status = http.StatusInternalServerError
+	}
+
+	if change.Action == changeDelete {
+		change = AllowlistChange{Action: changeAdd, Line: 0, Entry: "", Comment: ""}
+	}
+
+	h.render(resWriter, req, status, err.Error(), "", list, change, nil)
+}
+
+func (h *allowlistHandlers) render(resWriter http.ResponseWriter, req *http.Request, status int,
+	errMsg, notice, list string, form AllowlistChange, preview *AllowlistPreview,
+) {
+	sess, _ := sessionFromContext(req.Context())
+
+	editor, servedPath, includes := h.editor, "/allowlist.txt", []string(nil)
+
+	if named, ok := h.lists.Get(list); ok && h.editors[list] != nil {
+		editor, servedPath, includes = h.editors[list], listsPathPrefix+list+listsPathSuffix, named.Includes()
+	}
+
+	source, _, err := editor.Source()
+	if err != nil {
+		slog.Error("failed to read allowlist", "error", err)
+
+		status, errMsg = http.StatusInternalServerError, err.Error()
+	}
+
+	parsed := ParseAllowlist(source)
+	data := &allowlistPage{
+		List:        list,
+		Lists:       nil,
+		ServedPath:  servedPath,
+		Includes:    includes,
+		Editable:    editor.Editable() && sess.Role.Allows(RoleAdmin),
+		Query:       req.FormValue("q"),
+		Kind:        EntryKind(req.FormValue("kind")),
+		Kinds:       []EntryKind{EntryDomain, EntryWildcard, EntryRegex},
+		Entries:     nil,
+		Total:       len(parsed.Entries),
+		Diagnostics: parsed.Diagnostics,
+		Form:        form,
+		Preview:     preview,
+	}
+	data.Entries = filterEntries(parsed.Entries, data.Query, data.Kind)
+
+	for _, named := range h.lists.Lists() {
+		if named.File() != nil {
+			data.Lists = append(data.Lists, named.Name())
+		}
+	}
+
+	// Fill the edit form with the entry of the line
+	if form.Action == changeEdit && form.Entry == "" {
+		for _, entry := range parsed.Entries {
+			if entry.Line == form.Line {
+				data.Form.Entry, data.Form.Comment = entry.Raw, entry.Comment
+			}
+		}
+	}
+
+	h.pages.render(resWriter, status, "allowlist.html", page{ //nolint:exhaustruct // optional
+		Title: titleAllowlist, Error: errMsg, Notice: notice, Username: sess.Username, Role: sess.Role,
+		CSRFToken: sess.CSRFToken, Allowlist: data, Blocky: h.blocky.Status(),
+	})
+}
+
+// changeFromForm returns the change in the form of the request.
+func changeFromForm(req *http.Request) AllowlistChange {
+	line, _ := strconv.Atoi(req.PostFormValue("line"))
+
+	return AllowlistChange{
+		Action:  req.PostFormValue("action"),
+		Line:    line,
+		Entry:   req.PostFormValue("entry"),
+		Comment: req.PostFormValue("comment"),
+	}
+}
+
+// filterEntries returns the entries of the kind containing the query in the
+// entry or the comment, case-insensitively. Empty filters match all.
+func filterEntries(entries []AllowlistEntry, query string, kind EntryKind) []AllowlistEntry {
+	query = strings.ToLower(strings.TrimSpace(query))
+	matched := make([]AllowlistEntry, 0, len(entries))
+
+	for _, entry := range entries {
+		if kind != "" && entry.Kind != kind {
+			continue
+		}
+
+		text := strings.ToLower(fmt.Sprint(entry.Raw, "\n", entry.Domain, "\n", entry.Unicode, "\n", entry.Comment))
+		if strings.Contains(text, query) {
+			matched = append(matched, entry)
+		}
+	}
+
+	return matched
+}
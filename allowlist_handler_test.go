@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for Allowlist Handlers
+// ============================================================================
+
+func TestAllowlistHandlers(t *testing.T) {
+	t.Parallel()
+
+	path := writeTempFile(t, "example.com # school\n*.example.org\nbad domain\n")
+	prov := NewReloadingAllowlistProvider(NewFileAllowlistProvider(path), nil)
+	svc := testAdminServices(t, "", testAdminConfig("parent"))
+	handler := newAdminHandler(prov, svc)
+
+	admin := testSignIn(t, svc, "parent")
+	csrf := testCSRFToken(t, svc, admin)
+
+	rec := serveAdmin(handler, http.MethodGet, "/admin/allowlist", nil, admin)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<code>example.com</code>")
+	assert.Contains(t, rec.Body.String(), "<td>school</td>")
+	assert.Contains(t, rec.Body.String(), "line 3: error")
+	assert.Contains(t, rec.Body.String(), `action="/admin/allowlist/preview"`)
+
+	// Search and filter
+	rec = serveAdmin(handler, http.MethodGet, "/admin/allowlist?q=SCHOOL", nil, admin)
+	assert.Contains(t, rec.Body.String(), "1 of 2 entries.")
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/allowlist?kind=wildcard", nil, admin)
+	assert.Contains(t, rec.Body.String(), "<code>*.example.org</code>")
+	assert.NotContains(t, rec.Body.String(), "<code>example.com</code>")
+
+	// The edit form is filled with the line
+	rec = serveAdmin(handler, http.MethodGet, "/admin/allowlist?edit=1", nil, admin)
+	assert.Contains(t, rec.Body.String(), "Edit line 1")
+	assert.Contains(t, rec.Body.String(), `value="school"`)
+
+	// Preview shows the diff and saving applies it
+	form := url.Values{csrfFormField: {csrf}, "action": {"edit"}, "line": {"1"}, "entry": {"example.net"}}
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/allowlist/preview", form, admin)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `<span class="diff-del">-example.com</span>`)
+	assert.Contains(t, rec.Body.String(), `<span class="diff-add">&#43;example.net</span>`)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	form.Set("version", fastHash(string(data)))
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/allowlist/save", form, admin)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/admin/allowlist?saved=1", rec.Header().Get("Location"))
+
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "example.net\n*.example.org\nbad domain\n", string(data))
+
+	// Saving again is based on an old version
+	rec = serveAdmin(handler, http.MethodPost, "/admin/allowlist/save", form, admin)
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	form = url.Values{csrfFormField: {csrf}, "action": {"add"}, "entry": {"*.example.org"}}
+	rec = serveAdmin(handler, http.MethodPost, "/admin/allowlist/preview", form, admin)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrDomainDuplicate.Error())
+}
+
+func TestAllowlistHandlers_blocky_refresh(t *testing.T) {
+	t.Parallel()
+
+	blocky := newFakeBlocky(t, 0)
+
+	conf := testAdminConfig("parent")
+	conf.Blocky.URLs = []string{blocky.URL}
+	conf.Blocky.CanaryDomain = ""
+
+	svc := testAdminServices(t, "", conf)
+	svc.blocky.retryDelay = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go svc.blocky.Run(ctx)
+
+	path := writeTempFile(t, "example.com\n")
+	handler := newAdminHandler(NewReloadingAllowlistProvider(NewFileAllowlistProvider(path), nil), svc)
+	admin := testSignIn(t, svc, "parent")
+
+	rec := serveAdmin(handler, http.MethodGet, "/admin/allowlist", nil, admin)
+	assert.Contains(t, rec.Body.String(), "not requested yet")
+
+	form := url.Values{
+		csrfFormField: {testCSRFToken(t, svc, admin)}, "action": {"add"}, "entry": {"example.net"},
+		"version": {fastHash("example.com\n")},
+	}
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/allowlist/save", form, admin)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+
+	assert.Eventually(t, func() bool { return blocky.refreshes() == 1 }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		rec = serveAdmin(handler, http.MethodGet, "/admin/allowlist?saved=1", nil, admin)
+
+		return strings.Contains(rec.Body.String(), "confirmed at")
+	}, time.Second, time.Millisecond)
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/status", nil, admin)
+
+	var status adminStatus
+
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	require.Len(t, status.Blocky, 1)
+	assert.Equal(t, blocky.URL, status.Blocky[0].URL)
+	assert.True(t, status.Blocky[0].Confirmed)
+}
+
+func TestAllowlistHandlers_named_list(t *testing.T) {
+	t.Parallel()
+
+	mainPath := writeTempFile(t, "example.com\n")
+	kidsPath := writeTempFile(t, "khanacademy.org\n")
+
+	conf := testAdminConfig("parent")
+	conf.Lists = []ListConfig{
+		testListConfig("base", writeTempFile(t, "github.com\n")),
+		testListConfig("kids", kidsPath, "base"),
+	}
+
+	svc := testAdminServices(t, "", conf)
+	handler := newAdminHandler(NewReloadingAllowlistProvider(NewFileAllowlistProvider(mainPath), nil), svc)
+	admin := testSignIn(t, svc, "parent")
+
+	rec := serveAdmin(handler, http.MethodGet, "/admin/allowlist?list=kids", nil, admin)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Served at <code>/lists/kids.txt</code>.")
+	assert.Contains(t, rec.Body.String(), `<a href="/admin/lists/base">base</a>`)
+	assert.Contains(t, rec.Body.String(), "<code>khanacademy.org</code>")
+	assert.NotContains(t, rec.Body.String(), "<code>example.com</code>")
+
+	// The entry is checked against the list
+	rec = serveAdmin(handler, http.MethodGet, "/admin/allowlist/validate?list=kids&entry=example.com", nil, admin)
+	assert.Contains(t, rec.Body.String(), `"valid":true`)
+
+	form := url.Values{
+		csrfFormField: {testCSRFToken(t, svc, admin)}, "list": {"kids"}, "action": {"add"}, "entry": {"example.com"},
+		"version": {fastHash("khanacademy.org\n")},
+	}
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/allowlist/save", form, admin)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/admin/allowlist?list=kids&saved=1", rec.Header().Get("Location"))
+
+	data, err := os.ReadFile(kidsPath)
+	require.NoError(t, err)
+	assert.Equal(t, "khanacademy.org\nexample.com\n", string(data))
+
+	kids, _ := svc.lists.Get("kids")
+	snap, err := kids.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "khanacademy.org\nexample.com\ngithub.com\n", string(snap.Data))
+
+	data, err = os.ReadFile(mainPath)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com\n", string(data), "the main allowlist must not change")
+
+	// Unknown lists
+	rec = serveAdmin(handler, http.MethodGet, "/admin/allowlist?list=adults", nil, admin)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	form.Set("list", "adults")
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/allowlist/preview", form, admin)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrListNotFound.Error())
+}
+
+func TestAllowlistHandlers_read_only(t *testing.T) {
+	t.Parallel()
+
+	conf := testAdminConfig("parent")
+	conf.Auth.Users = append(conf.Auth.Users, UserConfig{Username: "teen", Role: RoleViewer, RecoveryCodes: nil})
+
+	svc := testAdminServices(t, "", conf)
+	prov := NewReloadingAllowlistProvider(NewFileAllowlistProvider(writeTempFile(t, "example.com\n")), nil)
+	handler := newAdminHandler(prov, svc)
+
+	teen := testSignIn(t, svc, "teen")
+	form := url.Values{csrfFormField: {testCSRFToken(t, svc, teen)}, "action": {"add"}, "entry": {"example.net"}}
+
+	rec := serveAdmin(handler, http.MethodGet, "/admin/allowlist", nil, teen)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<code>example.com</code>")
+	assert.NotContains(t, rec.Body.String(), `action="/admin/allowlist/preview"`)
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/allowlist/preview", form, teen)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/allowlist/save", form, teen)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// The built-in allowlist is not editable, even by admins
+	handler = newAdminHandler(new(StaticAllowlistProvider), svc)
+	admin := testSignIn(t, svc, "parent")
+	form.Set(csrfFormField, testCSRFToken(t, svc, admin))
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/allowlist/preview", form, admin)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestAllowlistHandlers_validate(t *testing.T) {
+	t.Parallel()
+
+	svc := testAdminServices(t, "", testAdminConfig("parent"))
+	prov := NewReloadingAllowlistProvider(NewFileAllowlistProvider(writeTempFile(t, "example.com\n")), nil)
+	handler := newAdminHandler(prov, svc)
+	cookie := testSignIn(t, svc, "parent")
+
+	for _, test := range []struct {
+		query  string
+		expect entryValidation
+	}{
+		{
+			query: "entry=xn--bcher-kva.example",
+			expect: entryValidation{
+				Valid: true, Kind: EntryDomain, Domain: "xn--bcher-kva.example", Unicode: "bücher.example", Error: "",
+			},
+		},
+		{
+			query:  "entry=example.com",
+			expect: entryValidation{Valid: false, Kind: "", Domain: "", Unicode: "", Error: "duplicate entry of line 1"},
+		},
+		{
+			query:  "entry=example.com&line=1",
+			expect: entryValidation{Valid: true, Kind: EntryDomain, Domain: "example.com", Unicode: "example.com", Error: ""},
+		},
+	} {
+		rec := serveAdmin(handler, http.MethodGet, "/admin/allowlist/validate?"+test.query, nil, cookie)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+		var result entryValidation
+
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		assert.Equal(t, test.expect, result, test.query)
+	}
+}
+
+func TestAllowlistHandlers_static(t *testing.T) {
+	t.Parallel()
+
+	svc := testAdminServices(t, "", testAdminConfig("parent"))
+	handler := newAdminHandler(new(StaticAllowlistProvider), svc)
+
+	rec := serveAdmin(handler, http.MethodGet, "/admin/static/admin.js", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "/admin/allowlist/validate")
+}
+
+func TestFilterEntries(t *testing.T) {
+	t.Parallel()
+
+	entries := ParseAllowlist([]byte("example.com # school\n*.example.org\n/^cdn[0-9]+\\.example\\.com$/\n")).Entries
+
+	assert.Len(t, filterEntries(entries, "", ""), 3)
+	assert.Len(t, filterEntries(entries, " Example ", ""), 3)
+	assert.Len(t, filterEntries(entries, "school", ""), 1)
+	assert.Len(t, filterEntries(entries, "", EntryRegex), 1)
+	assert.Empty(t, filterEntries(entries, "school", EntryWildcard))
+}
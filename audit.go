@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Audit trail settings.
+const (
+	// auditRecentMax is the number of latest entries kept in memory to show
+	// in the admin UI.
+	auditRecentMax = 100
+	// auditFileMode is the file mode of the audit log file.
+	auditFileMode os.FileMode = 0o600
+)
+
+// Actions recorded in the audit trail.
+const (
+	auditBlockingDisable = "blocking.disable"
+	auditBlockingEnable  = "blocking.enable"
+)
+
+// ============================================================================
+//  Audit Log
+// ============================================================================
+
+// AuditEntry is an action of a user recorded in the audit trail.
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Username string    `json:"username"`
+	Action   string    `json:"action"`
+	// Detail is what the action applied to, such as the duration of a pause.
+	Detail string `json:"detail,omitempty"`
+	// Reason is given by the user for the action.
+	Reason string `json:"reason,omitempty"`
+}
+
+// AuditLog is the audit trail of the actions of the users. Each entry is
+// logged and, if path is set, appended to the file as a JSON line, so that
+// the trail survives a restart and can be read with the usual tools.
+type AuditLog struct {
+	path string
+
+	mu     sync.Mutex
+	recent []AuditEntry
+}
+
+// NewAuditLog returns a new AuditLog. It loads the latest entries of the file
+// if path is set and the file exists.
+func NewAuditLog(path string) (*AuditLog, error) {
+	audit := new(AuditLog)
+	audit.path = path
+
+	if path == "" {
+		return audit, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return audit, nil
+	}
+
+	if err != nil {
+		return nil, wrapError(err, "failed to read audit log file")
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		var entry AuditEntry
+
+		// A line cut by a crash must not prevent the start
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			slog.Warn("skipping broken line of audit log", "path", path)
+
+			continue
+		}
+
+		audit.appendLocked(entry)
+	}
+
+	return audit, wrapError(scanner.Err(), "failed to read audit log file "+path)
+}
+
+// Record logs the entry and appends it to the file. The action must not be
+// done if it fails, so that no action is missing from the trail.
+func (a *AuditLog) Record(entry AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.path != "" {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return wrapError(err, "failed to encode audit entry")
+		}
+
+		err = appendFile(a.path, append(line, '\n'), auditFileMode)
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.Info("audit", "action", entry.Action, "username", entry.Username, "detail", entry.Detail,
+		"reason", entry.Reason)
+
+	a.appendLocked(entry)
+
+	return nil
+}
+
+// Recent returns the latest entries of the actions, newest first.
+func (a *AuditLog) Recent(actions ...string) []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var entries []AuditEntry
+
+	for _, entry := range slices.Backward(a.recent) {
+		if slices.Contains(actions, entry.Action) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// appendLocked keeps the entry in memory, dropping the oldest ones. The caller
+// must hold mu.
+func (a *AuditLog) appendLocked(entry AuditEntry) {
+	if len(a.recent) >= auditRecentMax {
+		a.recent = slices.Delete(a.recent, 0, len(a.recent)-auditRecentMax+1)
+	}
+
+	a.recent = append(a.recent, entry)
+}
+
+// appendFile appends the data to the file, creating it with the mode if
+// missing.
+func appendFile(path string, data []byte, mode os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, mode)
+	if err != nil {
+		return wrapError(err, "failed to open "+path)
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return wrapError(err, "failed to append to "+path)
+}
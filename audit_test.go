@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for AuditLog
+// ============================================================================
+
+func TestAuditLog(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 11, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	audit, err := NewAuditLog(path)
+	require.NoError(t, err)
+	assert.Empty(t, audit.Recent(auditBlockingDisable))
+
+	pause := AuditEntry{Time: now, Username: "parent", Action: auditBlockingDisable, Detail: "5m", Reason: "printer"}
+	resume := AuditEntry{Time: now.Add(time.Minute), Username: "parent", Action: auditBlockingEnable, Detail: "",
+		Reason: ""}
+
+	require.NoError(t, audit.Record(pause))
+	require.NoError(t, audit.Record(resume))
+
+	assert.Equal(t, []AuditEntry{resume, pause}, audit.Recent(auditBlockingDisable, auditBlockingEnable))
+	assert.Equal(t, []AuditEntry{pause}, audit.Recent(auditBlockingDisable))
+
+	if checkFileModeSupported {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, auditFileMode, info.Mode().Perm())
+	}
+
+	// A broken line is skipped on load
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = file.WriteString("{\"time\":\n")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	loaded, err := NewAuditLog(path)
+	require.NoError(t, err)
+	assert.Equal(t, []AuditEntry{resume, pause}, loaded.Recent(auditBlockingDisable, auditBlockingEnable))
+}
+
+func TestAuditLog_memory_only(t *testing.T) {
+	t.Parallel()
+
+	audit, err := NewAuditLog("")
+	require.NoError(t, err)
+
+	for idx := range auditRecentMax + 5 {
+		require.NoError(t, audit.Record(AuditEntry{
+			Time: time.Unix(int64(idx), 0).UTC(), Username: "parent", Action: auditBlockingEnable, Detail: "",
+			Reason: "",
+		}))
+	}
+
+	recent := audit.Recent(auditBlockingEnable)
+	require.Len(t, recent, auditRecentMax)
+	assert.Equal(t, int64(auditRecentMax+4), recent[0].Time.Unix())
+	assert.Equal(t, int64(5), recent[len(recent)-1].Time.Unix())
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// enrollmentTimeout is how long a started enrollment waits for the first code.
+const enrollmentTimeout = 10 * time.Minute
+
+// maxUsernameLength is the maximum length of a username in bytes.
+const maxUsernameLength = 64
+
+// Recovery codes. Each code has 50 bits of entropy in "xxxxx-xxxxx" form.
+const (
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+	recoveryCodeBytes  = 7 // enough random bytes for recoveryCodeLength base32 characters
+)
+
+// Errors of authentication.
+var (
+	ErrAlreadyEnrolled  = errors.New("TOTP is already enrolled")
+	ErrNotEnrolled      = errors.New("TOTP is not enrolled yet")
+	ErrNoEnrollment     = errors.New("no enrollment in progress or it has expired")
+	ErrNoConfigPath     = errors.New("enrollment requires a config file: set --config or " + envConfigPath)
+	ErrInvalidUsername  = errors.New("invalid username")
+	ErrInvalidCode      = errors.New("invalid username or code")
+	ErrUsernameMismatch = errors.New("username does not match the enrollment in progress")
+	ErrCodeReused       = errors.New("the code was already used. Wait for the next code")
+)
+
+// ============================================================================
+//  Authenticator
+// ============================================================================
+
+// Enrollment is a TOTP enrollment in progress. The seed is persisted only
+// after the user proves the authenticator app works by entering a code.
+type Enrollment struct {
+	Username string
+	// URI is the otpauth:// URI to register in the authenticator app.
+	URI string
+	// Secret is the base32 encoded secret for manual entry.
+	Secret string
+
+	seed    string
+	secret  []byte
+	expires time.Time
+}
+
+// Authenticator verifies TOTP codes of the admin users, handles the first-run
+// enrollment and manages the users and their roles.
+//
+// The TOTP secret is never stored. It is derived from the username and the
+// seed in the config file with deriveTOTPSecret.
+type Authenticator struct {
+	configPath string
+
+	mu      sync.Mutex
+	auth    AuthConfig
+	pending *Enrollment
+	// lastCounters are the time step counters of the last accepted codes per
+	// user, to reject a code used again within its window.
+	lastCounters map[string]uint64
+}
+
+// NewAuthenticator returns a new Authenticator for the auth configuration
+// loaded from the config file at configPath. The path is where the seed and
+// the users are saved.
+func NewAuthenticator(configPath string, auth AuthConfig) *Authenticator {
+	authn := new(Authenticator)
+	authn.configPath = configPath
+	authn.auth = auth.migrate()
+	authn.lastCounters = make(map[string]uint64)
+
+	return authn
+}
+
+// Enrolled returns true if the seed exists.
+func (a *Authenticator) Enrolled() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.auth.Seed != ""
+}
+
+// BeginEnrollment starts the enrollment of the user with a new random seed.
+// Only one enrollment can be in progress. Starting a new one discards the
+// previous one.
+func (a *Authenticator) BeginEnrollment(username string, now time.Time) (Enrollment, error) {
+	err := validateUsername(username)
+	if err != nil {
+		return Enrollment{}, err
+	}
+
+	if a.configPath == "" {
+		return Enrollment{}, ErrNoConfigPath
+	}
+
+	seed, err := generateSeed()
+	if err != nil {
+		return Enrollment{}, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.auth.Seed != "" {
+		return Enrollment{}, ErrAlreadyEnrolled
+	}
+
+	secret := deriveTOTPSecret(username, seed)
+
+	enroll := new(Enrollment)
+	enroll.Username = username
+	enroll.URI = otpauthURI(username, secret)
+	enroll.Secret = encodeTOTPSecret(secret)
+	enroll.seed = seed
+	enroll.secret = secret
+	enroll.expires = now.Add(enrollmentTimeout)
+
+	a.pending = enroll
+
+	return *enroll, nil
+}
+
+// PendingEnrollment returns the enrollment in progress.
+func (a *Authenticator) PendingEnrollment(now time.Time) (Enrollment, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.pending == nil || now.After(a.pending.expires) {
+		return Enrollment{}, ErrNoEnrollment
+	}
+
+	return *a.pending, nil
+}
+
+// CompleteEnrollment verifies the first code from the authenticator app and
+// saves the seed and the user as an admin with the hashes of new recovery
+// codes to the config file. It returns the recovery codes to show once.
+func (a *Authenticator) CompleteEnrollment(username, code string, now time.Time) ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.auth.Seed != "" {
+		return nil, ErrAlreadyEnrolled
+	}
+
+	if a.pending == nil || now.After(a.pending.expires) {
+		return nil, ErrNoEnrollment
+	}
+
+	if a.pending.Username != username {
+		return nil, ErrUsernameMismatch
+	}
+
+	counter, ok := verifyTOTP(a.pending.secret, code, now)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes(username, a.pending.seed)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := a.auth
+	auth.Seed = a.pending.seed
+	auth.Users = []UserConfig{{Username: username, Role: RoleAdmin, RecoveryCodes: hashes}}
+
+	err = SaveAuthConfig(a.configPath, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	a.auth = auth
+	a.pending = nil
+	a.lastCounters[username] = counter
+
+	slog.Info("TOTP enrolled", "username", username, "config", a.configPath)
+
+	return codes, nil
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes of the user.
+func (a *Authenticator) RecoveryCodesLeft(username string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	idx := a.userIndexLocked(username)
+	if idx < 0 {
+		return 0
+	}
+
+	return len(a.auth.Users[idx].RecoveryCodes)
+}
+
+// Verify checks the TOTP code of the user. A code is accepted only once, so
+// an observed code cannot be replayed within its window.
+func (a *Authenticator) Verify(username, code string, now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.auth.Seed == "" {
+		return ErrNotEnrolled
+	}
+
+	// A config without users (seed set by hand) accepts any username, since
+	// only the enrolled one derives the secret registered in the app.
+	idx := a.userIndexLocked(username)
+	if len(a.auth.Users) > 0 && idx < 0 {
+		return ErrInvalidCode
+	}
+
+	if isRecoveryCode(code) {
+		if idx < 0 {
+			return ErrInvalidCode
+		}
+
+		return a.useRecoveryCodeLocked(idx, code)
+	}
+
+	counter, ok := verifyTOTP(deriveTOTPSecret(username, a.auth.Seed), code, now)
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	if last, used := a.lastCounters[username]; used && counter <= last {
+		return ErrCodeReused
+	}
+
+	a.lastCounters[username] = counter
+
+	return nil
+}
+
+// useRecoveryCodeLocked accepts an unused recovery code of the user at the
+// index and removes it from the config file. The caller must hold mu.
+func (a *Authenticator) useRecoveryCodeLocked(idx int, code string) error {
+	user := a.auth.Users[idx]
+	hash := hashRecoveryCode(user.Username, a.auth.Seed, code)
+
+	codeIdx := slices.IndexFunc(user.RecoveryCodes, func(stored string) bool {
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1
+	})
+	if codeIdx < 0 {
+		return ErrInvalidCode
+	}
+
+	users := slices.Clone(a.auth.Users)
+	users[idx].RecoveryCodes = slices.Delete(slices.Clone(user.RecoveryCodes), codeIdx, codeIdx+1)
+
+	// Fail closed: a code that cannot be removed from the file could be used
+	// again after a restart.
+	err := a.saveUsersLocked(users)
+	if err != nil {
+		return err
+	}
+
+	slog.Warn("recovery code used", "username", user.Username, "left", len(users[idx].RecoveryCodes))
+
+	return nil
+}
+
+// ============================================================================
+//  Recovery Codes
+// ============================================================================
+
+// generateRecoveryCodes returns new recovery codes of the user and their
+// hashes to save in the config file.
+func generateRecoveryCodes(username, seed string) ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	for idx := range codes {
+		random := make([]byte, recoveryCodeBytes)
+
+		_, err := rand.Read(random)
+		if err != nil {
+			return nil, nil, wrapError(err, "failed to generate recovery code")
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(random))[:recoveryCodeLength]
+		half := recoveryCodeLength / 2 //nolint:mnd // split in two groups for readability
+
+		codes[idx] = code[:half] + "-" + code[half:]
+		hashes[idx] = hashRecoveryCode(username, seed, code)
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode returns the hash of the recovery code to store. The code
+// is bound to the user and the seed, so rotating the seed invalidates it.
+func hashRecoveryCode(username, seed, code string) string {
+	return secureHash(username+normalizeRecoveryCode(code)+seed, 0)
+}
+
+// normalizeRecoveryCode lowercases the code and removes the separators.
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(char rune) rune {
+		if char == '-' || unicode.IsSpace(char) {
+			return -1
+		}
+
+		return unicode.ToLower(char)
+	}, code)
+}
+
+// isRecoveryCode returns true if the code entered in the login form is a
+// recovery code rather than a TOTP code.
+func isRecoveryCode(code string) bool {
+	return len(normalizeRecoveryCode(code)) == recoveryCodeLength
+}
+
+// validateUsername checks that the username is usable as the label of the
+// otpauth URI.
+func validateUsername(username string) error {
+	if username == "" || len(username) > maxUsernameLength {
+		return fmt.Errorf("%w: must be 1 to %d bytes", ErrInvalidUsername, maxUsernameLength)
+	}
+
+	for _, char := range username {
+		if char == ':' || unicode.IsSpace(char) || !unicode.IsPrint(char) {
+			return fmt.Errorf("%w: %q contains a space, colon or control character", ErrInvalidUsername, username)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Titles of the authentication pages.
+const (
+	titleEnroll = "Set up two-factor authentication"
+	titleLogin  = "Sign in"
+	titleHome   = "Alotame"
+	// titleRecovery is the title of the page showing the recovery codes.
+	titleRecovery = "Save your recovery codes"
+)
+
+// ============================================================================
+//  Authentication Handlers
+// ============================================================================
+
+// authHandlers serves the TOTP enrollment, sign-in and sign-out pages.
+type authHandlers struct {
+	authn    *Authenticator
+	sessions *SessionStore
+	limiter  *LoginLimiter
+	blocky   *BlockyClient
+	pages    *pageRenderer
+	now      func() time.Time
+}
+
+// registerAuthHandlers registers the enrollment and login pages to the mux.
+//
+// Until the seed exists in the config file, anyone who can reach the admin
+// server can enroll. Keep the admin server on a trusted address until then.
+func registerAuthHandlers(mux *http.ServeMux, svc *adminServices, pages *pageRenderer) {
+	handlers := &authHandlers{
+		authn: svc.authn, sessions: svc.sessions, limiter: svc.limiter, blocky: svc.blocky, pages: pages, now: svc.now,
+	}
+
+	mux.HandleFunc("GET /admin/enroll", handlers.getEnroll)
+	mux.HandleFunc("POST /admin/enroll", handlers.postEnroll)
+	mux.HandleFunc("GET /admin/enroll/qr.png", handlers.getQRCode)
+	mux.HandleFunc("GET /admin/enroll/qr.svg", handlers.getQRCode)
+	mux.HandleFunc("POST /admin/enroll/confirm", handlers.postEnrollConfirm)
+	mux.HandleFunc("GET /admin/login", handlers.getLogin)
+	mux.HandleFunc("POST /admin/login", handlers.postLogin)
+	mux.HandleFunc("GET /admin/{$}", svc.requireSession(RoleViewer, handlers.getHome))
+	mux.HandleFunc("POST /admin/logout", svc.requireSession(RoleViewer, handlers.postLogout))
+	mux.HandleFunc("POST /admin/logout/all", svc.requireSession(RoleViewer, handlers.postLogoutAll))
+}
+
+func (h *authHandlers) getEnroll(resWriter http.ResponseWriter, req *http.Request) {
+	if h.authn.Enrolled() {
+		http.Redirect(resWriter, req, "/admin/login", http.StatusSeeOther)
+
+		return
+	}
+
+	h.pages.render(resWriter, http.StatusOK, "enroll.html", page{Title: titleEnroll}) //nolint:exhaustruct // optional
+}
+
+func (h *authHandlers) postEnroll(resWriter http.ResponseWriter, req *http.Request) {
+	username := req.PostFormValue("username")
+
+	enroll, err := h.authn.BeginEnrollment(username, h.now())
+
+	switch {
+	case errors.Is(err, ErrAlreadyEnrolled):
+		http.Redirect(resWriter, req, "/admin/login", http.StatusSeeOther)
+	case err != nil:
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrNoConfigPath) {
+			status = http.StatusServiceUnavailable
+		}
+
+		h.pages.render(resWriter, status, "enroll.html", page{ //nolint:exhaustruct // optional
+			Title: titleEnroll, Error: err.Error(), Username: username,
+		})
+	default:
+		h.renderEnrollQR(resWriter, http.StatusOK, enroll, "")
+	}
+}
+
+// getQRCode serves the QR code of the enrollment in progress as PNG or SVG.
+func (h *authHandlers) getQRCode(resWriter http.ResponseWriter, req *http.Request) {
+	enroll, err := h.authn.PendingEnrollment(h.now())
+	if err != nil {
+		http.NotFound(resWriter, req)
+
+		return
+	}
+
+	contentType, encode := "image/png", qrPNG
+	if req.URL.Path == "/admin/enroll/qr.svg" {
+		contentType, encode = "image/svg+xml", qrSVG
+	}
+
+	image, err := encode(enroll.URI)
+	if err != nil {
+		slog.Error("failed to render QR code", "error", err)
+		http.Error(resWriter, "Internal Server Error", http.StatusInternalServerError)
+
+		return
+	}
+
+	resWriter.Header().Set("Content-Type", contentType)
+	resWriter.Header().Set("Cache-Control", "no-store")
+
+	_, err = resWriter.Write(image)
+	if err != nil {
+		slog.Error("failed to write QR code", "error", err)
+	}
+}
+
+func (h *authHandlers) postEnrollConfirm(resWriter http.ResponseWriter, req *http.Request) {
+	now := h.now()
+	username := req.PostFormValue("username")
+
+	codes, err := h.authn.CompleteEnrollment(username, req.PostFormValue("code"), now)
+
+	switch {
+	case err == nil:
+		h.pages.render(resWriter, http.StatusOK, "recovery.html", page{ //nolint:exhaustruct // optional
+			Title: titleRecovery, Notice: "Two-factor authentication is enabled.",
+			Username: username, RecoveryCodes: codes,
+		})
+	case errors.Is(err, ErrAlreadyEnrolled):
+		http.Redirect(resWriter, req, "/admin/login", http.StatusSeeOther)
+	case errors.Is(err, ErrNoEnrollment):
+		h.pages.render(resWriter, http.StatusBadRequest, "enroll.html", page{ //nolint:exhaustruct // optional
+			Title: titleEnroll, Error: err.Error(), Username: username,
+		})
+	default:
+		enroll, pendingErr := h.authn.PendingEnrollment(now)
+		if pendingErr != nil {
+			http.Redirect(resWriter, req, "/admin/enroll", http.StatusSeeOther)
+
+			return
+		}
+
+		status := http.StatusBadRequest
+		if !errors.Is(err, ErrInvalidCode) && !errors.Is(err, ErrUsernameMismatch) {
+			slog.Error("failed to complete TOTP enrollment", "error", err)
+
+			status = http.StatusInternalServerError
+		}
+
+		h.renderEnrollQR(resWriter, status, enroll, err.Error())
+	}
+}
+
+func (h *authHandlers) renderEnrollQR(resWriter http.ResponseWriter, status int, enroll Enrollment, errMsg string) {
+	h.pages.render(resWriter, status, "enroll_qr.html", page{ //nolint:exhaustruct // optional
+		Title: titleEnroll, Error: errMsg, Username: enroll.Username, Secret: enroll.Secret, URI: enroll.URI,
+	})
+}
+
+func (h *authHandlers) getLogin(resWriter http.ResponseWriter, req *http.Request) {
+	if !h.authn.Enrolled() {
+		http.Redirect(resWriter, req, "/admin/enroll", http.StatusSeeOther)
+
+		return
+	}
+
+	h.pages.render(resWriter, http.StatusOK, "login.html", page{Title: titleLogin}) //nolint:exhaustruct // optional
+}
+
+// postLogin verifies the TOTP code and starts a session. Attempts are rate
+// limited per username and per source IP.
+func (h *authHandlers) postLogin(resWriter http.ResponseWriter, req *http.Request) {
+	now := h.now()
+	username := req.PostFormValue("username")
+	keys := loginLimitKeys(username, req.RemoteAddr)
+
+	if wait, ok := h.limiter.Allow(keys, now); !ok {
+		slog.Warn("login rate limited", "username", username, "remote_addr", req.RemoteAddr, "retry_after", wait)
+
+		wait = wait.Round(time.Second) + time.Second
+		resWriter.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+		h.pages.render(resWriter, http.StatusTooManyRequests, "login.html", page{ //nolint:exhaustruct // optional
+			Title: titleLogin, Error: "Too many attempts. Try again in " + wait.String() + ".", Username: username,
+		})
+
+		return
+	}
+
+	err := h.authn.Verify(username, req.PostFormValue("code"), now)
+	if errors.Is(err, ErrNotEnrolled) {
+		http.Redirect(resWriter, req, "/admin/enroll", http.StatusSeeOther)
+
+		return
+	}
+
+	if err != nil {
+		h.limiter.Failure(keys, now)
+
+		slog.Warn("login failed", "username", username, "remote_addr", req.RemoteAddr, "error", err)
+
+		// Do not tell whether the username exists
+		msg := ErrInvalidCode.Error()
+		if errors.Is(err, ErrCodeReused) {
+			msg = err.Error()
+		}
+
+		h.pages.render(resWriter, http.StatusUnauthorized, "login.html", page{ //nolint:exhaustruct // optional
+			Title: titleLogin, Error: msg, Username: username,
+		})
+
+		return
+	}
+
+	h.limiter.Success(keys)
+
+	sessionID, _, err := h.sessions.Create(username, now)
+	if err != nil {
+		slog.Error("failed to create session", "error", err)
+		http.Error(resWriter, "Internal Server Error", http.StatusInternalServerError)
+
+		return
+	}
+
+	slog.Info("login succeeded", "username", username, "remote_addr", req.RemoteAddr)
+
+	setSessionCookie(resWriter, sessionID, h.sessions.maxAge)
+	http.Redirect(resWriter, req, "/admin/", http.StatusSeeOther)
+}
+
+func (h *authHandlers) getHome(resWriter http.ResponseWriter, req *http.Request) {
+	sess, _ := sessionFromContext(req.Context())
+
+	// Only admins see who is locked out
+	var lockouts []Lockout
+	if sess.Role.Allows(RoleAdmin) {
+		lockouts = h.limiter.Lockouts(h.now())
+	}
+
+	h.pages.render(resWriter, http.StatusOK, "home.html", page{ //nolint:exhaustruct // optional
+		Title: titleHome, Username: sess.Username, Role: sess.Role, CSRFToken: sess.CSRFToken,
+		Lockouts: lockouts, RecoveryCodesLeft: h.authn.RecoveryCodesLeft(sess.Username), Blocky: h.blocky.Status(),
+	})
+}
+
+// postLogout ends the current session.
+func (h *authHandlers) postLogout(resWriter http.ResponseWriter, req *http.Request) {
+	cookie, err := req.Cookie(sessionCookieName)
+	if err == nil {
+		h.sessions.Revoke(cookie.Value, h.now())
+	}
+
+	sess, _ := sessionFromContext(req.Context())
+	slog.Info("logout", "username", sess.Username, "remote_addr", req.RemoteAddr)
+
+	clearSessionCookie(resWriter)
+	http.Redirect(resWriter, req, "/admin/login", http.StatusSeeOther)
+}
+
+// postLogoutAll ends all the sessions of the user on every device.
+func (h *authHandlers) postLogoutAll(resWriter http.ResponseWriter, req *http.Request) {
+	sess, _ := sessionFromContext(req.Context())
+	count := h.sessions.RevokeUser(sess.Username, h.now())
+
+	slog.Info("logout everywhere", "username", sess.Username, "sessions", count, "remote_addr", req.RemoteAddr)
+
+	clearSessionCookie(resWriter)
+	http.Redirect(resWriter, req, "/admin/login", http.StatusSeeOther)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for authentication handlers
+// ============================================================================
+
+func TestAuthHandlers_enroll_and_login(t *testing.T) {
+	t.Parallel()
+
+	svc := testAdminServices(t, writeConfigFile(t, `{}`, 0o600), DefaultConfig())
+	clock := time.Unix(1700000000, 0)
+	svc.now = func() time.Time { return clock }
+
+	authn := svc.authn
+	handler := newAdminHandler(new(StaticAllowlistProvider), svc)
+
+	// Not enrolled yet
+	rec := serveAdmin(handler, http.MethodGet, "/admin/login", nil)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/admin/enroll", rec.Header().Get("Location"))
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/enroll/qr.png", nil)
+	require.Equal(t, http.StatusNotFound, rec.Code, "no QR code before enrollment starts")
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/enroll", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "DENY", rec.Header().Get("X-Frame-Options"))
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/enroll", url.Values{"username": {"alice"}})
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `src="/admin/enroll/qr.png"`)
+
+	enroll, err := authn.PendingEnrollment(clock)
+	require.NoError(t, err)
+	assert.Contains(t, rec.Body.String(), enroll.Secret)
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/enroll/qr.png", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/enroll/qr.svg", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/svg+xml", rec.Header().Get("Content-Type"))
+
+	// Confirm with a wrong and then the right code
+	rec = serveAdmin(handler, http.MethodPost, "/admin/enroll/confirm",
+		url.Values{"username": {"alice"}, "code": {wrongCode(enroll.secret, clock)}})
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "invalid username or code")
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/enroll/confirm",
+		url.Values{"username": {"alice"}, "code": {totpCode(enroll.secret, totpCounter(clock))}})
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Two-factor authentication is enabled")
+	assert.Contains(t, rec.Body.String(), "recovery codes")
+	assert.Regexp(t, `<code>[a-z2-7]{5}-[a-z2-7]{5}</code>`, rec.Body.String())
+
+	// Enrollment is closed
+	rec = serveAdmin(handler, http.MethodPost, "/admin/enroll", url.Values{"username": {"mallory"}})
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/admin/login", rec.Header().Get("Location"))
+
+	// The code used for enrollment cannot be used again
+	rec = serveAdmin(handler, http.MethodPost, "/admin/login",
+		url.Values{"username": {"alice"}, "code": {totpCode(enroll.secret, totpCounter(clock))}})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "already used")
+
+	// Login after the backoff of the failure
+	clock = clock.Add(totpPeriod)
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/login",
+		url.Values{"username": {"alice"}, "code": {totpCode(enroll.secret, totpCounter(clock))}})
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/admin/", rec.Header().Get("Location"))
+
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, sessionCookieName, cookies[0].Name)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.True(t, cookies[0].Secure)
+	assert.Equal(t, http.SameSiteStrictMode, cookies[0].SameSite)
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/", nil, cookies[0])
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Signed in as <strong>alice</strong> (admin).")
+	assert.Contains(t, rec.Body.String(), "Recovery codes left: 10.")
+}
+
+func TestAuthHandlers_enroll_invalid_username(t *testing.T) {
+	t.Parallel()
+
+	svc := testAdminServices(t, writeConfigFile(t, `{}`, 0o600), DefaultConfig())
+	handler := newAdminHandler(new(StaticAllowlistProvider), svc)
+
+	rec := serveAdmin(handler, http.MethodPost, "/admin/enroll", url.Values{"username": {"<b>a b</b>"}})
+
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "invalid username")
+	assert.NotContains(t, rec.Body.String(), "<b>", "user input must be escaped")
+}
+
+func TestAuthHandlers_enroll_without_config_path(t *testing.T) {
+	t.Parallel()
+
+	handler := newAdminHandler(new(StaticAllowlistProvider), testAdminServices(t, "", DefaultConfig()))
+
+	rec := serveAdmin(handler, http.MethodPost, "/admin/enroll", url.Values{"username": {"alice"}})
+
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), envConfigPath)
+}
+
+func TestAuthHandlers_home_requires_session(t *testing.T) {
+	t.Parallel()
+
+	handler := newAdminHandler(new(StaticAllowlistProvider), testAdminServices(t, "", DefaultConfig()))
+
+	// Browsers are sent to the login page
+	req := httptest.NewRequest(http.MethodGet, "/admin/", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/admin/login", rec.Header().Get("Location"))
+
+	// Others get 401
+	rec = serveAdmin(handler, http.MethodGet, "/admin/status", nil)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Unknown session
+	rec = serveAdmin(handler, http.MethodGet, "/admin/status", nil,
+		&http.Cookie{Name: sessionCookieName, Value: "forged"}) //nolint:exhaustruct // only name and value are sent
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestAuthHandlers_logout(t *testing.T) {
+	t.Parallel()
+
+	svc := testAdminServices(t, "", testAdminConfig("alice"))
+	handler := newAdminHandler(new(StaticAllowlistProvider), svc)
+
+	cookie := testSignIn(t, svc, "alice")
+	sess, err := svc.sessions.Get(cookie.Value, time.Now())
+	require.NoError(t, err)
+
+	// The CSRF token is required
+	rec := serveAdmin(handler, http.MethodPost, "/admin/logout", url.Values{}, cookie)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/logout", url.Values{csrfFormField: {"wrong"}}, cookie)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/logout", url.Values{csrfFormField: {sess.CSRFToken}}, cookie)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, -1, rec.Result().Cookies()[0].MaxAge, "cookie must be cleared")
+
+	_, err = svc.sessions.Get(cookie.Value, time.Now())
+	require.ErrorIs(t, err, ErrSessionNotFound)
+}
+
+func TestAuthHandlers_logout_all(t *testing.T) {
+	t.Parallel()
+
+	svc := testAdminServices(t, "", testAdminConfig("alice", "bob"))
+	handler := newAdminHandler(new(StaticAllowlistProvider), svc)
+
+	laptop, phone, other := testSignIn(t, svc, "alice"), testSignIn(t, svc, "alice"), testSignIn(t, svc, "bob")
+
+	sess, err := svc.sessions.Get(laptop.Value, time.Now())
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/logout/all", nil)
+	req.Header.Set(csrfHeader, sess.CSRFToken)
+	req.AddCookie(laptop)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+
+	_, err = svc.sessions.Get(phone.Value, time.Now())
+	require.ErrorIs(t, err, ErrSessionNotFound, "other devices must be signed out")
+
+	_, err = svc.sessions.Get(other.Value, time.Now())
+	require.NoError(t, err, "other users must stay signed in")
+}
+
+func TestAuthHandlers_login_rate_limit(t *testing.T) {
+	t.Parallel()
+
+	svc := testAdminServices(t, "", testAdminConfig("alice"))
+
+	clock := time.Unix(1700000000, 0)
+	svc.now = func() time.Time { return clock }
+
+	handler := newAdminHandler(new(StaticAllowlistProvider), svc)
+	secret := deriveTOTPSecret("alice", "seed")
+
+	for range loginLockoutThreshold {
+		rec := serveAdmin(handler, http.MethodPost, "/admin/login",
+			url.Values{"username": {"alice"}, "code": {wrongCode(secret, clock)}})
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		// Wait for the backoff and the token bucket
+		clock = clock.Add(loginBackoffMax)
+	}
+
+	// Locked out even with the right code
+	rec := serveAdmin(handler, http.MethodPost, "/admin/login",
+		url.Values{"username": {"alice"}, "code": {totpCode(secret, totpCounter(clock))}})
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), "Too many attempts")
+
+	// Visible to the signed-in admin
+	rec = serveAdmin(handler, http.MethodGet, "/admin/", nil, testSignIn(t, svc, "alice"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "user:alice")
+}
+
+func TestAdminHandler_cross_origin(t *testing.T) {
+	t.Parallel()
+
+	handler := newAdminHandler(new(StaticAllowlistProvider), testAdminServices(t, "", DefaultConfig()))
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/login", strings.NewReader("username=alice&code=123456"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func serveAdmin(
+	handler http.Handler, method, path string, form url.Values, cookies ...*http.Cookie,
+) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// wrongCode returns a code that is not accepted at the time.
+func wrongCode(secret []byte, now time.Time) string {
+	return totpCode(secret, totpCounter(now)+totpSkew+2)
+}
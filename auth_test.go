@@ -0,0 +1,235 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for Authenticator
+// ============================================================================
+
+func TestAuthenticator_enrollment(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfigFile(t, `{}`, 0o600)
+	authn := NewAuthenticator(path, DefaultConfig().Auth)
+	now := time.Unix(1700000000, 0)
+
+	require.False(t, authn.Enrolled())
+	require.ErrorIs(t, authn.Verify("alice", "000000", now), ErrNotEnrolled)
+
+	enroll, err := authn.BeginEnrollment("alice", now)
+	require.NoError(t, err)
+
+	assert.Equal(t, "alice", enroll.Username)
+	assert.Contains(t, enroll.URI, "otpauth://totp/Alotame:alice?")
+
+	// A wrong code does not enroll
+	_, err = authn.CompleteEnrollment("alice", "000000", now)
+	require.ErrorIs(t, err, ErrInvalidCode)
+	require.False(t, authn.Enrolled())
+
+	code := totpCode(enroll.secret, totpCounter(now))
+
+	_, err = authn.CompleteEnrollment("bob", code, now)
+	require.ErrorIs(t, err, ErrUsernameMismatch)
+
+	codes, err := authn.CompleteEnrollment("alice", code, now)
+	require.NoError(t, err)
+	require.True(t, authn.Enrolled())
+	assert.Len(t, codes, recoveryCodeCount)
+	assert.Equal(t, recoveryCodeCount, authn.RecoveryCodesLeft("alice"))
+
+	// The seed is saved and the secret is derived from it
+	conf, err := LoadConfig(path)
+	require.NoError(t, err)
+
+	require.Len(t, conf.Auth.Users, 1)
+	assert.Equal(t, "alice", conf.Auth.Users[0].Username)
+	assert.Equal(t, RoleAdmin, conf.Auth.Users[0].Role, "the enrolled user is an admin")
+	assert.Equal(t, enroll.secret, deriveTOTPSecret("alice", conf.Auth.Seed))
+	assert.Len(t, conf.Auth.Users[0].RecoveryCodes, recoveryCodeCount)
+	assert.NotContains(t, conf.Auth.Users[0].RecoveryCodes, codes[0], "only the hashes are saved")
+
+	// Logins after restart
+	restarted := NewAuthenticator(path, conf.Auth)
+	later := now.Add(time.Hour)
+	code = totpCode(enroll.secret, totpCounter(later))
+
+	require.NoError(t, restarted.Verify("alice", code, later))
+	require.ErrorIs(t, restarted.Verify("bob", code, later), ErrInvalidCode)
+	require.ErrorIs(t, restarted.Verify("alice", code, later.Add(2*totpPeriod)), ErrInvalidCode)
+
+	_, err = restarted.BeginEnrollment("mallory", later)
+	require.ErrorIs(t, err, ErrAlreadyEnrolled)
+}
+
+func TestAuthenticator_enrollment_expired(t *testing.T) {
+	t.Parallel()
+
+	authn := NewAuthenticator(writeConfigFile(t, `{}`, 0o600), DefaultConfig().Auth)
+	now := time.Unix(1700000000, 0)
+
+	enroll, err := authn.BeginEnrollment("alice", now)
+	require.NoError(t, err)
+
+	later := now.Add(enrollmentTimeout + time.Second)
+	code := totpCode(enroll.secret, totpCounter(later))
+
+	_, err = authn.CompleteEnrollment("alice", code, later)
+	require.ErrorIs(t, err, ErrNoEnrollment)
+
+	_, err = authn.PendingEnrollment(later)
+	require.ErrorIs(t, err, ErrNoEnrollment)
+}
+
+func TestAuthenticator_enrollment_without_config_path(t *testing.T) {
+	t.Parallel()
+
+	authn := NewAuthenticator("", DefaultConfig().Auth)
+
+	_, err := authn.BeginEnrollment("alice", time.Now())
+
+	require.ErrorIs(t, err, ErrNoConfigPath)
+}
+
+func TestAuthenticator_enrollment_save_failure(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "missing-dir", "config.json")
+	authn := NewAuthenticator(path, DefaultConfig().Auth)
+	now := time.Unix(1700000000, 0)
+
+	enroll, err := authn.BeginEnrollment("alice", now)
+	require.NoError(t, err)
+
+	_, err = authn.CompleteEnrollment("alice", totpCode(enroll.secret, totpCounter(now)), now)
+
+	require.Error(t, err)
+	assert.False(t, authn.Enrolled(), "must not be enrolled if the seed is not saved")
+}
+
+func TestAuthenticator_Verify_seed_without_username(t *testing.T) {
+	t.Parallel()
+
+	authn := NewAuthenticator("", testAuthConfig("hand-written-seed"))
+	now := time.Unix(1700000000, 0)
+	code := totpCode(deriveTOTPSecret("alice", "hand-written-seed"), totpCounter(now))
+
+	require.NoError(t, authn.Verify("alice", code, now))
+	require.ErrorIs(t, authn.Verify("bob", code, now), ErrInvalidCode)
+}
+
+func TestAuthenticator_Verify_replay(t *testing.T) {
+	t.Parallel()
+
+	authn := NewAuthenticator("", testAuthConfig("seed", "alice"))
+	secret := deriveTOTPSecret("alice", "seed")
+	now := time.Unix(1700000000, 0)
+	current := totpCounter(now)
+
+	require.NoError(t, authn.Verify("alice", totpCode(secret, current), now))
+	require.ErrorIs(t, authn.Verify("alice", totpCode(secret, current), now), ErrCodeReused)
+	require.ErrorIs(t, authn.Verify("alice", totpCode(secret, current-1), now), ErrCodeReused,
+		"an older code in the window must not be accepted either")
+	require.NoError(t, authn.Verify("alice", totpCode(secret, current+1), now))
+}
+
+func TestAuthenticator_Verify_recovery_code(t *testing.T) {
+	t.Parallel()
+
+	codes, hashes, err := generateRecoveryCodes("alice", "seed")
+	require.NoError(t, err)
+
+	auth := testAuthConfig("seed", "alice")
+	auth.Users[0].RecoveryCodes = hashes
+
+	path := writeConfigFile(t, `{"allowlistPath": "allowlist.txt"}`, 0o600)
+	require.NoError(t, SaveAuthConfig(path, auth))
+
+	authn := NewAuthenticator(path, auth)
+	now := time.Unix(1700000000, 0)
+
+	require.ErrorIs(t, authn.Verify("bob", codes[0], now), ErrInvalidCode)
+	require.ErrorIs(t, authn.Verify("alice", "aaaaa-aaaaa", now), ErrInvalidCode)
+
+	// Case and separators do not matter
+	require.NoError(t, authn.Verify("alice", " "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))+" ", now))
+	assert.Equal(t, recoveryCodeCount-1, authn.RecoveryCodesLeft("alice"))
+
+	// Each code works only once, also after restart
+	require.ErrorIs(t, authn.Verify("alice", codes[0], now), ErrInvalidCode)
+
+	conf, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Len(t, conf.Auth.Users[0].RecoveryCodes, recoveryCodeCount-1)
+	assert.Equal(t, "allowlist.txt", conf.AllowlistPath, "other settings are kept")
+
+	restarted := NewAuthenticator(path, conf.Auth)
+	require.ErrorIs(t, restarted.Verify("alice", codes[0], now), ErrInvalidCode)
+	require.NoError(t, restarted.Verify("alice", codes[1], now))
+
+	// Rotating the seed invalidates the codes
+	rotated := NewAuthenticator("", testAuthConfig("new-seed", "alice"))
+	require.ErrorIs(t, rotated.Verify("alice", codes[2], now), ErrInvalidCode)
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	t.Parallel()
+
+	codes, hashes, err := generateRecoveryCodes("alice", "seed")
+	require.NoError(t, err)
+
+	require.Len(t, codes, recoveryCodeCount)
+	require.Len(t, hashes, recoveryCodeCount)
+
+	for idx, code := range codes {
+		assert.Regexp(t, `^[a-z2-7]{5}-[a-z2-7]{5}$`, code)
+		assert.True(t, isRecoveryCode(code))
+		assert.Equal(t, hashRecoveryCode("alice", "seed", code), hashes[idx])
+	}
+
+	assert.False(t, isRecoveryCode("123456"), "TOTP codes are not recovery codes")
+}
+
+func TestValidateUsername(t *testing.T) {
+	t.Parallel()
+
+	valid := []string{"alice", "alice@example.com", "管理者"}
+	for _, username := range valid {
+		require.NoError(t, validateUsername(username), username)
+	}
+
+	invalid := []string{"", "a:b", "a b", "a\tb", "a\x00b", string(make([]byte, maxUsernameLength+1))}
+	for _, username := range invalid {
+		require.ErrorIs(t, validateUsername(username), ErrInvalidUsername, "%q", username)
+	}
+}
+
+// testAuthConfig returns the default auth configuration with the seed and the
+// users as admins.
+func testAuthConfig(seed string, usernames ...string) AuthConfig {
+	auth := DefaultConfig().Auth
+	auth.Seed = seed
+
+	for _, username := range usernames {
+		auth.Users = append(auth.Users, UserConfig{Username: username, Role: RoleAdmin, RecoveryCodes: nil})
+	}
+
+	return auth
+}
+
+// testAdminConfig returns the default configuration with the seed "seed" and
+// the users as admins.
+func testAdminConfig(usernames ...string) Config {
+	conf := DefaultConfig()
+	conf.Auth = testAuthConfig("seed", usernames...)
+
+	return conf
+}
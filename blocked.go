@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Defaults of the blocked domain review.
+const (
+	// dismissThresholdDefault is the number of new hits after which a
+	// dismissed group is shown again.
+	dismissThresholdDefault = 10
+	// dismissedFileMode is the file mode of the dismissed domains file.
+	dismissedFileMode os.FileMode = 0o600
+)
+
+// ErrBlockedNotFound is returned for a domain not in the blocked domains.
+var ErrBlockedNotFound = errors.New("domain not found in the blocked domains")
+
+// ============================================================================
+//  Allowlist Matcher
+// ============================================================================
+
+// allowlistMatcher tells if a domain is allowed by the entries of an allowlist
+// as Blocky matches them.
+type allowlistMatcher struct {
+	exact     map[string]bool
+	wildcards []string
+	regexes   []*regexp.Regexp
+}
+
+// newAllowlistMatcher returns the matcher of the entries.
+func newAllowlistMatcher(entries []AllowlistEntry) *allowlistMatcher {
+	matcher := &allowlistMatcher{exact: make(map[string]bool, len(entries)), wildcards: nil, regexes: nil}
+
+	for _, entry := range entries {
+		switch entry.Kind {
+		case EntryDomain:
+			matcher.exact[entry.Domain] = true
+		case EntryWildcard:
+			matcher.wildcards = append(matcher.wildcards, strings.TrimPrefix(entry.Domain, wildcardPrefix))
+		case EntryRegex:
+			// Entries are validated on load, so they compile
+			pattern := strings.TrimSuffix(strings.TrimPrefix(entry.Domain, "/"), "/")
+			if expr, err := regexp.Compile(pattern); err == nil {
+				matcher.regexes = append(matcher.regexes, expr)
+			}
+		}
+	}
+
+	return matcher
+}
+
+// Matches returns true if the domain is allowed. A wildcard matches the base
+// domain and all its subdomains.
+func (m *allowlistMatcher) Matches(domain string) bool {
+	if m.exact[domain] {
+		return true
+	}
+
+	for _, base := range m.wildcards {
+		if domain == base || strings.HasSuffix(domain, "."+base) {
+			return true
+		}
+	}
+
+	for _, expr := range m.regexes {
+		if expr.MatchString(domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ============================================================================
+//  Blocked Groups
+// ============================================================================
+
+// BlockedGroup is the blocked domains sharing a registrable domain (eTLD+1),
+// such as "ads.example.com" and "cdn.example.com" for "example.com".
+type BlockedGroup struct {
+	// Registrable is the registrable domain in punycode form.
+	Registrable string
+	// Unicode is the human-readable form of Registrable.
+	Unicode  string
+	Hits     int
+	LastSeen time.Time
+	// Clients are the clients that queried any of the domains.
+	Clients []string
+	// Domains are the blocked domains, the most recently seen first.
+	Domains []BlockedDomain
+	// Dismissed is set if the group was dismissed.
+	Dismissed *Dismissal
+	// Hidden is true if the group was dismissed and has not exceeded the
+	// threshold of new hits since.
+	Hidden bool
+}
+
+// registrableDomain returns the eTLD+1 of the domain, or the domain itself if
+// it has none, such as a public suffix or a single label name.
+func registrableDomain(domain string) string {
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return domain
+	}
+
+	return registrable
+}
+
+// groupBlocked groups the blocked domains not allowed by the matcher by their
+// registrable domain. The groups are sorted by the last seen time of their
+// domains, the most recent first.
+func groupBlocked(domains []BlockedDomain, matcher *allowlistMatcher) []BlockedGroup {
+	groups := make([]BlockedGroup, 0, len(domains))
+	byName := make(map[string]int, len(domains))
+
+	// Domains are sorted by LastSeen, so the first one of a group is the latest
+	for _, blocked := range domains {
+		if matcher.Matches(blocked.Domain) {
+			continue
+		}
+
+		registrable := registrableDomain(blocked.Domain)
+
+		idx, ok := byName[registrable]
+		if !ok {
+			idx = len(groups)
+			byName[registrable] = idx
+			groups = append(groups, BlockedGroup{
+				Registrable: registrable, Unicode: idnToUnicode(registrable), Hits: 0, LastSeen: blocked.LastSeen,
+				Clients: nil, Domains: nil, Dismissed: nil, Hidden: false,
+			})
+		}
+
+		group := &groups[idx]
+		group.Hits += blocked.Hits
+		group.Domains = append(group.Domains, blocked)
+
+		for _, client := range blocked.Clients {
+			if len(group.Clients) < maxBlockedClients && !slices.Contains(group.Clients, client) {
+				group.Clients = append(group.Clients, client)
+			}
+		}
+	}
+
+	return groups
+}
+
+// ============================================================================
+//  Dismissals
+// ============================================================================
+
+// Dismissal is a group of blocked domains dismissed by a user.
+type Dismissal struct {
+	// Hits is the number of hits of the group when dismissed. Lowered when
+	// old hits are dropped from the index.
+	Hits     int       `json:"hits"`
+	Time     time.Time `json:"time"`
+	Username string    `json:"username"`
+}
+
+// DismissalStore keeps the dismissed groups by registrable domain. A dismissed
+// group is hidden until it gets more than the threshold of hits since.
+//
+// If path is set, the dismissals are saved to the file so that they survive a
+// restart.
+type DismissalStore struct {
+	path      string
+	threshold int
+
+	mu        sync.Mutex
+	dismissed map[string]Dismissal
+}
+
+// NewDismissalStore returns a new DismissalStore. It loads the file if path is
+// set and the file exists.
+func NewDismissalStore(path string, threshold int) (*DismissalStore, error) {
+	store := new(DismissalStore)
+	store.path = path
+	store.threshold = threshold
+	store.dismissed = make(map[string]Dismissal)
+
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+
+	if err != nil {
+		return nil, wrapError(err, "failed to read dismissed domains file")
+	}
+
+	err = json.Unmarshal(data, &store.dismissed)
+	if err != nil {
+		return nil, wrapError(err, "failed to parse dismissed domains file "+path)
+	}
+
+	return store, nil
+}
+
+// Dismiss hides the group until it gets more than the threshold of new hits.
+func (s *DismissalStore) Dismiss(group BlockedGroup, username string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, existed := s.dismissed[group.Registrable]
+	s.dismissed[group.Registrable] = Dismissal{Hits: group.Hits, Time: now, Username: username}
+
+	err := s.saveLocked()
+	if err != nil {
+		// Keep the state in sync with the file
+		if existed {
+			s.dismissed[group.Registrable] = prev
+		} else {
+			delete(s.dismissed, group.Registrable)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Apply sets Dismissed and Hidden of the groups.
+//
+// When old hits are dropped from the index, the hits of a group get lower
+// than when it was dismissed. The dismissal is lowered to match, so that only
+// new hits count towards the threshold.
+func (s *DismissalStore) Apply(groups []BlockedGroup) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hits := make(map[string]int, len(groups))
+	changed := false
+
+	for idx := range groups {
+		group := &groups[idx]
+		hits[group.Registrable] = group.Hits
+
+		dismissal, ok := s.dismissed[group.Registrable]
+		if !ok {
+			continue
+		}
+
+		if group.Hits < dismissal.Hits {
+			dismissal.Hits = group.Hits
+			s.dismissed[group.Registrable] = dismissal
+			changed = true
+		}
+
+		group.Dismissed = &dismissal
+		group.Hidden = group.Hits-dismissal.Hits <= s.threshold
+	}
+
+	for name, dismissal := range s.dismissed {
+		if _, ok := hits[name]; !ok && dismissal.Hits != 0 {
+			dismissal.Hits = 0
+			s.dismissed[name] = dismissal
+			changed = true
+		}
+	}
+
+	if changed {
+		err := s.saveLocked()
+		if err != nil {
+			slog.Error("failed to save dismissed domains", "error", err)
+		}
+	}
+}
+
+// saveLocked writes the dismissals to the file if path is set. The caller must
+// hold mu.
+func (s *DismissalStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s.dismissed)
+	if err != nil {
+		return wrapError(err, "failed to encode dismissed domains")
+	}
+
+	return writeFileAtomic(s.path, data, dismissedFileMode)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// Title of the blocked domains page.
+const titleBlocked = "Blocked domains"
+
+// Scopes of the "Allow" actions of a blocked domain.
+const (
+	// allowExact allows the blocked name only.
+	allowExact = "exact"
+	// allowWildcard allows the registrable domain and all its subdomains.
+	allowWildcard = "wildcard"
+)
+
+// ErrAllowScope is returned for an unknown scope of the "Allow" action.
+var ErrAllowScope = errors.New("unknown allow scope: must be exact or wildcard")
+
+// Notices of the blocked domains page after an action, by the "done" parameter.
+var blockedNotices = map[string]string{
+	"allowed":   "The domain is allowed.",
+	"dismissed": "The domain is dismissed.",
+}
+
+// ============================================================================
+//  Blocked Domain Handlers
+// ============================================================================
+
+// blockedPage is the data of the blocked domains page.
+type blockedPage struct {
+	// Enabled is false if reading the query log is disabled.
+	Enabled bool
+	// CanAct is true if the user may allow and dismiss domains.
+	CanAct bool
+	// Editable is false if the allowlist is not a file.
+	Editable bool
+	// ShowDismissed lists the hidden groups too.
+	ShowDismissed bool
+	// Groups are the groups of blocked domains not allowed yet.
+	Groups []BlockedGroup
+	// HiddenCount is the number of dismissed groups not shown.
+	HiddenCount int
+	// Threshold is the number of new hits that shows a dismissed group again.
+	Threshold int
+}
+
+// blockedHandlers serves the review of the domains blocked by Blocky.
+type blockedHandlers struct {
+	svc    *adminServices
+	editor *AllowlistEditor
+	pages  *pageRenderer
+}
+
+// registerBlockedHandlers registers the blocked domains pages to the mux.
+// Anyone signed in can view the blocked domains, and approvers and admins can
+// allow or dismiss them.
+//
+// Allowing adds the entry to the allowlist right away, since the entry is
+// derived from a blocked name and needs no preview, and asks Blocky to refresh
+// its lists.
+func registerBlockedHandlers(mux *http.ServeMux, svc *adminServices, pages *pageRenderer, editor *AllowlistEditor) {
+	handlers := &blockedHandlers{svc: svc, editor: editor, pages: pages}
+
+	mux.HandleFunc("GET /admin/blocked", svc.requireSession(RoleViewer, handlers.getBlocked))
+	mux.HandleFunc("POST /admin/blocked/allow", svc.requireSession(RoleApprover, handlers.postAllow))
+	mux.HandleFunc("POST /admin/blocked/dismiss", svc.requireSession(RoleApprover, handlers.postDismiss))
+}
+
+// getBlocked lists the blocked domains grouped by registrable domain. With the
+// "dismissed" parameter, the dismissed groups are listed too.
+func (h *blockedHandlers) getBlocked(resWriter http.ResponseWriter, req *http.Request) {
+	h.render(resWriter, req, http.StatusOK, "", blockedNotices[req.FormValue("done")])
+}
+
+// postAllow adds the "domain" of the form to the allowlist, either the name
+// itself or a wildcard of its registrable domain by the "scope".
+func (h *blockedHandlers) postAllow(resWriter http.ResponseWriter, req *http.Request) {
+	blocked, err := h.findDomain(req.PostFormValue("domain"))
+	if err != nil {
+		h.renderError(resWriter, req, err)
+
+		return
+	}
+
+	var entry string
+
+	switch req.PostFormValue("scope") {
+	case allowExact:
+		entry = blocked.Domain
+	case allowWildcard:
+		entry = wildcardPrefix + registrableDomain(blocked.Domain)
+	default:
+		h.renderError(resWriter, req, fmt.Errorf("%w: %w", ErrInvalidChange, ErrAllowScope))
+
+		return
+	}
+
+	sess, _ := sessionFromContext(req.Context())
+
+	err = h.editor.Add(req.Context(), entry, "allowed by "+sess.Username+" from blocked "+blocked.Domain)
+	if err != nil {
+		h.renderError(resWriter, req, err)
+
+		return
+	}
+
+	slog.Info("blocked domain allowed", "username", sess.Username, "domain", blocked.Domain, "entry", entry)
+	h.svc.blocky.RequestRefresh()
+
+	http.Redirect(resWriter, req, "/admin/blocked?done=allowed", http.StatusSeeOther)
+}
+
+// postDismiss hides the group of the "registrable" domain of the form until it
+// gets more new hits than the threshold.
+func (h *blockedHandlers) postDismiss(resWriter http.ResponseWriter, req *http.Request) {
+	registrable := req.PostFormValue("registrable")
+	groups := h.groups()
+	idx := -1
+
+	for i := range groups {
+		if groups[i].Registrable == registrable {
+			idx = i
+		}
+	}
+
+	if idx < 0 {
+		h.renderError(resWriter, req, fmt.Errorf("%w: %q", ErrBlockedNotFound, registrable))
+
+		return
+	}
+
+	sess, _ := sessionFromContext(req.Context())
+
+	err := h.svc.dismissals.Dismiss(groups[idx], sess.Username, h.svc.now())
+	if err != nil {
+		h.renderError(resWriter, req, err)
+
+		return
+	}
+
+	slog.Info("blocked domain dismissed", "username", sess.Username, "registrable", registrable)
+
+	http.Redirect(resWriter, req, "/admin/blocked?done=dismissed", http.StatusSeeOther)
+}
+
+// findDomain returns the blocked domain of the name in the index.
+func (h *blockedHandlers) findDomain(name string) (BlockedDomain, error) {
+	domain, err := NormalizeDomain(name)
+	if err != nil {
+		return BlockedDomain{}, fmt.Errorf("%w: %w", ErrInvalidChange, err)
+	}
+
+	for _, blocked := range h.svc.blocked.Domains(h.svc.now()) {
+		if blocked.Domain == domain {
+			return blocked, nil
+		}
+	}
+
+	return BlockedDomain{}, fmt.Errorf("%w: %q", ErrBlockedNotFound, domain)
+}
+
+// groups returns the groups of the blocked domains not allowed by the current
+// allowlist, with their dismissals applied.
+func (h *blockedHandlers) groups() []BlockedGroup {
+	source, _, err := h.editor.Source()
+	if err != nil {
+		slog.Error("failed to read allowlist", "error", err)
+	}
+
+	groups := groupBlocked(h.svc.blocked.Domains(h.svc.now()), newAllowlistMatcher(ParseAllowlist(source).Entries))
+	h.svc.dismissals.Apply(groups)
+
+	return groups
+}
+
+// renderError renders the blocked domains page with the error of the action.
+func (h *blockedHandlers) renderError(resWriter http.ResponseWriter, req *http.Request, err error) {
+	status := http.StatusBadRequest
+
+	switch {
+	case errors.Is(err, ErrBlockedNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrAllowlistChanged):
+		status = http.StatusConflict
+	case errors.Is(err, ErrAllowlistNotEditable):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, ErrInvalidChange):
+	default:
+		slog.Error("failed to act on blocked domain", "error", err)
+
+		status = http.StatusInternalServerError
+	}
+
+	h.render(resWriter, req, status, err.Error(), "")
+}
+
+func (h *blockedHandlers) render(resWriter http.ResponseWriter, req *http.Request, status int, errMsg, notice string) {
+	sess, _ := sessionFromContext(req.Context())
+
+	data := &blockedPage{
+		Enabled:       h.svc.queryLog != nil,
+		CanAct:        sess.Role.Allows(RoleApprover),
+		Editable:      h.editor.Editable(),
+		ShowDismissed: req.FormValue("dismissed") != "",
+		Groups:        nil,
+		HiddenCount:   0,
+		Threshold:     h.svc.dismissals.threshold,
+	}
+
+	for _, group := range h.groups() {
+		if group.Hidden && !data.ShowDismissed {
+			data.HiddenCount++
+
+			continue
+		}
+
+		data.Groups = append(data.Groups, group)
+	}
+
+	h.pages.render(resWriter, status, "blocked.html", page{ //nolint:exhaustruct // optional
+		Title: titleBlocked, Error: errMsg, Notice: notice, Username: sess.Username, Role: sess.Role,
+		CSRFToken: sess.CSRFToken, Blocked: data, Blocky: h.svc.blocky.Status(),
+	})
+}
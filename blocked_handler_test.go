@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for Blocked Domain Handlers
+// ============================================================================
+
+func TestBlockedHandlers(t *testing.T) {
+	t.Parallel()
+
+	path := writeTempFile(t, "example.org\n")
+	prov := NewReloadingAllowlistProvider(NewFileAllowlistProvider(path), nil)
+	svc := testBlockedServices(t, "parent")
+	handler := newAdminHandler(prov, svc)
+
+	admin := testSignIn(t, svc, "parent")
+	csrf := testCSRFToken(t, svc, admin)
+
+	rec := serveAdmin(handler, http.MethodGet, "/admin/blocked", nil, admin)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Registrable domains blocked: 2.")
+	assert.Contains(t, rec.Body.String(), "<code>ads.example.com</code>")
+	assert.Contains(t, rec.Body.String(), "<code>bücher.example</code> <small>(xn--bcher-kva.example)</small>")
+	assert.NotContains(t, rec.Body.String(), "<code>example.org</code>")
+
+	// Allow the exact name
+	form := url.Values{csrfFormField: {csrf}, "domain": {"ads.example.com"}, "scope": {allowExact}}
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/blocked/allow", form, admin)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/admin/blocked?done=allowed", rec.Header().Get("Location"))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "example.org\nads.example.com # allowed by parent from blocked ads.example.com\n", string(data))
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/blocked?done=allowed", nil, admin)
+	assert.Contains(t, rec.Body.String(), "The domain is allowed.")
+	assert.NotContains(t, rec.Body.String(), "<code>ads.example.com</code>")
+	assert.Contains(t, rec.Body.String(), "<code>cdn.example.com</code>")
+
+	// Allow the wildcard of the registrable domain
+	form.Set("domain", "cdn.example.com")
+	form.Set("scope", allowWildcard)
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/blocked/allow", form, admin)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "\n*.example.com # allowed by parent from blocked cdn.example.com\n")
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/blocked", nil, admin)
+	assert.Contains(t, rec.Body.String(), "Registrable domains blocked: 1.")
+
+	// Unknown names and scopes
+	form.Set("domain", "unknown.example.com")
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/blocked/allow", form, admin)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	form.Set("domain", "bücher.example")
+	form.Set("scope", "everything")
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/blocked/allow", form, admin)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrAllowScope.Error())
+}
+
+func TestBlockedHandlers_dismiss(t *testing.T) {
+	t.Parallel()
+
+	svc := testBlockedServices(t, "parent")
+	handler := newAdminHandler(new(StaticAllowlistProvider), svc)
+
+	admin := testSignIn(t, svc, "parent")
+	form := url.Values{csrfFormField: {testCSRFToken(t, svc, admin)}, "registrable": {"example.com"}}
+
+	rec := serveAdmin(handler, http.MethodPost, "/admin/blocked/dismiss", form, admin)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/admin/blocked?done=dismissed", rec.Header().Get("Location"))
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/blocked", nil, admin)
+	assert.Contains(t, rec.Body.String(), "Registrable domains blocked: 2.")
+	assert.Contains(t, rec.Body.String(), "1 dismissed until more than 10 new hits.")
+	assert.NotContains(t, rec.Body.String(), "<code>ads.example.com</code>")
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/blocked?dismissed=1", nil, admin)
+	assert.Contains(t, rec.Body.String(), "<code>ads.example.com</code>")
+	assert.Contains(t, rec.Body.String(), "Dismissed by parent")
+
+	// Shown again after more hits than the threshold
+	for range dismissThresholdDefault + 1 {
+		svc.blocked.Add(testBlockedEntry(svc.now(), "new.example.com", "tablet"))
+	}
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/blocked", nil, admin)
+	assert.Contains(t, rec.Body.String(), "<code>new.example.com</code>")
+	assert.Contains(t, rec.Body.String(), "Dismissed by parent")
+
+	form.Set("registrable", "unknown.example")
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/blocked/dismiss", form, admin)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestBlockedHandlers_read_only(t *testing.T) {
+	t.Parallel()
+
+	conf := testAdminConfig("parent")
+	conf.Auth.Users = append(conf.Auth.Users, UserConfig{Username: "teen", Role: RoleViewer, RecoveryCodes: nil})
+
+	svc := testAdminServices(t, "", conf)
+	handler := newAdminHandler(new(StaticAllowlistProvider), svc)
+
+	teen := testSignIn(t, svc, "teen")
+	form := url.Values{csrfFormField: {testCSRFToken(t, svc, teen)}, "registrable": {"example.com"}}
+
+	rec := serveAdmin(handler, http.MethodGet, "/admin/blocked", nil, teen)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Reading Blocky's query log is disabled.")
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/blocked/dismiss", form, teen)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/blocked/allow", form, teen)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+// testBlockedServices returns the admin services reading an empty CSV query
+// log, with blocked domains of example.com, example.org and bücher.example.
+func testBlockedServices(t *testing.T, usernames ...string) *adminServices {
+	t.Helper()
+
+	conf := testAdminConfig(usernames...)
+	conf.QueryLog.Type = queryLogCSV
+	conf.QueryLog.Target = t.TempDir()
+
+	svc := testAdminServices(t, "", conf)
+	now := svc.now()
+
+	svc.blocked.Add(testBlockedEntry(now.Add(-time.Minute), "ads.example.com", "tablet"))
+	svc.blocked.Add(testBlockedEntry(now.Add(-2*time.Minute), "cdn.example.com", "laptop"))
+	svc.blocked.Add(testBlockedEntry(now.Add(-3*time.Minute), "example.org", "laptop"))
+	svc.blocked.Add(testBlockedEntry(now.Add(-4*time.Minute), "xn--bcher-kva.example", "laptop"))
+
+	return svc
+}
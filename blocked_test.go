@@ -0,0 +1,138 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for allowlistMatcher
+// ============================================================================
+
+func TestAllowlistMatcher(t *testing.T) {
+	t.Parallel()
+
+	parsed := ParseAllowlist([]byte("example.com\n*.example.org\n/^cdn[0-9]+\\.example\\.net$/\n"))
+	matcher := newAllowlistMatcher(parsed.Entries)
+
+	for domain, expect := range map[string]bool{
+		"example.com":       true,
+		"www.example.com":   false,
+		"example.org":       true,
+		"a.b.example.org":   true,
+		"badexample.org":    false,
+		"cdn1.example.net":  true,
+		"cdn.example.net":   false,
+		"tracker.invalid":   false,
+		"example.org.evil.": false,
+	} {
+		assert.Equal(t, expect, matcher.Matches(domain), domain)
+	}
+}
+
+// ============================================================================
+//  Tests for groupBlocked
+// ============================================================================
+
+func TestGroupBlocked(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 11, 12, 0, 0, 0, time.UTC)
+	index := NewBlockedIndex(24 * time.Hour)
+
+	index.Add(testBlockedEntry(now.Add(-time.Minute), "ads.example.com", "tablet"))
+	index.Add(testBlockedEntry(now.Add(-2*time.Minute), "tracker.example.co.uk", "laptop"))
+	index.Add(testBlockedEntry(now.Add(-3*time.Minute), "cdn.example.com", "laptop"))
+	index.Add(testBlockedEntry(now.Add(-4*time.Minute), "cdn.example.com", "laptop"))
+	index.Add(testBlockedEntry(now.Add(-5*time.Minute), "allowed.example.org", "laptop"))
+
+	matcher := newAllowlistMatcher(ParseAllowlist([]byte("*.example.org\n")).Entries)
+	groups := groupBlocked(index.Domains(now), matcher)
+
+	require.Len(t, groups, 2)
+
+	assert.Equal(t, "example.com", groups[0].Registrable)
+	assert.Equal(t, 3, groups[0].Hits)
+	assert.Equal(t, now.Add(-time.Minute), groups[0].LastSeen)
+	assert.Equal(t, []string{"tablet", "laptop"}, groups[0].Clients)
+	require.Len(t, groups[0].Domains, 2)
+	assert.Equal(t, "ads.example.com", groups[0].Domains[0].Domain)
+	assert.Equal(t, "cdn.example.com", groups[0].Domains[1].Domain)
+
+	assert.Equal(t, "example.co.uk", groups[1].Registrable)
+	assert.Equal(t, 1, groups[1].Hits)
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "example.com", registrableDomain("a.b.example.com"))
+	assert.Equal(t, "example.co.uk", registrableDomain("www.example.co.uk"))
+	assert.Equal(t, "co.uk", registrableDomain("co.uk"))
+	assert.Equal(t, "localhost", registrableDomain("localhost"))
+}
+
+// ============================================================================
+//  Tests for DismissalStore
+// ============================================================================
+
+func TestDismissalStore(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 11, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "dismissed.json")
+
+	store, err := NewDismissalStore(path, 5)
+	require.NoError(t, err)
+
+	group := BlockedGroup{ //nolint:exhaustruct // only the keys are used
+		Registrable: "example.com", Hits: 10,
+	}
+	require.NoError(t, store.Dismiss(group, "parent", now))
+
+	// Hidden up to the threshold of new hits
+	for hits, hidden := range map[int]bool{10: true, 15: true, 16: false} {
+		groups := []BlockedGroup{group}
+		groups[0].Hits = hits
+
+		store.Apply(groups)
+		require.NotNil(t, groups[0].Dismissed)
+		assert.Equal(t, "parent", groups[0].Dismissed.Username)
+		assert.Equal(t, hidden, groups[0].Hidden, hits)
+	}
+
+	// Dismissals survive a restart
+	store, err = NewDismissalStore(path, 5)
+	require.NoError(t, err)
+
+	groups := []BlockedGroup{group}
+	store.Apply(groups)
+	assert.True(t, groups[0].Hidden)
+
+	// Dropped hits lower the dismissal, so that only new hits count
+	groups[0].Hits = 2
+	store.Apply(groups)
+	assert.True(t, groups[0].Hidden)
+
+	groups[0].Hits = 8
+	store.Apply(groups)
+	assert.False(t, groups[0].Hidden)
+
+	// Groups dropped from the index start over from zero hits
+	store.Apply(nil)
+
+	groups[0].Hits = 5
+	store.Apply(groups)
+	assert.True(t, groups[0].Hidden)
+}
+
+func TestNewDismissalStore_invalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewDismissalStore(writeTempFile(t, "{broken"), dismissThresholdDefault)
+	require.Error(t, err)
+}
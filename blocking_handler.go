@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Title of the blocking page.
+const titleBlocking = "Blocking"
+
+// Limits of a pause of blocking.
+const (
+	blockingMaxPauseDefault = time.Hour
+	// blockingReasonMax is the maximum length of the reason of a pause.
+	blockingReasonMax = 200
+)
+
+// pauseDurations are the durations offered on the blocking page, up to the
+// maximum pause.
+var pauseDurations = []time.Duration{5 * time.Minute, 15 * time.Minute, 30 * time.Minute, time.Hour}
+
+// Errors of the blocking actions.
+var (
+	ErrBlockyNotConfigured = errors.New("no Blocky instance is configured")
+	ErrPauseDuration       = errors.New("invalid pause duration")
+	ErrPauseReason         = errors.New("a reason of up to 200 characters in a single line is required")
+	ErrBlockingChange      = errors.New("failed to change blocking of Blocky")
+)
+
+// Notices of the blocking page after an action, by the "done" parameter.
+var blockingNotices = map[string]string{
+	"paused":  "Blocking is paused.",
+	"resumed": "Blocking is resumed.",
+}
+
+// ============================================================================
+//  Blocking Handlers
+// ============================================================================
+
+// blockingPage is the data of the blocking page.
+type blockingPage struct {
+	// Enabled is false if no Blocky instance is configured.
+	Enabled bool
+	// CanAct is true if the user may pause and resume blocking.
+	CanAct bool
+	// Instances are the Blocky instances with their blocking state.
+	Instances []blockingInstance
+	// Durations are the pause durations to choose from.
+	Durations []string
+	// History is the latest pauses and resumes, newest first.
+	History []AuditEntry
+}
+
+// blockingInstance is the blocking state of a Blocky instance.
+type blockingInstance struct {
+	BlockyStatus
+
+	// Paused is true if Blocky reported that blocking is disabled.
+	Paused bool
+	// Remaining is the time left until Blocky resumes blocking by itself.
+	Remaining time.Duration
+}
+
+// blockingHandlers serves the pause and resume of blocking on Blocky, so that
+// a family member can get a few minutes of open internet without a shell on
+// the server.
+type blockingHandlers struct {
+	svc   *adminServices
+	pages *pageRenderer
+}
+
+// registerBlockingHandlers registers the blocking page to the mux. Anyone
+// signed in can view the state, and approvers and admins can pause and resume
+// blocking.
+func registerBlockingHandlers(mux *http.ServeMux, svc *adminServices, pages *pageRenderer) {
+	handlers := &blockingHandlers{svc: svc, pages: pages}
+
+	mux.HandleFunc("GET /admin/blocking", svc.requireSession(RoleViewer, handlers.getBlocking))
+	mux.HandleFunc("POST /admin/blocking/disable", svc.requireSession(RoleApprover, handlers.postDisable))
+	mux.HandleFunc("POST /admin/blocking/enable", svc.requireSession(RoleApprover, handlers.postEnable))
+}
+
+func (h *blockingHandlers) getBlocking(resWriter http.ResponseWriter, req *http.Request) {
+	h.render(resWriter, req, http.StatusOK, "", blockingNotices[req.FormValue("done")])
+}
+
+// postDisable pauses blocking on all the instances for the "duration" of the
+// form. The "reason" is required and recorded in the audit trail before the
+// pause, so that a failed attempt is recorded too.
+func (h *blockingHandlers) postDisable(resWriter http.ResponseWriter, req *http.Request) {
+	duration, err := h.parseDuration(req.PostFormValue("duration"))
+	if err != nil {
+		h.renderError(resWriter, req, err)
+
+		return
+	}
+
+	reason, err := parseReason(req.PostFormValue("reason"))
+	if err != nil {
+		h.renderError(resWriter, req, err)
+
+		return
+	}
+
+	err = h.record(req, AuditEntry{
+		Time: h.svc.now(), Username: "", Action: auditBlockingDisable, Detail: shortDuration(duration), Reason: reason,
+	})
+	if err != nil {
+		h.renderError(resWriter, req, err)
+
+		return
+	}
+
+	err = h.svc.blocky.DisableBlocking(req.Context(), duration)
+	if err != nil {
+		h.renderError(resWriter, req, fmt.Errorf("%w: %w", ErrBlockingChange, err))
+
+		return
+	}
+
+	http.Redirect(resWriter, req, "/admin/blocking?done=paused", http.StatusSeeOther)
+}
+
+// postEnable resumes blocking on all the instances before the end of the
+// pause.
+func (h *blockingHandlers) postEnable(resWriter http.ResponseWriter, req *http.Request) {
+	err := h.record(req, AuditEntry{
+		Time: h.svc.now(), Username: "", Action: auditBlockingEnable, Detail: "", Reason: "",
+	})
+	if err != nil {
+		h.renderError(resWriter, req, err)
+
+		return
+	}
+
+	err = h.svc.blocky.EnableBlocking(req.Context())
+	if err != nil {
+		h.renderError(resWriter, req, fmt.Errorf("%w: %w", ErrBlockingChange, err))
+
+		return
+	}
+
+	http.Redirect(resWriter, req, "/admin/blocking?done=resumed", http.StatusSeeOther)
+}
+
+// record records the action of the signed-in user in the audit trail.
+func (h *blockingHandlers) record(req *http.Request, entry AuditEntry) error {
+	if !h.svc.blocky.Enabled() {
+		return ErrBlockyNotConfigured
+	}
+
+	sess, _ := sessionFromContext(req.Context())
+	entry.Username = sess.Username
+
+	return h.svc.audit.Record(entry)
+}
+
+// parseDuration parses the duration of a pause, which must be positive and up
+// to the maximum pause.
+func (h *blockingHandlers) parseDuration(value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 || duration > h.svc.maxPause {
+		return 0, fmt.Errorf("%w: %q must be up to %s", ErrPauseDuration, value, shortDuration(h.svc.maxPause))
+	}
+
+	return duration, nil
+}
+
+// parseReason returns the trimmed reason of a pause. It must be a single line
+// so that it stays a single line in the logs.
+func parseReason(value string) (string, error) {
+	reason := strings.TrimSpace(value)
+
+	if reason == "" || utf8.RuneCountInString(reason) > blockingReasonMax ||
+		strings.ContainsFunc(reason, unicode.IsControl) {
+		return "", ErrPauseReason
+	}
+
+	return reason, nil
+}
+
+// shortDuration formats the duration without the trailing zero units, such as
+// "5m" instead of "5m0s".
+func shortDuration(duration time.Duration) string {
+	str := duration.String()
+
+	if strings.HasSuffix(str, "m0s") {
+		str = strings.TrimSuffix(str, "0s")
+	}
+
+	if strings.HasSuffix(str, "h0m") {
+		str = strings.TrimSuffix(str, "0m")
+	}
+
+	return str
+}
+
+// renderError renders the blocking page with the error of the action.
+func (h *blockingHandlers) renderError(resWriter http.ResponseWriter, req *http.Request, err error) {
+	status := http.StatusBadRequest
+
+	switch {
+	case errors.Is(err, ErrBlockyNotConfigured):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, ErrBlockingChange):
+		status = http.StatusBadGateway
+	case errors.Is(err, ErrPauseDuration), errors.Is(err, ErrPauseReason):
+	default:
+		slog.Error("failed to change blocking", "error", err)
+
+		status = http.StatusInternalServerError
+	}
+
+	h.render(resWriter, req, status, err.Error(), "")
+}
+
+func (h *blockingHandlers) render(resWriter http.ResponseWriter, req *http.Request, status int, errMsg, notice string) {
+	sess, _ := sessionFromContext(req.Context())
+	now := h.svc.now()
+
+	data := &blockingPage{
+		Enabled:   h.svc.blocky.Enabled(),
+		CanAct:    sess.Role.Allows(RoleApprover),
+		Instances: nil,
+		Durations: nil,
+		History:   h.svc.audit.Recent(auditBlockingDisable, auditBlockingEnable),
+	}
+
+	for _, instance := range h.svc.blocky.Status() {
+		paused := instance.State == BlockyReachable && !instance.BlockingEnabled
+		remaining := time.Duration(0)
+
+		if paused && !instance.AutoEnable.IsZero() {
+			remaining = max(instance.AutoEnable.Sub(now).Round(time.Second), 0)
+		}
+
+		data.Instances = append(data.Instances, blockingInstance{
+			BlockyStatus: instance, Paused: paused, Remaining: remaining,
+		})
+	}
+
+	durations := slices.DeleteFunc(slices.Clone(pauseDurations), func(d time.Duration) bool {
+		return d >= h.svc.maxPause
+	})
+
+	for _, duration := range append(durations, h.svc.maxPause) {
+		data.Durations = append(data.Durations, shortDuration(duration))
+	}
+
+	h.pages.render(resWriter, status, "blocking.html", page{ //nolint:exhaustruct // optional
+		Title: titleBlocking, Error: errMsg, Notice: notice, Username: sess.Username, Role: sess.Role,
+		CSRFToken: sess.CSRFToken, Blocking: data,
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for Blocking Handlers
+// ============================================================================
+
+func TestBlockingHandlers(t *testing.T) {
+	t.Parallel()
+
+	blocky := newFakeBlocky(t, 0)
+
+	conf := testAdminConfig("parent")
+	conf.Blocky.URLs = []string{blocky.URL}
+	conf.Blocky.CanaryDomain = ""
+	conf.AuditLogPath = filepath.Join(t.TempDir(), "audit.jsonl")
+
+	svc := testAdminServices(t, "", conf)
+	handler := newAdminHandler(new(StaticAllowlistProvider), svc)
+
+	admin := testSignIn(t, svc, "parent")
+	form := url.Values{
+		csrfFormField: {testCSRFToken(t, svc, admin)}, "duration": {"5m"}, "reason": {"  fixing the printer "},
+	}
+
+	rec := serveAdmin(handler, http.MethodPost, "/admin/blocking/disable", form, admin)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/admin/blocking?done=paused", rec.Header().Get("Location"))
+	assert.Equal(t, 5*time.Minute, blocky.paused())
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/blocking?done=paused", nil, admin)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Blocking is paused.")
+	assert.Contains(t, rec.Body.String(), "paused until ")
+	assert.Contains(t, rec.Body.String(), "data-countdown=")
+	assert.Contains(t, rec.Body.String(), "<td>paused for 5m</td>\n<td>fixing the printer</td>")
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/blocking/enable", url.Values{csrfFormField: form[csrfFormField]},
+		admin)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/admin/blocking?done=resumed", rec.Header().Get("Location"))
+	assert.Zero(t, blocky.paused())
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/blocking", nil, admin)
+	assert.Contains(t, rec.Body.String(), `class="notice">enabled</td>`)
+
+	// Both actions are in the audit file
+	file, err := os.Open(conf.AuditLogPath)
+	require.NoError(t, err)
+
+	defer file.Close()
+
+	var actions []string
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		assert.Equal(t, "parent", entry.Username)
+
+		actions = append(actions, entry.Action+" "+entry.Detail+" "+entry.Reason)
+	}
+
+	assert.Equal(t, []string{"blocking.disable 5m fixing the printer", "blocking.enable  "}, actions)
+}
+
+func TestBlockingHandlers_invalid(t *testing.T) {
+	t.Parallel()
+
+	blocky := newFakeBlocky(t, 0)
+
+	conf := testAdminConfig("parent")
+	conf.Blocky.URLs = []string{blocky.URL}
+
+	svc := testAdminServices(t, "", conf)
+	handler := newAdminHandler(new(StaticAllowlistProvider), svc)
+
+	admin := testSignIn(t, svc, "parent")
+	csrf := testCSRFToken(t, svc, admin)
+
+	for _, test := range []struct {
+		duration string
+		reason   string
+		expect   error
+	}{
+		{duration: "5m", reason: " ", expect: ErrPauseReason},
+		{duration: "5m", reason: "first line\nsecond line", expect: ErrPauseReason},
+		{duration: "5m", reason: strings.Repeat("x", blockingReasonMax+1), expect: ErrPauseReason},
+		{duration: "2h", reason: "movie night", expect: ErrPauseDuration},
+		{duration: "-5m", reason: "movie night", expect: ErrPauseDuration},
+		{duration: "forever", reason: "movie night", expect: ErrPauseDuration},
+	} {
+		form := url.Values{csrfFormField: {csrf}, "duration": {test.duration}, "reason": {test.reason}}
+
+		rec := serveAdmin(handler, http.MethodPost, "/admin/blocking/disable", form, admin)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, test.duration+" "+test.reason)
+		assert.Contains(t, rec.Body.String(), test.expect.Error())
+	}
+
+	assert.Zero(t, blocky.paused())
+	assert.Empty(t, svc.audit.Recent(auditBlockingDisable))
+
+	// Blocky fails to pause
+	blocky.setDown(true)
+
+	form := url.Values{csrfFormField: {csrf}, "duration": {"1h"}, "reason": {"movie night"}}
+
+	rec := serveAdmin(handler, http.MethodPost, "/admin/blocking/disable", form, admin)
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrBlockingChange.Error())
+	assert.Len(t, svc.audit.Recent(auditBlockingDisable), 1, "the attempt must be recorded")
+}
+
+func TestBlockingHandlers_read_only(t *testing.T) {
+	t.Parallel()
+
+	conf := testAdminConfig("parent")
+	conf.Auth.Users = append(conf.Auth.Users, UserConfig{Username: "teen", Role: RoleViewer, RecoveryCodes: nil})
+
+	svc := testAdminServices(t, "", conf)
+	handler := newAdminHandler(new(StaticAllowlistProvider), svc)
+
+	teen := testSignIn(t, svc, "teen")
+	form := url.Values{csrfFormField: {testCSRFToken(t, svc, teen)}, "duration": {"5m"}, "reason": {"homework"}}
+
+	rec := serveAdmin(handler, http.MethodGet, "/admin/blocking", nil, teen)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "No Blocky instance is configured.")
+	assert.NotContains(t, rec.Body.String(), "Pause blocking")
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/blocking/disable", form, teen)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	parent := testSignIn(t, svc, "parent")
+	form.Set(csrfFormField, testCSRFToken(t, svc, parent))
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/blocking/disable", form, parent)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+// ============================================================================
+//  Tests for shortDuration
+// ============================================================================
+
+func TestShortDuration(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "5m", shortDuration(5*time.Minute))
+	assert.Equal(t, "1h", shortDuration(time.Hour))
+	assert.Equal(t, "1h30m", shortDuration(90*time.Minute))
+	assert.Equal(t, "1m30s", shortDuration(90*time.Second))
+	assert.Equal(t, "45s", shortDuration(45*time.Second))
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the Blocky API client.
+const (
+	blockyTimeoutDefault       = 5 * time.Second
+	blockyRetriesDefault       = 3
+	blockyProbeIntervalDefault = 30 * time.Second
+	blockyDNSPortDefault       = "53"
+	blockyCanaryDefault        = "example.com"
+	// blockyRetryDelay is the wait before the first retry and the first probe
+	// after a failure. It doubles on each failure up to blockyMaxBackoff.
+	blockyRetryDelay = time.Second
+	// blockyMaxBackoff is the longest wait between the probes of an
+	// unreachable instance.
+	blockyMaxBackoff = 5 * time.Minute
+	// blockyMaxErrorBody is the size of the response body kept in the error.
+	blockyMaxErrorBody = 200
+	// blockyMaxBody is the maximum size of a response body read.
+	blockyMaxBody = 64 << 10
+)
+
+// Endpoints of the HTTP API of Blocky.
+const (
+	// blockyRefreshPath reloads the allow and deny lists.
+	blockyRefreshPath = "/api/lists/refresh"
+	// blockyStatusPath returns the blocking status.
+	blockyStatusPath = "/api/blocking/status"
+	// blockyDisablePath pauses blocking for the duration of the query.
+	blockyDisablePath = "/api/blocking/disable"
+	// blockyEnablePath resumes blocking.
+	blockyEnablePath = "/api/blocking/enable"
+)
+
+// BlockyState is the reachability of a Blocky instance.
+type BlockyState string
+
+// States of a Blocky instance.
+const (
+	// BlockyUnknown is the state until the first probe.
+	BlockyUnknown BlockyState = "unknown"
+	// BlockyReachable is the state after the API and DNS answered.
+	BlockyReachable BlockyState = "reachable"
+	// BlockyUnreachable is the state after a failed probe or request.
+	BlockyUnreachable BlockyState = "unreachable"
+)
+
+// ErrBlockyStatus is returned if Blocky responds with a non-2xx status.
+var ErrBlockyStatus = errors.New("unexpected response from Blocky")
+
+// ============================================================================
+//  Blocky Instance Status
+// ============================================================================
+
+// BlockyStatus is the state of a Blocky instance.
+type BlockyStatus struct {
+	// URL is the base URL of the HTTP API of the instance.
+	URL string
+	// DNSAddr is the address probed with the canary domain. Empty if the DNS
+	// probe is disabled.
+	DNSAddr string
+	// State is the reachability after the latest probe or request.
+	State BlockyState
+	// BlockingEnabled is the blocking status reported by the latest probe or
+	// set by the latest pause or resume.
+	BlockingEnabled bool
+	// AutoEnable is when Blocky resumes blocking after a pause. Zero if
+	// blocking is enabled or paused without a limit.
+	AutoEnable time.Time
+	// LastProbe is the time of the latest probe.
+	LastProbe time.Time
+	// LastReachable is the time the instance last answered.
+	LastReachable time.Time
+	// LastError is the error of the latest probe or request. Nil if
+	// succeeded.
+	LastError error
+	// Failures is the number of consecutive failures.
+	Failures int
+	// NextProbe is the time of the next probe.
+	NextProbe time.Time
+
+	// Pending is true from a refresh request until it is confirmed. Requests
+	// to an unreachable instance stay pending until it is reachable again.
+	Pending bool
+	// LastRequest is the time of the latest refresh request.
+	LastRequest time.Time
+	// LastConfirmed is the time Blocky last confirmed a refresh.
+	LastConfirmed time.Time
+}
+
+// Confirmed returns true if Blocky confirmed the latest refresh request.
+func (s BlockyStatus) Confirmed() bool {
+	return !s.Pending && !s.LastConfirmed.IsZero() && !s.LastConfirmed.Before(s.LastRequest)
+}
+
+// ============================================================================
+//  Blocky Client
+// ============================================================================
+
+// BlockyClient watches the Blocky instances and asks them to refresh their
+// lists, so that allowlist changes apply without waiting for the
+// refreshPeriod of Blocky.
+//
+// Run probes each instance at the interval: the blocking status of the API
+// and, if a canary domain is set, a DNS query. After a failure, the instance
+// is probed again with an exponential backoff.
+//
+// Refresh requests are queued per instance. A request made while a refresh is
+// running is merged into the next one, and a request to an unreachable
+// instance is sent once it answers a probe again.
+//
+// Blocking can also be paused and resumed on all the instances at once.
+type BlockyClient struct {
+	client   *http.Client
+	timeout  time.Duration
+	retries  int
+	interval time.Duration
+	canary   string
+	// retryDelay is the wait before the first retry and the first probe
+	// after a failure. Replaced in tests.
+	retryDelay time.Duration
+	// now returns the current time. Replaced in tests.
+	now func() time.Time
+	// triggers wake up the worker of each instance, in the order of status.
+	triggers []chan struct{}
+
+	mu     sync.Mutex
+	status []BlockyStatus
+}
+
+// NewBlockyClient returns the client of the Blocky instances of the config.
+// It does nothing if no instance is configured.
+func NewBlockyClient(conf BlockyConfig) *BlockyClient {
+	client := new(BlockyClient)
+	client.client = &http.Client{Timeout: time.Duration(conf.Timeout)} //nolint:exhaustruct // defaults
+	client.timeout = time.Duration(conf.Timeout)
+	client.retries = conf.Retries
+	client.interval = time.Duration(conf.ProbeInterval)
+	client.canary = conf.CanaryDomain
+	client.retryDelay = blockyRetryDelay
+	client.now = time.Now
+	client.triggers = make([]chan struct{}, len(conf.URLs))
+	client.status = make([]BlockyStatus, len(conf.URLs))
+
+	for idx, base := range conf.URLs {
+		client.triggers[idx] = make(chan struct{}, 1)
+		client.status[idx] = BlockyStatus{ //nolint:exhaustruct // zero times until the first probe
+			URL: strings.TrimSuffix(base, "/"), State: BlockyUnknown,
+		}
+
+		if conf.CanaryDomain != "" {
+			client.status[idx].DNSAddr = blockyDNSAddr(base, conf.DNSPort)
+		}
+	}
+
+	return client
+}
+
+// blockyDNSAddr returns the DNS address of the instance of the API URL.
+func blockyDNSAddr(base, port string) string {
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+
+	return net.JoinHostPort(parsed.Hostname(), port)
+}
+
+// Enabled returns true if any Blocky instance is configured.
+func (c *BlockyClient) Enabled() bool {
+	return len(c.status) > 0
+}
+
+// Status returns the state of the instances in the configured order.
+func (c *BlockyClient) Status() []BlockyStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.status) == 0 {
+		return nil
+	}
+
+	status := make([]BlockyStatus, len(c.status))
+	copy(status, c.status)
+
+	return status
+}
+
+// RequestRefresh queues a refresh of all the instances and returns at once.
+// The refresh is sent by Run.
+func (c *BlockyClient) RequestRefresh() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+
+	for idx := range c.status {
+		c.status[idx].Pending = true
+		c.status[idx].LastRequest = now
+
+		select {
+		case c.triggers[idx] <- struct{}{}:
+		default: // a refresh is already queued
+		}
+	}
+}
+
+// Run probes the instances and sends the queued refresh requests until the
+// context is canceled.
+func (c *BlockyClient) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for idx := range c.triggers {
+		wg.Go(func() { c.watch(ctx, idx) })
+	}
+
+	wg.Wait()
+}
+
+// watch probes the instance when due and sends its refresh requests.
+func (c *BlockyClient) watch(ctx context.Context, idx int) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.triggers[idx]:
+			// Wait for the next probe if unreachable, so that a down
+			// instance is not flooded. The request may have been sent after
+			// a probe already.
+			if c.state(idx) != BlockyUnreachable && c.pending(idx) {
+				_ = c.refreshInstance(ctx, idx)
+			}
+		case <-timer.C:
+			err := c.Probe(ctx, idx)
+			if err == nil && c.pending(idx) {
+				_ = c.refreshInstance(ctx, idx)
+			}
+		}
+
+		timer.Reset(max(c.untilNextProbe(idx), 0))
+	}
+}
+
+// Probe checks the API and the DNS of the instance and records the result.
+func (c *BlockyClient) Probe(ctx context.Context, idx int) error {
+	c.mu.Lock()
+	base, dnsAddr := c.status[idx].URL, c.status[idx].DNSAddr
+	c.mu.Unlock()
+
+	blocking, err := c.getBlockingStatus(ctx, base)
+	if err == nil && dnsAddr != "" {
+		err = c.resolveCanary(ctx, dnsAddr)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := &c.status[idx]
+	status.LastProbe = c.now()
+
+	if err == nil {
+		status.BlockingEnabled = blocking.Enabled
+		status.AutoEnable = time.Time{}
+
+		if !blocking.Enabled && blocking.AutoEnableInSec > 0 {
+			status.AutoEnable = status.LastProbe.Add(time.Duration(blocking.AutoEnableInSec) * time.Second)
+		}
+	}
+
+	c.recordLocked(idx, err)
+
+	return err
+}
+
+// DisableBlocking pauses blocking on all the instances for the duration, and
+// returns the errors of the instances that failed. Blocky resumes blocking by
+// itself after the duration, so a pause never lasts longer even if Alotame
+// stops.
+func (c *BlockyClient) DisableBlocking(ctx context.Context, duration time.Duration) error {
+	query := url.Values{"duration": {duration.String()}}
+
+	return c.setBlocking(ctx, blockyDisablePath+"?"+query.Encode(), false, duration)
+}
+
+// EnableBlocking resumes blocking on all the instances, and returns the errors
+// of the instances that failed.
+func (c *BlockyClient) EnableBlocking(ctx context.Context) error {
+	return c.setBlocking(ctx, blockyEnablePath, true, 0)
+}
+
+// setBlocking sends the request of the blocking state to all the instances at
+// once and records the results. Unlike refreshes, the request is not retried
+// nor queued, since a pause applied later than asked would be a surprise.
+func (c *BlockyClient) setBlocking(ctx context.Context, path string, enabled bool, duration time.Duration) error {
+	c.mu.Lock()
+	bases := make([]string, len(c.status))
+
+	for idx := range c.status {
+		bases[idx] = c.status[idx].URL
+	}
+	c.mu.Unlock()
+
+	errs := make([]error, len(bases))
+
+	var wg sync.WaitGroup
+
+	for idx, base := range bases {
+		wg.Go(func() {
+			// Blocky serves the blocking endpoints with GET
+			_, err := c.do(ctx, http.MethodGet, base+path)
+			errs[idx] = c.recordBlocking(idx, err, enabled, duration)
+		})
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// recordBlocking records the result of a blocking request to the instance.
+func (c *BlockyClient) recordBlocking(idx int, err error, enabled bool, duration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := &c.status[idx]
+
+	if errors.Is(err, ErrBlockyStatus) {
+		// Blocky answered, so it is still reachable
+		status.LastError = err
+	} else {
+		c.recordLocked(idx, err)
+	}
+
+	if err != nil {
+		slog.Warn("failed to change Blocky blocking", "url", status.URL, "enabled", enabled, "error", err)
+
+		return wrapError(err, status.URL)
+	}
+
+	status.BlockingEnabled = enabled
+	status.AutoEnable = time.Time{}
+
+	if duration > 0 {
+		status.AutoEnable = c.now().Add(duration)
+	}
+
+	return nil
+}
+
+// refreshInstance sends the refresh request to the instance with retries and
+// records the result. The request stays pending if it fails.
+func (c *BlockyClient) refreshInstance(ctx context.Context, idx int) error {
+	c.mu.Lock()
+	base := c.status[idx].URL
+	requested := c.status[idx].LastRequest
+	c.mu.Unlock()
+
+	delay := c.retryDelay
+
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		err = c.postRefresh(ctx, base)
+		if err == nil || attempt >= c.retries || ctx.Err() != nil {
+			break
+		}
+
+		slog.Debug("retrying Blocky refresh", "url", base, "attempt", attempt+1, "error", err)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if errors.Is(err, ErrBlockyStatus) {
+		// Blocky answered but failed to refresh, such as when it cannot
+		// download the lists. The request is sent again after the next probe.
+		c.status[idx].LastError = err
+	} else {
+		c.recordLocked(idx, err)
+	}
+
+	if err != nil {
+		slog.Warn("failed to refresh Blocky lists, retrying after the next probe", "url", base, "error", err)
+
+		return wrapError(err, base)
+	}
+
+	status := &c.status[idx]
+	status.LastConfirmed = c.now()
+	// Requests made during the refresh are sent again
+	status.Pending = status.LastRequest.After(requested)
+
+	slog.Info("Blocky lists refreshed", "url", base)
+
+	return nil
+}
+
+// recordLocked updates the state of the instance with the result of a probe
+// or a request. The caller must hold mu.
+func (c *BlockyClient) recordLocked(idx int, err error) {
+	status := &c.status[idx]
+	now := c.now()
+	previous := status.State
+
+	status.LastError = err
+
+	if err != nil {
+		status.State = BlockyUnreachable
+		status.Failures++
+	} else {
+		status.State = BlockyReachable
+		status.Failures = 0
+		status.LastReachable = now
+	}
+
+	status.NextProbe = now.Add(backoff(status.Failures, c.retryDelay, blockyMaxBackoff, c.interval))
+
+	switch {
+	case status.State == BlockyUnreachable && previous != BlockyUnreachable:
+		slog.Warn("Blocky is unreachable", "url", status.URL, "error", err)
+	case status.State == BlockyReachable && previous == BlockyUnreachable:
+		slog.Info("Blocky is reachable again", "url", status.URL)
+	}
+}
+
+func (c *BlockyClient) state(idx int) BlockyState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.status[idx].State
+}
+
+func (c *BlockyClient) pending(idx int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.status[idx].Pending
+}
+
+func (c *BlockyClient) untilNextProbe(idx int) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.status[idx].NextProbe.Sub(c.now())
+}
+
+// ============================================================================
+//  Requests
+// ============================================================================
+
+// blockingStatus is the response of GET /api/blocking/status.
+type blockingStatus struct {
+	Enabled bool `json:"enabled"`
+	// AutoEnableInSec is the time left of a pause. Zero if enabled or paused
+	// without a limit.
+	AutoEnableInSec int `json:"autoEnableInSec"`
+}
+
+// postRefresh sends a single refresh request to the instance.
+func (c *BlockyClient) postRefresh(ctx context.Context, base string) error {
+	_, err := c.do(ctx, http.MethodPost, base+blockyRefreshPath)
+
+	return err
+}
+
+// getBlockingStatus returns the blocking status of the instance.
+func (c *BlockyClient) getBlockingStatus(ctx context.Context, base string) (blockingStatus, error) {
+	var status blockingStatus
+
+	body, err := c.do(ctx, http.MethodGet, base+blockyStatusPath)
+	if err != nil {
+		return status, err
+	}
+
+	err = json.Unmarshal(body, &status)
+	if err != nil {
+		return status, wrapError(err, "failed to parse Blocky blocking status")
+	}
+
+	return status, nil
+}
+
+// resolveCanary queries the canary domain to the DNS of the instance. Any
+// answer, including NXDOMAIN, means the DNS is up.
+func (c *BlockyClient) resolveCanary(ctx context.Context, addr string) error {
+	resolver := &net.Resolver{ //nolint:exhaustruct // defaults
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, network, addr)
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	_, err := resolver.LookupHost(ctx, c.canary)
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil
+	}
+
+	return wrapError(err, "failed to resolve "+c.canary+" with Blocky DNS "+addr)
+}
+
+// do sends the request to Blocky and returns the response body.
+func (c *BlockyClient) do(ctx context.Context, method, target string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, http.NoBody)
+	if err != nil {
+		return nil, wrapError(err, "failed to create Blocky request")
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, wrapError(err, "failed to request Blocky")
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, blockyMaxErrorBody))
+
+		return nil, fmt.Errorf("%w: %s: %s", ErrBlockyStatus, res.Status, strings.TrimSpace(string(body)))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, blockyMaxBody))
+	if err != nil {
+		return nil, wrapError(err, "failed to read Blocky response")
+	}
+
+	return body, nil
+}
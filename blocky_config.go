@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// cmdBlockyConfig is the name of the subcommand to print the blocking section
+// of the Blocky config.
+const cmdBlockyConfig = "blocky-config"
+
+// Groups of the generated Blocky config. List names start with a letter or a
+// digit, so these never collide with them.
+const (
+	// blockyGroupCatchAll is the denylist blocking every domain.
+	blockyGroupCatchAll = "_catch-all"
+	// blockyGroupMain is the allowlist served at /allowlist.txt.
+	blockyGroupMain = "_main"
+)
+
+// blockyDefaultClient is the client group of Blocky for the clients in no
+// other client group.
+const blockyDefaultClient = "default"
+
+// blockyCatchAll is the inline denylist entry matching every domain.
+const blockyCatchAll = "/.*/"
+
+// blockyPlaceholderURL is the URL of the public server in the generated config
+// if it listens on a unix socket and publicURL is not set. The ".invalid" TLD
+// never resolves, so Blocky fails loudly until it is replaced.
+const blockyPlaceholderURL = "http://alotame.invalid"
+
+// ============================================================================
+//  Blocky Config
+// ============================================================================
+
+// runBlockyConfig runs "alotame blocky-config". It prints the blocking section
+// of the Blocky config for the effective config, with the same flags and
+// environment variables as the server so that the URLs are the served ones.
+func runBlockyConfig(args []string, getenv func(string) string, output io.Writer) error {
+	opts, err := parseCommandLine(args, getenv, output)
+	if err != nil {
+		return err
+	}
+
+	_, err = output.Write(BlockyBlockingConfig(opts.Config))
+
+	return wrapError(err, "failed to print Blocky config")
+}
+
+// BlockyBlockingConfig returns the "blocking:" section of the config.yml of
+// Blocky serving the lists to their clients.
+//
+// Each client group gets the catch-all denylist and the allowlists of its
+// lists, so that only the allowed domains resolve whatever the other groups
+// are. The default client group gets the main allowlist unless a list is
+// for the "default" clients.
+func BlockyBlockingConfig(conf Config) []byte {
+	baseURL, exact := publicBaseURL(conf)
+	clients, groups := blockyClientGroups(conf.Lists)
+
+	var out bytes.Buffer
+
+	out.WriteString("# Generated by \"alotame " + cmdBlockyConfig + "\". Replace the blocking section of the\n")
+	out.WriteString("# config.yml of Blocky with it, and keep the other settings such as loading.\n")
+
+	if !exact {
+		out.WriteString("# Set publicURL in the config of Alotame to the URL Blocky reaches it at.\n")
+
+		if conf.Server.UnixSocket != "" {
+			out.WriteString("# Alotame listens on a unix socket, so the URLs below are a placeholder.\n")
+		} else {
+			out.WriteString("# The URLs below are a guess.\n")
+		}
+	}
+
+	out.WriteString("blocking:\n")
+	out.WriteString("  # Everything is blocked unless an allowlist of the client group allows it\n")
+	out.WriteString("  denylists:\n")
+	out.WriteString("    " + blockyGroupCatchAll + ":\n")
+	out.WriteString("      - |\n")
+	out.WriteString("        " + blockyCatchAll + "\n")
+	out.WriteString("  allowlists:\n")
+
+	if slices.Contains(groups[blockyDefaultClient], blockyGroupMain) {
+		out.WriteString("    " + blockyGroupMain + ":\n")
+		out.WriteString("      - " + strconv.Quote(baseURL+"/allowlist.txt") + "\n")
+	}
+
+	var unused []string
+
+	for _, list := range conf.Lists {
+		if len(list.Clients) == 0 {
+			if !list.Base {
+				unused = append(unused, list.Name)
+			}
+
+			continue
+		}
+
+		out.WriteString("    " + list.Name + ":\n")
+		out.WriteString("      - " + strconv.Quote(baseURL+listsPathPrefix+list.Name+listsPathSuffix) + "\n")
+	}
+
+	if len(unused) > 0 {
+		out.WriteString("    # Lists without clients: " + strings.Join(unused, ", ") + "\n")
+	}
+
+	out.WriteString("  clientGroupsBlock:\n")
+
+	for _, client := range clients {
+		out.WriteString("    " + strconv.Quote(client) + ":\n")
+		out.WriteString("      - " + blockyGroupCatchAll + "\n")
+
+		for _, group := range groups[client] {
+			out.WriteString("      - " + group + "\n")
+		}
+	}
+
+	return out.Bytes()
+}
+
+// blockyClientGroups returns the clients of the lists and the groups of each.
+// The default clients come first with the main allowlist if no list is for
+// them, and the others in the configured order.
+func blockyClientGroups(lists []ListConfig) ([]string, map[string][]string) {
+	clients := []string{blockyDefaultClient}
+	groups := make(map[string][]string)
+
+	for _, list := range lists {
+		for _, client := range list.Clients {
+			if _, ok := groups[client]; !ok && client != blockyDefaultClient {
+				clients = append(clients, client)
+			}
+
+			groups[client] = append(groups[client], list.Name)
+		}
+	}
+
+	if len(groups[blockyDefaultClient]) == 0 {
+		groups[blockyDefaultClient] = []string{blockyGroupMain}
+	}
+
+	return clients, groups
+}
+
+// publicBaseURL returns the URL of the public server for Blocky, without the
+// trailing slash. It returns false if publicURL is not set and the URL is
+// guessed from the listen address, or is blockyPlaceholderURL for a unix
+// socket.
+func publicBaseURL(conf Config) (string, bool) {
+	if conf.PublicURL != "" {
+		return strings.TrimSuffix(conf.PublicURL, "/"), true
+	}
+
+	// Blocky cannot fetch from a unix socket, so only a proxy in front of it
+	// knows the URL
+	if conf.Server.UnixSocket != "" {
+		return blockyPlaceholderURL, false
+	}
+
+	scheme := "http"
+	if conf.Server.IsTLS() {
+		scheme = "https"
+	}
+
+	host := conf.Server.Host
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host = "localhost"
+	}
+
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, conf.Server.Port)), false
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for BlockyBlockingConfig
+// ============================================================================
+
+func TestBlockyBlockingConfig(t *testing.T) {
+	t.Parallel()
+
+	kids := testListConfig("kids", "kids.txt", "base")
+	kids.Clients = []string{"192.168.1.10", "tablet"}
+
+	adults := testListConfig("adults", "adults.txt", "base")
+	adults.Clients = []string{"192.168.1.0/24", "tablet"}
+
+	base := testListConfig("base", "base.txt")
+	base.Base = true
+
+	conf := DefaultConfig()
+	conf.PublicURL = "http://alotame:5963/"
+	conf.Lists = []ListConfig{base, kids, adults, testListConfig("iot", "iot.txt")}
+
+	assert.Equal(t, `# Generated by "alotame blocky-config". Replace the blocking section of the
+# config.yml of Blocky with it, and keep the other settings such as loading.
+blocking:
+  # Everything is blocked unless an allowlist of the client group allows it
+  denylists:
+    _catch-all:
+      - |
+        /.*/
+  allowlists:
+    _main:
+      - "http://alotame:5963/allowlist.txt"
+    kids:
+      - "http://alotame:5963/lists/kids.txt"
+    adults:
+      - "http://alotame:5963/lists/adults.txt"
+    # Lists without clients: iot
+  clientGroupsBlock:
+    "default":
+      - _catch-all
+      - _main
+    "192.168.1.10":
+      - _catch-all
+      - kids
+    "tablet":
+      - _catch-all
+      - kids
+      - adults
+    "192.168.1.0/24":
+      - _catch-all
+      - adults
+`, string(BlockyBlockingConfig(conf)))
+}
+
+func TestBlockyBlockingConfig_default_list(t *testing.T) {
+	t.Parallel()
+
+	family := testListConfig("family", "family.txt")
+	family.Clients = []string{"default"}
+
+	conf := DefaultConfig()
+	conf.Server.Port = "8080"
+	conf.Lists = []ListConfig{family}
+
+	generated := string(BlockyBlockingConfig(conf))
+
+	// The main allowlist is not used if a list is for the default clients
+	assert.NotContains(t, generated, "_main")
+	assert.Contains(t, generated, "    family:\n      - \"http://localhost:8080/lists/family.txt\"\n")
+	assert.Contains(t, generated, "    \"default\":\n      - _catch-all\n      - family\n")
+	assert.Contains(t, generated, "# Set publicURL")
+}
+
+// ============================================================================
+//  Tests for publicBaseURL
+// ============================================================================
+
+func TestPublicBaseURL(t *testing.T) {
+	t.Parallel()
+
+	conf := DefaultConfig()
+
+	baseURL, exact := publicBaseURL(conf)
+	assert.Equal(t, "http://localhost:5963", baseURL)
+	assert.False(t, exact)
+
+	conf.Server.Host = "::1"
+	conf.Server.TLSCert, conf.Server.TLSKey = "cert.pem", "key.pem"
+
+	baseURL, _ = publicBaseURL(conf)
+	assert.Equal(t, "https://[::1]:5963", baseURL)
+
+	// The port is usually not set with a unix socket
+	conf.Server.UnixSocket, conf.Server.Port = "/run/alotame/alotame.sock", ""
+
+	baseURL, exact = publicBaseURL(conf)
+	assert.Equal(t, blockyPlaceholderURL, baseURL)
+	assert.False(t, exact)
+	assert.Contains(t, string(BlockyBlockingConfig(conf)), "# Alotame listens on a unix socket")
+
+	conf.PublicURL = "https://dns.example.home/alotame"
+
+	baseURL, exact = publicBaseURL(conf)
+	assert.Equal(t, "https://dns.example.home/alotame", baseURL)
+	assert.True(t, exact)
+}
+
+// ============================================================================
+//  Tests for runBlockyConfig
+// ============================================================================
+
+func TestRunBlockyConfig(t *testing.T) {
+	t.Parallel()
+
+	configPath := writeConfigFile(t, `{"lists": [{"name": "kids", "path": "kids.txt", "clients": ["tablet"]}]}`, 0o600)
+	env := fakeEnv(map[string]string{envConfigPath: configPath, "ALOTAME_PUBLIC_URL": "http://alotame:5963"})
+	output := new(bytes.Buffer)
+
+	require.NoError(t, runBlockyConfig(nil, env, output))
+	assert.Contains(t, output.String(), "    kids:\n      - \"http://alotame:5963/lists/kids.txt\"\n")
+	assert.NotContains(t, output.String(), "# Set publicURL")
+
+	err := runBlockyConfig([]string{"--public-url", "blocky"}, env, new(bytes.Buffer))
+	require.ErrorIs(t, err, ErrConfigInvalid)
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ============================================================================
+//  Tests for BlockyClient
+// ============================================================================
+
+func TestBlockyClient_RequestRefresh(t *testing.T) {
+	t.Parallel()
+
+	healthy := newFakeBlocky(t, 0)
+	flaky := newFakeBlocky(t, 2)
+
+	client := testBlockyClient(healthy.URL+"/", flaky.URL)
+	require.True(t, client.Enabled())
+
+	runBlockyClient(t, client)
+	client.RequestRefresh()
+
+	assert.Eventually(t, func() bool {
+		for _, instance := range client.Status() {
+			if !instance.Confirmed() {
+				return false
+			}
+		}
+
+		return true
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, 1, healthy.refreshes())
+	assert.Equal(t, 3, flaky.refreshes(), "failed requests must be retried")
+
+	status := client.Status()
+	require.Len(t, status, 2)
+	assert.Equal(t, healthy.URL, status[0].URL)
+
+	for _, instance := range status {
+		assert.False(t, instance.Pending)
+		assert.Equal(t, BlockyReachable, instance.State)
+	}
+}
+
+func TestBlockyClient_RequestRefresh_failure(t *testing.T) {
+	t.Parallel()
+
+	broken := newFakeBlocky(t, blockyRetriesDefault+1)
+	client := testBlockyClient(broken.URL)
+
+	runBlockyClient(t, client)
+
+	// Probed first, so that the next probe does not send the request again
+	assert.Eventually(t, func() bool { return client.Status()[0].State == BlockyReachable }, time.Second,
+		time.Millisecond)
+
+	client.RequestRefresh()
+
+	assert.Eventually(t, func() bool { return client.Status()[0].LastError != nil }, time.Second, time.Millisecond)
+
+	// Blocky answered, so the request stays pending on a reachable instance
+	status := client.Status()
+	require.Len(t, status, 1)
+	assert.Equal(t, blockyRetriesDefault+1, broken.refreshes())
+	assert.False(t, status[0].Confirmed())
+	assert.True(t, status[0].Pending)
+	assert.NotEqual(t, BlockyUnreachable, status[0].State)
+	require.ErrorIs(t, status[0].LastError, ErrBlockyStatus)
+	assert.Contains(t, status[0].LastError.Error(), "list refresh failed")
+
+	client.RequestRefresh()
+
+	assert.Eventually(t, func() bool { return client.Status()[0].Confirmed() }, time.Second, time.Millisecond)
+}
+
+func TestBlockyClient_Probe(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 11, 12, 0, 0, 0, time.UTC)
+	blocky := newFakeBlocky(t, 0)
+	client := testBlockyClient(blocky.URL)
+	client.now = func() time.Time { return now }
+
+	assert.Equal(t, BlockyUnknown, client.Status()[0].State)
+
+	require.NoError(t, client.Probe(context.Background(), 0))
+
+	status := client.Status()[0]
+	assert.Equal(t, BlockyReachable, status.State)
+	assert.True(t, status.BlockingEnabled)
+	assert.Equal(t, now, status.LastReachable)
+	assert.Equal(t, now.Add(blockyProbeIntervalDefault), status.NextProbe)
+
+	// Failures are probed again with a backoff
+	blocky.setDown(true)
+
+	for failures, delay := range []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond} {
+		require.ErrorIs(t, client.Probe(context.Background(), 0), ErrBlockyStatus)
+
+		status = client.Status()[0]
+		assert.Equal(t, BlockyUnreachable, status.State)
+		assert.Equal(t, failures+1, status.Failures)
+		assert.Equal(t, now.Add(delay), status.NextProbe)
+		assert.Equal(t, now, status.LastReachable)
+	}
+
+	blocky.setDown(false)
+
+	require.NoError(t, client.Probe(context.Background(), 0))
+	assert.Equal(t, BlockyReachable, client.Status()[0].State)
+	assert.Zero(t, client.Status()[0].Failures)
+}
+
+func TestBlockyClient_Probe_dns(t *testing.T) {
+	t.Parallel()
+
+	blocky := newFakeBlocky(t, 0)
+
+	for _, test := range []struct {
+		canary string
+		rcode  dnsmessage.RCode
+		expect BlockyState
+	}{
+		{canary: "example.com", rcode: dnsmessage.RCodeSuccess, expect: BlockyReachable},
+		{canary: "missing.example", rcode: dnsmessage.RCodeNameError, expect: BlockyReachable},
+		{canary: "example.com", rcode: dnsmessage.RCodeServerFailure, expect: BlockyUnreachable},
+	} {
+		port := startFakeDNS(t, test.rcode)
+
+		conf := DefaultConfig().Blocky
+		conf.URLs = []string{blocky.URL}
+		conf.DNSPort = port
+		conf.CanaryDomain = test.canary
+
+		client := NewBlockyClient(conf)
+		require.Equal(t, net.JoinHostPort("127.0.0.1", port), client.Status()[0].DNSAddr)
+
+		_ = client.Probe(context.Background(), 0)
+		assert.Equal(t, test.expect, client.Status()[0].State, test.rcode.String())
+	}
+}
+
+func TestBlockyClient_DisableBlocking(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 11, 12, 0, 0, 0, time.UTC)
+	first := newFakeBlocky(t, 0)
+	second := newFakeBlocky(t, 0)
+	client := testBlockyClient(first.URL, second.URL)
+	client.now = func() time.Time { return now }
+
+	require.NoError(t, client.DisableBlocking(context.Background(), 5*time.Minute))
+
+	for idx, fake := range []*fakeBlocky{first, second} {
+		assert.Equal(t, 5*time.Minute, fake.paused())
+
+		status := client.Status()[idx]
+		assert.False(t, status.BlockingEnabled)
+		assert.Equal(t, now.Add(5*time.Minute), status.AutoEnable)
+		assert.Equal(t, BlockyReachable, status.State)
+	}
+
+	// The probe reports the time left of the pause
+	require.NoError(t, client.Probe(context.Background(), 0))
+	assert.False(t, client.Status()[0].BlockingEnabled)
+	assert.Equal(t, now.Add(5*time.Minute), client.Status()[0].AutoEnable)
+
+	require.NoError(t, client.EnableBlocking(context.Background()))
+
+	for idx, fake := range []*fakeBlocky{first, second} {
+		assert.Zero(t, fake.paused())
+		assert.True(t, client.Status()[idx].BlockingEnabled)
+		assert.Zero(t, client.Status()[idx].AutoEnable)
+	}
+}
+
+func TestBlockyClient_DisableBlocking_failure(t *testing.T) {
+	t.Parallel()
+
+	healthy := newFakeBlocky(t, 0)
+	down := newFakeBlocky(t, 0)
+	down.setDown(true)
+
+	client := testBlockyClient(healthy.URL, down.URL)
+
+	err := client.DisableBlocking(context.Background(), time.Minute)
+
+	require.ErrorIs(t, err, ErrBlockyStatus)
+	assert.Contains(t, err.Error(), down.URL)
+
+	// The pause is not retried, and the other instances are paused anyway
+	assert.Equal(t, time.Minute, healthy.paused())
+	assert.False(t, client.Status()[0].BlockingEnabled)
+	require.ErrorIs(t, client.Status()[1].LastError, ErrBlockyStatus)
+}
+
+func TestBlockyClient_Run(t *testing.T) {
+	t.Parallel()
+
+	blocky := newFakeBlocky(t, 0)
+	blocky.setDown(true)
+
+	client := testBlockyClient(blocky.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		client.Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return client.Status()[0].State == BlockyUnreachable }, time.Second,
+		time.Millisecond)
+
+	// The request is queued while Blocky is unreachable
+	client.RequestRefresh()
+	assert.True(t, client.Status()[0].Pending)
+	assert.Zero(t, blocky.refreshes())
+
+	// and sent once it is reachable again
+	blocky.setDown(false)
+
+	assert.Eventually(t, func() bool { return client.Status()[0].Confirmed() }, time.Second, time.Millisecond)
+	assert.Equal(t, 1, blocky.refreshes())
+	assert.Equal(t, BlockyReachable, client.Status()[0].State)
+
+	cancel()
+	<-done
+}
+
+func TestBlockyClient_disabled(t *testing.T) {
+	t.Parallel()
+
+	client := NewBlockyClient(DefaultConfig().Blocky)
+
+	assert.False(t, client.Enabled())
+	assert.Nil(t, client.Status())
+
+	// Nothing to watch
+	client.RequestRefresh()
+	client.Run(context.Background())
+}
+
+// ============================================================================
+//  Test Helpers
+// ============================================================================
+
+// fakeBlocky is a Blocky HTTP API counting the list refresh requests and
+// keeping the blocking state.
+type fakeBlocky struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// failures is the number of refresh requests to fail before succeeding.
+	failures int
+	count    int
+	// down fails all the requests as if Blocky is not ready.
+	down bool
+	// pause is the duration of the latest pause of blocking. Zero if enabled.
+	pause time.Duration
+}
+
+// newFakeBlocky starts a fake Blocky failing the first refresh requests.
+func newFakeBlocky(t *testing.T, failures int) *fakeBlocky {
+	t.Helper()
+
+	fake := &fakeBlocky{Server: nil, failures: failures, count: 0, down: false, pause: 0}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+blockyStatusPath, func(resWriter http.ResponseWriter, _ *http.Request) {
+		pause := fake.paused()
+
+		resWriter.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(resWriter, `{"enabled":%t,"disabledGroups":[],"autoEnableInSec":%d}`,
+			pause == 0, int(pause.Seconds()))
+	})
+	mux.HandleFunc("GET "+blockyDisablePath, func(resWriter http.ResponseWriter, req *http.Request) {
+		pause, err := time.ParseDuration(req.FormValue("duration"))
+		if err != nil {
+			http.Error(resWriter, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+
+		fake.pause = pause
+	})
+	mux.HandleFunc("GET "+blockyEnablePath, func(http.ResponseWriter, *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+
+		fake.pause = 0
+	})
+	mux.HandleFunc("POST "+blockyRefreshPath, func(resWriter http.ResponseWriter, _ *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+
+		fake.count++
+
+		if fake.count <= fake.failures {
+			http.Error(resWriter, "list refresh failed", http.StatusInternalServerError)
+		}
+	})
+
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(resWriter http.ResponseWriter, req *http.Request) {
+		fake.mu.Lock()
+		down := fake.down
+		fake.mu.Unlock()
+
+		if down {
+			http.Error(resWriter, "starting", http.StatusServiceUnavailable)
+
+			return
+		}
+
+		mux.ServeHTTP(resWriter, req)
+	}))
+	t.Cleanup(fake.Close)
+
+	return fake
+}
+
+// setDown makes the fake fail all the requests or serve again.
+func (f *fakeBlocky) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.down = down
+}
+
+// refreshes returns the number of refresh requests received.
+func (f *fakeBlocky) refreshes() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.count
+}
+
+// paused returns the duration of the latest pause of blocking.
+func (f *fakeBlocky) paused() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.pause
+}
+
+// testBlockyClient returns a client of the URLs without the DNS probe,
+// retrying without delay.
+func testBlockyClient(urls ...string) *BlockyClient {
+	conf := DefaultConfig().Blocky
+	conf.URLs = urls
+	conf.CanaryDomain = ""
+
+	client := NewBlockyClient(conf)
+	client.retryDelay = time.Millisecond
+
+	return client
+}
+
+// runBlockyClient runs the client until the end of the test.
+func runBlockyClient(t *testing.T, client *BlockyClient) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		client.Run(ctx)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// startFakeDNS starts a UDP DNS server on 127.0.0.1 answering every query
+// with the response code, and returns its port.
+func startFakeDNS(t *testing.T, rcode dnsmessage.RCode) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+
+		for {
+			size, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var query dnsmessage.Message
+			if query.Unpack(buf[:size]) != nil || len(query.Questions) == 0 {
+				continue
+			}
+
+			answer := dnsmessage.Message{ //nolint:exhaustruct // no authorities nor additionals
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, RCode: rcode}, //nolint:exhaustruct // flags
+				Questions: query.Questions,
+			}
+
+			question := query.Questions[0]
+			if rcode == dnsmessage.RCodeSuccess && question.Type == dnsmessage.TypeA {
+				answer.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{ //nolint:exhaustruct // length is set by Pack
+						Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60,
+					},
+					Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+				}}
+			}
+
+			packed, err := answer.Pack()
+			if err == nil {
+				_, _ = conn.WriteTo(packed, addr)
+			}
+		}
+	}()
+
+	_, port, err := net.SplitHostPort(conn.LocalAddr().String())
+	require.NoError(t, err)
+
+	return port
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redacted replaces secret values in the printed config.
+const redacted = "[REDACTED]"
+
+// Names of the flags that are not a config value.
+const (
+	flagConfig      = "config"
+	flagPrintConfig = "print-config"
+)
+
+// ============================================================================
+//  Settings
+// ============================================================================
+
+// setting is a config value that can be set by a CLI flag or an environment
+// variable.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	// boolean is true if the flag is a switch that needs no value, such as
+	// "--tls-self-signed".
+	boolean bool
+	apply   func(conf *Config, value string) error
+}
+
+// settings are the config values settable by CLI flags and environment
+// variables. The precedence is: flag > env > config file > DefaultConfig.
+var settings = []setting{
+	{
+		flag: "host", env: "ALOTAME_HOST", usage: "host to listen on", boolean: false,
+		apply: func(conf *Config, value string) error {
+			conf.Server.Host = value
+
+			return nil
+		},
+	},
+	{
+		flag: "port", env: "ALOTAME_PORT", usage: "port to listen on", boolean: false,
+		apply: func(conf *Config, value string) error {
+			conf.Server.Port = value
+
+			return nil
+		},
+	},
+	{
+		flag: "allowlist", env: envAllowlistPath, usage: "path of the allowlist file", boolean: false,
+		apply: func(conf *Config, value string) error {
+			conf.AllowlistPath = value
+
+			return nil
+		},
+	},
+	{
+		flag: "public-url", env: "ALOTAME_PUBLIC_URL", boolean: false,
+		usage: "URL Blocky reaches the public server at, for blocky-config (e.g. http://alotame:5963)",
+		apply: func(conf *Config, value string) error {
+			conf.PublicURL = value
+
+			return nil
+		},
+	},
+	{
+		flag: "unix-socket", env: "ALOTAME_UNIX_SOCKET", usage: "path of the unix socket to listen on instead of TCP",
+		boolean: false,
+		apply: func(conf *Config, value string) error {
+			conf.Server.UnixSocket = value
+
+			return nil
+		},
+	},
+	{
+		flag: "unix-socket-mode", env: "ALOTAME_UNIX_SOCKET_MODE", usage: "file mode of the unix socket (e.g. 0660)",
+		boolean: false,
+		apply: func(conf *Config, value string) error {
+			mode, err := parseFileMode(value)
+			if err != nil {
+				return err
+			}
+
+			conf.Server.UnixSocketMode = mode
+
+			return nil
+		},
+	},
+	{
+		flag: "unix-socket-owner", env: "ALOTAME_UNIX_SOCKET_OWNER", usage: "owner of the unix socket (user:group)",
+		boolean: false,
+		apply: func(conf *Config, value string) error {
+			conf.Server.UnixSocketOwner = value
+
+			return nil
+		},
+	},
+	{
+		flag: "tls-cert", env: "ALOTAME_TLS_CERT", usage: "path of the TLS certificate to serve HTTPS", boolean: false,
+		apply: func(conf *Config, value string) error {
+			conf.Server.TLSCert = value
+
+			return nil
+		},
+	},
+	{
+		flag: "tls-key", env: "ALOTAME_TLS_KEY", usage: "path of the TLS private key to serve HTTPS", boolean: false,
+		apply: func(conf *Config, value string) error {
+			conf.Server.TLSKey = value
+
+			return nil
+		},
+	},
+	boolSetting("tls-self-signed", "ALOTAME_TLS_SELF_SIGNED", "generate a self-signed certificate if missing",
+		func(conf *Config) *bool { return &conf.Server.TLSSelfSigned }),
+	{
+		flag: "admin-host", env: "ALOTAME_ADMIN_HOST", usage: "host of the admin server to listen on", boolean: false,
+		apply: func(conf *Config, value string) error {
+			conf.Admin.Host = value
+
+			return nil
+		},
+	},
+	{
+		flag: "admin-port", env: "ALOTAME_ADMIN_PORT", usage: "port of the admin server to listen on", boolean: false,
+		apply: func(conf *Config, value string) error {
+			conf.Admin.Port = value
+
+			return nil
+		},
+	},
+	{
+		flag: "admin-unix-socket", env: "ALOTAME_ADMIN_UNIX_SOCKET", boolean: false,
+		usage: "path of the unix socket for the admin server to listen on instead of TCP",
+		apply: func(conf *Config, value string) error {
+			conf.Admin.UnixSocket = value
+
+			return nil
+		},
+	},
+	{
+		flag: "admin-tls-cert", env: "ALOTAME_ADMIN_TLS_CERT", boolean: false,
+		usage: "path of the TLS certificate for the admin server to serve HTTPS",
+		apply: func(conf *Config, value string) error {
+			conf.Admin.TLSCert = value
+
+			return nil
+		},
+	},
+	{
+		flag: "admin-tls-key", env: "ALOTAME_ADMIN_TLS_KEY", boolean: false,
+		usage: "path of the TLS private key for the admin server to serve HTTPS",
+		apply: func(conf *Config, value string) error {
+			conf.Admin.TLSKey = value
+
+			return nil
+		},
+	},
+	boolSetting("admin-tls-self-signed", "ALOTAME_ADMIN_TLS_SELF_SIGNED",
+		"generate a self-signed certificate for the admin server if missing",
+		func(conf *Config) *bool { return &conf.Admin.TLSSelfSigned }),
+	{
+		flag: "blocky-urls", env: "ALOTAME_BLOCKY_URLS", boolean: false,
+		usage: "comma-separated base URLs of the Blocky APIs to refresh on change (e.g. http://blocky:4000)",
+		apply: func(conf *Config, value string) error {
+			conf.Blocky.URLs = nil
+
+			for raw := range strings.SplitSeq(value, ",") {
+				if raw = strings.TrimSpace(raw); raw != "" {
+					conf.Blocky.URLs = append(conf.Blocky.URLs, raw)
+				}
+			}
+
+			return nil
+		},
+	},
+	durationSetting("read-header-timeout", "ALOTAME_READ_HEADER_TIMEOUT", "time to read request headers",
+		func(conf *Config) *time.Duration { return &conf.Server.ReadHeaderTimeout }),
+	durationSetting("read-timeout", "ALOTAME_READ_TIMEOUT", "time to read the entire request",
+		func(conf *Config) *time.Duration { return &conf.Server.ReadTimeout }),
+	durationSetting("write-timeout", "ALOTAME_WRITE_TIMEOUT", "time to write the response",
+		func(conf *Config) *time.Duration { return &conf.Server.WriteTimeout }),
+	durationSetting("idle-timeout", "ALOTAME_IDLE_TIMEOUT", "time to keep idle connections",
+		func(conf *Config) *time.Duration { return &conf.Server.IdleTimeout }),
+	durationSetting("shutdown-timeout", "ALOTAME_SHUTDOWN_TIMEOUT", "time to wait for graceful shutdown",
+		func(conf *Config) *time.Duration { return &conf.Server.ShutdownTimeout }),
+	durationSetting("admin-read-header-timeout", "ALOTAME_ADMIN_READ_HEADER_TIMEOUT",
+		"time for the admin server to read request headers",
+		func(conf *Config) *time.Duration { return &conf.Admin.ReadHeaderTimeout }),
+	durationSetting("admin-read-timeout", "ALOTAME_ADMIN_READ_TIMEOUT",
+		"time for the admin server to read the entire request",
+		func(conf *Config) *time.Duration { return &conf.Admin.ReadTimeout }),
+	durationSetting("admin-write-timeout", "ALOTAME_ADMIN_WRITE_TIMEOUT",
+		"time for the admin server to write the response",
+		func(conf *Config) *time.Duration { return &conf.Admin.WriteTimeout }),
+	durationSetting("admin-idle-timeout", "ALOTAME_ADMIN_IDLE_TIMEOUT",
+		"time for the admin server to keep idle connections",
+		func(conf *Config) *time.Duration { return &conf.Admin.IdleTimeout }),
+	durationSetting("admin-shutdown-timeout", "ALOTAME_ADMIN_SHUTDOWN_TIMEOUT",
+		"time for the admin server to wait for graceful shutdown",
+		func(conf *Config) *time.Duration { return &conf.Admin.ShutdownTimeout }),
+}
+
+// boolSetting returns a setting for a boolean field of the config.
+func boolSetting(name, env, usage string, field func(conf *Config) *bool) setting {
+	return setting{
+		flag: name, env: env, usage: usage + " (true/false)", boolean: true,
+		apply: func(conf *Config, value string) error {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return wrapError(err, "invalid value for "+name)
+			}
+
+			*field(conf) = parsed
+
+			return nil
+		},
+	}
+}
+
+// durationSetting returns a setting for a duration field of the config.
+func durationSetting(name, env, usage string, field func(conf *Config) *time.Duration) setting {
+	return setting{
+		flag: name, env: env, usage: usage + " (e.g. 30s)", boolean: false,
+		apply: func(conf *Config, value string) error {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return wrapError(err, "invalid value for "+name)
+			}
+
+			*field(conf) = parsed
+
+			return nil
+		},
+	}
+}
+
+// ============================================================================
+//  Command Line
+// ============================================================================
+
+// cliOptions is the result of parseCommandLine.
+type cliOptions struct {
+	// Config is the effective merged configuration.
+	Config Config
+	// ConfigPath is the path of the config file. Empty if not given.
+	ConfigPath string
+	// PrintConfig is true if the config should be printed instead of starting
+	// the server.
+	PrintConfig bool
+}
+
+// parseCommandLine merges the configuration from the CLI flags, environment
+// variables, config file and DefaultConfig in this order of precedence.
+//
+// The config file path is given by the "--config" flag or the
+// ALOTAME_CONFIG_PATH environment variable.
+func parseCommandLine(args []string, getenv func(string) string, output io.Writer) (cliOptions, error) {
+	opts := cliOptions{Config: DefaultConfig(), ConfigPath: "", PrintConfig: false}
+
+	flagSet := flag.NewFlagSet("alotame", flag.ContinueOnError)
+	flagSet.SetOutput(output)
+	flagSet.Usage = func() { printUsage(flagSet, output) }
+
+	configPath := flagSet.String(flagConfig, "", "path of the JSON config file (env: "+envConfigPath+")")
+	flagSet.BoolVar(&opts.PrintConfig, flagPrintConfig, false, "print the effective config with secrets redacted and exit")
+
+	for _, set := range settings {
+		if set.boolean {
+			flagSet.Var(new(boolFlag), set.flag, set.usage+" (env: "+set.env+")")
+
+			continue
+		}
+
+		flagSet.String(set.flag, "", set.usage+" (env: "+set.env+")")
+	}
+
+	err := flagSet.Parse(args)
+	if err != nil {
+		return opts, wrapError(err, "failed to parse flags")
+	}
+
+	if *configPath == "" {
+		*configPath = getenv(envConfigPath)
+	}
+
+	opts.ConfigPath = *configPath
+
+	if *configPath != "" {
+		opts.Config, err = LoadConfig(*configPath)
+		if err != nil {
+			return opts, err
+		}
+	}
+
+	err = applyEnv(&opts.Config, getenv)
+	if err != nil {
+		return opts, err
+	}
+
+	err = applyFlags(&opts.Config, flagSet)
+	if err != nil {
+		return opts, err
+	}
+
+	return opts, opts.Config.Validate()
+}
+
+// applyEnv applies the settings given by non-empty environment variables.
+func applyEnv(conf *Config, getenv func(string) string) error {
+	for _, set := range settings {
+		value := getenv(set.env)
+		if value == "" {
+			continue
+		}
+
+		err := set.apply(conf, value)
+		if err != nil {
+			return wrapError(err, "env "+set.env)
+		}
+	}
+
+	return nil
+}
+
+// applyFlags applies the settings given on the command line.
+func applyFlags(conf *Config, flagSet *flag.FlagSet) error {
+	given := make(map[string]string)
+
+	flagSet.Visit(func(fl *flag.Flag) {
+		given[fl.Name] = fl.Value.String()
+	})
+
+	for _, set := range settings {
+		value, ok := given[set.flag]
+		if !ok {
+			continue
+		}
+
+		err := set.apply(conf, value)
+		if err != nil {
+			return wrapError(err, "flag --"+set.flag)
+		}
+	}
+
+	return nil
+}
+
+// boolFlag is the flag.Value of a boolean setting. It can be given without a
+// value as "--name" for true, and keeps the value as given so that the
+// setting parses it like the environment variable.
+type boolFlag string
+
+func (f *boolFlag) String() string {
+	if f == nil {
+		return ""
+	}
+
+	return string(*f)
+}
+
+func (f *boolFlag) Set(value string) error {
+	*f = boolFlag(value)
+
+	return nil
+}
+
+// IsBoolFlag tells the flag package that the flag needs no value.
+func (f *boolFlag) IsBoolFlag() bool {
+	return true
+}
+
+func printUsage(flagSet *flag.FlagSet, output io.Writer) {
+	_, _ = fmt.Fprint(output, `Usage: alotame [options]
+       alotame reset-totp [--config path] [--username name] [--clear]
+       alotame blocky-config [options]
+
+Settings are applied in this order of precedence:
+  CLI flag > environment variable > config file > default value
+
+Options:
+`)
+
+	flagSet.PrintDefaults()
+}
+
+// printConfig writes the configuration as indented JSON with secrets redacted.
+func printConfig(output io.Writer, conf Config) error {
+	enc := json.NewEncoder(output)
+	enc.SetIndent("", "  ")
+
+	return wrapError(enc.Encode(conf.Redacted()), "failed to print config")
+}
+
+// Redacted returns a copy of the configuration with secret values replaced.
+func (c Config) Redacted() Config {
+	if c.Auth.Seed != "" {
+		c.Auth.Seed = redacted
+	}
+
+	c.Auth.Users = slices.Clone(c.Auth.Users)
+
+	for idx, user := range c.Auth.Users {
+		codes := make([]string, len(user.RecoveryCodes))
+		for codeIdx := range codes {
+			codes[codeIdx] = redacted
+		}
+
+		c.Auth.Users[idx].RecoveryCodes = codes
+	}
+
+	// The DSN may have the password of the database
+	if c.QueryLog.IsDatabase() && c.QueryLog.Target != "" {
+		c.QueryLog.Target = redacted
+	}
+
+	// URLs may have credentials in the userinfo or a token in the query
+	c.PublicURL = redactURL(c.PublicURL)
+	c.Blocky.URLs = slices.Clone(c.Blocky.URLs)
+
+	for idx, raw := range c.Blocky.URLs {
+		c.Blocky.URLs[idx] = redactURL(raw)
+	}
+
+	c.Lists = slices.Clone(c.Lists)
+
+	for idx, list := range c.Lists {
+		sources := make([]string, len(list.Sources))
+		for sourceIdx, raw := range list.Sources {
+			sources[sourceIdx] = redactURL(raw)
+		}
+
+		c.Lists[idx].Sources = sources
+	}
+
+	return c
+}
+
+// redactURL returns the URL without the userinfo and the query. It returns
+// redacted if the URL cannot be parsed.
+func redactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+
+	parsed.User = nil
+	parsed.RawQuery = ""
+	parsed.ForceQuery = false
+
+	return parsed.String()
+}
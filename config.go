@@ -0,0 +1,781 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configFileMode is the only file mode allowed for the config file. The config
+// file holds the auth seed, so it must be readable only by its owner.
+const configFileMode os.FileMode = 0o600
+
+// maxPort is the largest TCP port number.
+const maxPort = 65535
+
+// Errors returned by LoadConfig.
+var (
+	ErrConfigPermission = errors.New("config file permission must be 0600")
+	ErrConfigOwner      = errors.New("config file must be owned by the current user")
+	ErrConfigNotRegular = errors.New("config file is not a regular file")
+	ErrConfigTrailing   = errors.New("config file has data after the JSON object")
+	ErrConfigInvalid    = errors.New("invalid config value")
+)
+
+// ============================================================================
+//  Types
+// ============================================================================
+
+// Config is the whole configuration of Alotame.
+type Config struct {
+	// Server is the configuration of the public HTTP server serving the
+	// allowlist to Blocky.
+	Server ServerConfig `json:"server"`
+	// Admin is the configuration of the HTTP server serving the admin UI and
+	// APIs. It must not be reachable from the DNS network.
+	Admin ServerConfig `json:"admin"`
+	// PublicURL is the URL Blocky reaches the public server at, such as
+	// "http://alotame:5963", for the generated Blocky config.
+	PublicURL string `json:"publicURL,omitempty"`
+	// AllowlistPath is the path of the allowlist file. If empty, the sample
+	// allowlist is served.
+	AllowlistPath string `json:"allowlistPath,omitempty"`
+	// Lists are the named allowlists served at /lists/{name}.txt, such as
+	// one per client group of Blocky.
+	Lists []ListConfig `json:"lists,omitempty"`
+	// Remote is how to fetch the remote sources of the lists.
+	Remote RemoteConfig `json:"remote"`
+	// Auth is the authentication configuration.
+	Auth AuthConfig `json:"auth"`
+	// QueryLog is the query log of Blocky to read the blocked domains from.
+	QueryLog QueryLogConfig `json:"queryLog"`
+	// Blocky is the Blocky instances to refresh after allowlist changes.
+	Blocky BlockyConfig `json:"blocky"`
+	// AuditLogPath is the file to append the audit trail to, such as the
+	// pauses of blocking. Empty to only log it.
+	AuditLogPath string `json:"auditLogPath,omitempty"`
+}
+
+// AuthConfig holds the authentication configuration. It is written back to
+// the config file on TOTP enrollment and when users are changed.
+type AuthConfig struct {
+	// Seed is the random value to derive TOTP secrets from. It is generated
+	// on enrollment and shared by the users. Rotate it with reset-totp.
+	Seed string `json:"seed,omitempty"`
+	// Users are the admin users. The first one is enrolled on the enrollment
+	// page as an admin and the others are added by an admin.
+	Users []UserConfig `json:"users,omitempty"`
+	// Username and RecoveryCodes are the single admin user of the config
+	// files before Users. LoadConfig moves them to Users.
+	Username      string   `json:"username,omitempty"`
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+	// SessionsPath is the file to save the sessions to, so that signed-in
+	// users stay signed in after a restart. Empty to keep them in memory.
+	SessionsPath string `json:"sessionsPath,omitempty"`
+	// SessionIdleTimeout ends a session after this time without access.
+	SessionIdleTimeout Duration `json:"sessionIdleTimeout"`
+	// SessionMaxAge ends a session after this time since sign-in.
+	SessionMaxAge Duration `json:"sessionMaxAge"`
+}
+
+// UserConfig is an admin user. The TOTP secret of the user is derived from the
+// username and the seed.
+type UserConfig struct {
+	Username string `json:"username"`
+	Role     Role   `json:"role"`
+	// RecoveryCodes are the hashes of the unused one-time recovery codes.
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
+// QueryLogConfig is where to read the query log of Blocky from. It mirrors
+// the queryLog settings of Blocky.
+type QueryLogConfig struct {
+	// Type is the queryLog.type of Blocky: "csv", "csv-client", "mysql",
+	// "postgresql" or "timescale". Empty to disable reading the query log.
+	Type string `json:"type,omitempty"`
+	// Target is the queryLog.target of Blocky: the directory of the files or
+	// the DSN of the database.
+	Target string `json:"target,omitempty"`
+	// PollInterval is the interval to read the new entries.
+	PollInterval Duration `json:"pollInterval"`
+	// Retention is how long a blocked domain is listed since it was last seen.
+	Retention Duration `json:"retention"`
+	// DismissThreshold is the number of new hits after which a dismissed
+	// group of blocked domains is shown again.
+	DismissThreshold int `json:"dismissThreshold"`
+	// DismissedPath is the file to save the dismissed groups to, so they stay
+	// dismissed after a restart. Empty to keep them in memory.
+	DismissedPath string `json:"dismissedPath,omitempty"`
+}
+
+// ListConfig is a named allowlist. It is composed of a file, remote sources
+// and other lists, minus the exclusions.
+type ListConfig struct {
+	// Name is the name of the list in its URL, such as "kids".
+	Name string `json:"name"`
+	// Path is the path of the allowlist file of the list. Empty for a list
+	// composed of other lists and sources only.
+	Path string `json:"path,omitempty"`
+	// Base marks a list meant to be included by other lists rather than used
+	// on its own.
+	Base bool `json:"base,omitempty"`
+	// Include are the names of the lists served with the list.
+	Include []string `json:"include,omitempty"`
+	// Sources are the URLs of remote allowlists served with the list.
+	Sources []string `json:"sources,omitempty"`
+	// Exclude are the entries not to serve, such as "youtube.com" from an
+	// included list. A wildcard also removes the domains under it.
+	Exclude []string `json:"exclude,omitempty"`
+	// Clients are the clients of Blocky to serve the list to: names, IP
+	// addresses or CIDRs as in clientGroupsBlock, or "default".
+	Clients []string `json:"clients,omitempty"`
+}
+
+// RemoteConfig is how to fetch the remote sources of the lists.
+type RemoteConfig struct {
+	// RefreshInterval is the interval to fetch each source again.
+	RefreshInterval Duration `json:"refreshInterval"`
+	// Timeout is the timeout of each request.
+	Timeout Duration `json:"timeout"`
+}
+
+// BlockyConfig is how to reach the HTTP API of the Blocky instances.
+type BlockyConfig struct {
+	// URLs are the base URLs of the HTTP API of the instances, such as
+	// "http://blocky:4000". Empty to not refresh Blocky.
+	URLs []string `json:"urls,omitempty"`
+	// Timeout is the timeout of each request to Blocky.
+	Timeout Duration `json:"timeout"`
+	// Retries is the number of retries of a failed request.
+	Retries int `json:"retries"`
+	// ProbeInterval is the interval to check that the instances are
+	// reachable. Unreachable ones are checked more often with a backoff.
+	ProbeInterval Duration `json:"probeInterval"`
+	// DNSPort is the DNS port of the instances, on the hosts of URLs.
+	DNSPort string `json:"dnsPort"`
+	// CanaryDomain is the domain resolved to check the DNS of the instances.
+	// Empty to check the HTTP API only.
+	CanaryDomain string `json:"canaryDomain"`
+	// MaxPause is the longest pause of blocking allowed from the admin UI.
+	MaxPause Duration `json:"maxPause"`
+}
+
+// DefaultConfig returns the default configuration.
+func DefaultConfig() Config {
+	return Config{
+		Server:        DefaultServerConfig(),
+		Admin:         DefaultAdminServerConfig(),
+		PublicURL:     "",
+		AllowlistPath: "",
+		Lists:         nil,
+		Remote: RemoteConfig{
+			RefreshInterval: Duration(remoteRefreshIntervalDefault),
+			Timeout:         Duration(remoteTimeoutDefault),
+		},
+		Auth: AuthConfig{
+			Seed:               "",
+			Users:              nil,
+			Username:           "",
+			RecoveryCodes:      nil,
+			SessionsPath:       "",
+			SessionIdleTimeout: Duration(sessionIdleTimeoutDefault),
+			SessionMaxAge:      Duration(sessionMaxAgeDefault),
+		},
+		QueryLog: QueryLogConfig{
+			Type:             "",
+			Target:           "",
+			PollInterval:     Duration(queryLogPollIntervalDefault),
+			Retention:        Duration(queryLogRetentionDefault),
+			DismissThreshold: dismissThresholdDefault,
+			DismissedPath:    "",
+		},
+		Blocky: BlockyConfig{
+			URLs:          nil,
+			Timeout:       Duration(blockyTimeoutDefault),
+			Retries:       blockyRetriesDefault,
+			ProbeInterval: Duration(blockyProbeIntervalDefault),
+			DNSPort:       blockyDNSPortDefault,
+			CanaryDomain:  blockyCanaryDefault,
+			MaxPause:      Duration(blockingMaxPauseDefault),
+		},
+		AuditLogPath: "",
+	}
+}
+
+// Duration is a time.Duration encoded in JSON as a Go duration string such as
+// "30s" or "1m30s".
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a Go duration string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String()) //nolint:wrapcheck // encoding a string never fails
+}
+
+// UnmarshalJSON decodes a Go duration string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var str string
+
+	err := json.Unmarshal(data, &str)
+	if err != nil {
+		return wrapError(err, "duration must be a string such as \"30s\"")
+	}
+
+	parsed, err := time.ParseDuration(str)
+	if err != nil {
+		return wrapError(err, "invalid duration")
+	}
+
+	*d = Duration(parsed)
+
+	return nil
+}
+
+// FileMode is an os.FileMode encoded in JSON as an octal string such as
+// "0660".
+type FileMode os.FileMode
+
+// MarshalJSON encodes the file mode as an octal string.
+func (m FileMode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%#04o", uint32(m))) //nolint:wrapcheck // encoding a string never fails
+}
+
+// UnmarshalJSON decodes an octal string.
+func (m *FileMode) UnmarshalJSON(data []byte) error {
+	var str string
+
+	err := json.Unmarshal(data, &str)
+	if err != nil {
+		return wrapError(err, "file mode must be an octal string such as \"0660\"")
+	}
+
+	parsed, err := parseFileMode(str)
+	if err != nil {
+		return err
+	}
+
+	*m = FileMode(parsed)
+
+	return nil
+}
+
+// parseFileMode parses an octal file mode such as "0660" or "660".
+func parseFileMode(str string) (os.FileMode, error) {
+	parsed, err := strconv.ParseUint(str, 8, 32)
+	if err != nil || parsed > uint64(os.ModePerm) {
+		return 0, fmt.Errorf("%w: file mode %q", ErrConfigInvalid, str)
+	}
+
+	return os.FileMode(parsed), nil
+}
+
+// serverConfigJSON is the JSON form of ServerConfig.
+type serverConfigJSON struct {
+	Host              string   `json:"host"`
+	Port              string   `json:"port"`
+	ReadHeaderTimeout Duration `json:"readHeaderTimeout"`
+	ReadTimeout       Duration `json:"readTimeout"`
+	WriteTimeout      Duration `json:"writeTimeout"`
+	IdleTimeout       Duration `json:"idleTimeout"`
+	ShutdownTimeout   Duration `json:"shutdownTimeout"`
+	UnixSocket        string   `json:"unixSocket,omitempty"`
+	UnixSocketMode    FileMode `json:"unixSocketMode"`
+	UnixSocketOwner   string   `json:"unixSocketOwner,omitempty"`
+	TLSCert           string   `json:"tlsCert,omitempty"`
+	TLSKey            string   `json:"tlsKey,omitempty"`
+	TLSSelfSigned     bool     `json:"tlsSelfSigned,omitempty"`
+}
+
+// MarshalJSON encodes the server configuration with durations as strings.
+func (c ServerConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(serverConfigJSON{ //nolint:wrapcheck // no custom error to wrap
+		Host:              c.Host,
+		Port:              c.Port,
+		ReadHeaderTimeout: Duration(c.ReadHeaderTimeout),
+		ReadTimeout:       Duration(c.ReadTimeout),
+		WriteTimeout:      Duration(c.WriteTimeout),
+		IdleTimeout:       Duration(c.IdleTimeout),
+		ShutdownTimeout:   Duration(c.ShutdownTimeout),
+		UnixSocket:        c.UnixSocket,
+		UnixSocketMode:    FileMode(c.UnixSocketMode),
+		UnixSocketOwner:   c.UnixSocketOwner,
+		TLSCert:           c.TLSCert,
+		TLSKey:            c.TLSKey,
+		TLSSelfSigned:     c.TLSSelfSigned,
+	})
+}
+
+// UnmarshalJSON decodes the server configuration. Absent fields keep their
+// current values and unknown fields are an error.
+func (c *ServerConfig) UnmarshalJSON(data []byte) error {
+	shadow := serverConfigJSON{
+		Host:              c.Host,
+		Port:              c.Port,
+		ReadHeaderTimeout: Duration(c.ReadHeaderTimeout),
+		ReadTimeout:       Duration(c.ReadTimeout),
+		WriteTimeout:      Duration(c.WriteTimeout),
+		IdleTimeout:       Duration(c.IdleTimeout),
+		ShutdownTimeout:   Duration(c.ShutdownTimeout),
+		UnixSocket:        c.UnixSocket,
+		UnixSocketMode:    FileMode(c.UnixSocketMode),
+		UnixSocketOwner:   c.UnixSocketOwner,
+		TLSCert:           c.TLSCert,
+		TLSKey:            c.TLSKey,
+		TLSSelfSigned:     c.TLSSelfSigned,
+	}
+
+	err := decodeStrict(data, &shadow)
+	if err != nil {
+		return err
+	}
+
+	c.Host = shadow.Host
+	c.Port = shadow.Port
+	c.ReadHeaderTimeout = time.Duration(shadow.ReadHeaderTimeout)
+	c.ReadTimeout = time.Duration(shadow.ReadTimeout)
+	c.WriteTimeout = time.Duration(shadow.WriteTimeout)
+	c.IdleTimeout = time.Duration(shadow.IdleTimeout)
+	c.ShutdownTimeout = time.Duration(shadow.ShutdownTimeout)
+	c.UnixSocket = shadow.UnixSocket
+	c.UnixSocketMode = os.FileMode(shadow.UnixSocketMode)
+	c.UnixSocketOwner = shadow.UnixSocketOwner
+	c.TLSCert = shadow.TLSCert
+	c.TLSKey = shadow.TLSKey
+	c.TLSSelfSigned = shadow.TLSSelfSigned
+
+	return nil
+}
+
+// Validate checks the server configuration values.
+func (c ServerConfig) Validate() error {
+	port, err := strconv.Atoi(c.Port)
+	if err != nil || port < 0 || port > maxPort {
+		return fmt.Errorf("%w: port %q", ErrConfigInvalid, c.Port)
+	}
+
+	timeouts := map[string]time.Duration{
+		"readHeaderTimeout": c.ReadHeaderTimeout,
+		"readTimeout":       c.ReadTimeout,
+		"writeTimeout":      c.WriteTimeout,
+		"idleTimeout":       c.IdleTimeout,
+		"shutdownTimeout":   c.ShutdownTimeout,
+	}
+
+	for name, timeout := range timeouts {
+		if timeout < 0 {
+			return fmt.Errorf("%w: %s must not be negative", ErrConfigInvalid, name)
+		}
+	}
+
+	if c.IsTLS() && (c.TLSCert == "" || c.TLSKey == "") {
+		return fmt.Errorf("%w: %w", ErrConfigInvalid, ErrTLSConfig)
+	}
+
+	if c.TLSSelfSigned && !c.IsTLS() {
+		return fmt.Errorf("%w: tlsSelfSigned requires tlsCert and tlsKey", ErrConfigInvalid)
+	}
+
+	if c.UnixSocketMode&^os.ModePerm != 0 {
+		return fmt.Errorf("%w: unixSocketMode %#o", ErrConfigInvalid, uint32(c.UnixSocketMode))
+	}
+
+	return nil
+}
+
+// Validate checks the configuration values.
+func (c Config) Validate() error {
+	err := c.Server.Validate()
+	if err != nil {
+		return wrapError(err, "server")
+	}
+
+	err = c.Admin.Validate()
+	if err != nil {
+		return wrapError(err, "admin")
+	}
+
+	if c.Server.UnixSocket == "" && c.Admin.UnixSocket == "" &&
+		c.Server.Port != "0" && c.Server.Port == c.Admin.Port {
+		return fmt.Errorf("%w: server and admin must listen on different ports", ErrConfigInvalid)
+	}
+
+	if c.Server.UnixSocket != "" && c.Server.UnixSocket == c.Admin.UnixSocket {
+		return fmt.Errorf("%w: server and admin must listen on different unix sockets", ErrConfigInvalid)
+	}
+
+	if c.PublicURL != "" {
+		parsed, err := url.Parse(c.PublicURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+			parsed.RawQuery != "" || parsed.Fragment != "" {
+			return fmt.Errorf("%w: publicURL: %q must be an http or https URL", ErrConfigInvalid, c.PublicURL)
+		}
+	}
+
+	err = validateLists(c.Lists)
+	if err != nil {
+		return wrapError(err, "lists")
+	}
+
+	err = c.Remote.Validate()
+	if err != nil {
+		return wrapError(err, "remote")
+	}
+
+	err = c.QueryLog.Validate()
+	if err != nil {
+		return wrapError(err, "queryLog")
+	}
+
+	err = c.Blocky.Validate()
+	if err != nil {
+		return wrapError(err, "blocky")
+	}
+
+	return c.Auth.Validate()
+}
+
+// IsDatabase returns true if the query log is in a database.
+func (c QueryLogConfig) IsDatabase() bool {
+	return c.Type == queryLogMySQL || c.Type == queryLogPostgreSQL || c.Type == queryLogTimescale
+}
+
+// Validate checks the query log configuration values.
+func (c QueryLogConfig) Validate() error {
+	if c.PollInterval <= 0 || c.Retention <= 0 {
+		return fmt.Errorf("%w: pollInterval and retention must be positive", ErrConfigInvalid)
+	}
+
+	if c.DismissThreshold < 0 {
+		return fmt.Errorf("%w: dismissThreshold must not be negative", ErrConfigInvalid)
+	}
+
+	switch c.Type {
+	case "":
+		return nil
+	case queryLogCSV, queryLogCSVClient, queryLogMySQL, queryLogPostgreSQL, queryLogTimescale:
+		if c.Target == "" {
+			return fmt.Errorf("%w: target is required for type %q", ErrConfigInvalid, c.Type)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w: %w: %q", ErrConfigInvalid, ErrQueryLogType, c.Type)
+	}
+}
+
+// validateLists checks the names of the lists, their inputs and exclusions.
+// A list can include any other list as long as no list includes itself.
+func validateLists(lists []ListConfig) error {
+	names := make(map[string]bool, len(lists))
+
+	for _, list := range lists {
+		if !listNamePattern.MatchString(list.Name) {
+			return fmt.Errorf("%w: %q: %w", ErrConfigInvalid, list.Name, ErrListName)
+		}
+
+		if names[list.Name] {
+			return fmt.Errorf("%w: %q: duplicate list name", ErrConfigInvalid, list.Name)
+		}
+
+		if list.Path == "" && len(list.Include) == 0 && len(list.Sources) == 0 {
+			return fmt.Errorf("%w: %q: path, include or sources is required", ErrConfigInvalid, list.Name)
+		}
+
+		names[list.Name] = true
+	}
+
+	for _, list := range lists {
+		err := validateListInputs(list, names)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cycle := findIncludeCycle(lists); cycle != nil {
+		return fmt.Errorf("%w: %w: %s", ErrConfigInvalid, ErrListCycle, strings.Join(cycle, " -> "))
+	}
+
+	return nil
+}
+
+// validateListInputs checks the includes, sources, exclusions and clients of
+// the list.
+func validateListInputs(list ListConfig, names map[string]bool) error {
+	for _, name := range list.Include {
+		if !names[name] {
+			return fmt.Errorf("%w: %q: include %q: %w", ErrConfigInvalid, list.Name, name, ErrListNotFound)
+		}
+	}
+
+	for _, raw := range list.Sources {
+		source, err := url.Parse(raw)
+		if err != nil || (source.Scheme != "http" && source.Scheme != "https") || source.Host == "" {
+			return fmt.Errorf("%w: %q: source %q must be an http or https URL", ErrConfigInvalid, list.Name, raw)
+		}
+	}
+
+	for _, raw := range list.Exclude {
+		_, ok, err := parseLine(raw)
+		if err != nil {
+			return fmt.Errorf("%w: %q: exclude %q: %w", ErrConfigInvalid, list.Name, raw, err)
+		}
+
+		if !ok {
+			return fmt.Errorf("%w: %q: exclude %q: %w", ErrConfigInvalid, list.Name, raw, ErrDomainEmpty)
+		}
+	}
+
+	for idx, client := range list.Clients {
+		if client == "" || strings.ContainsAny(client, " \t\n") || slices.Contains(list.Clients[:idx], client) {
+			return fmt.Errorf("%w: %q: client %q must be a name, an address or a CIDR once", ErrConfigInvalid,
+				list.Name, client)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the remote source configuration values.
+func (c RemoteConfig) Validate() error {
+	if c.RefreshInterval <= 0 || c.Timeout <= 0 {
+		return fmt.Errorf("%w: refreshInterval and timeout must be positive", ErrConfigInvalid)
+	}
+
+	return nil
+}
+
+// Validate checks the Blocky configuration values.
+func (c BlockyConfig) Validate() error {
+	if c.Timeout <= 0 || c.ProbeInterval <= 0 || c.MaxPause <= 0 {
+		return fmt.Errorf("%w: timeout, probeInterval and maxPause must be positive", ErrConfigInvalid)
+	}
+
+	port, err := strconv.Atoi(c.DNSPort)
+	if err != nil || port < 1 || port > maxPort {
+		return fmt.Errorf("%w: dnsPort %q", ErrConfigInvalid, c.DNSPort)
+	}
+
+	if c.CanaryDomain != "" {
+		_, err = NormalizeDomain(c.CanaryDomain)
+		if err != nil {
+			return fmt.Errorf("%w: canaryDomain: %w", ErrConfigInvalid, err)
+		}
+	}
+
+	if c.Retries < 0 {
+		return fmt.Errorf("%w: retries must not be negative", ErrConfigInvalid)
+	}
+
+	for _, raw := range c.URLs {
+		parsed, err := url.Parse(raw)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("%w: urls: %q must be an http or https URL", ErrConfigInvalid, raw)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the auth configuration values.
+func (c AuthConfig) Validate() error {
+	if c.SessionIdleTimeout <= 0 || c.SessionMaxAge <= 0 {
+		return fmt.Errorf("%w: sessionIdleTimeout and sessionMaxAge must be positive", ErrConfigInvalid)
+	}
+
+	seen := make(map[string]bool, len(c.Users))
+
+	for _, user := range c.Users {
+		err := validateUsername(user.Username)
+		if err != nil {
+			return fmt.Errorf("%w: auth.users: %w", ErrConfigInvalid, err)
+		}
+
+		if !user.Role.Valid() {
+			return fmt.Errorf("%w: auth.users: %q: %w", ErrConfigInvalid, user.Username, ErrInvalidRole)
+		}
+
+		if seen[user.Username] {
+			return fmt.Errorf("%w: auth.users: %q: %w", ErrConfigInvalid, user.Username, ErrUserExists)
+		}
+
+		seen[user.Username] = true
+	}
+
+	return nil
+}
+
+// migrate moves the single admin user of older config files to Users.
+func (c AuthConfig) migrate() AuthConfig {
+	if c.Username == "" {
+		return c
+	}
+
+	if !slices.ContainsFunc(c.Users, func(user UserConfig) bool { return user.Username == c.Username }) {
+		admin := UserConfig{Username: c.Username, Role: RoleAdmin, RecoveryCodes: c.RecoveryCodes}
+		c.Users = append([]UserConfig{admin}, c.Users...)
+	}
+
+	c.Username = ""
+	c.RecoveryCodes = nil
+
+	return c
+}
+
+// ============================================================================
+//  Loading
+// ============================================================================
+
+// LoadConfig reads the JSON config file at the given path. Fields absent in
+// the file keep the values of DefaultConfig.
+//
+// It refuses to load the file if its permission is other than 0600 or it is
+// owned by another user, since the file holds the auth seed.
+func LoadConfig(path string) (Config, error) {
+	conf := DefaultConfig()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return conf, wrapError(err, "failed to stat config file")
+	}
+
+	err = checkConfigFile(path, info)
+	if err != nil {
+		return conf, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return conf, wrapError(err, "failed to read config file")
+	}
+
+	err = decodeStrict(data, &conf)
+	if err != nil {
+		return DefaultConfig(), wrapError(err, "failed to parse config file "+path)
+	}
+
+	conf.Auth = conf.Auth.migrate()
+
+	err = conf.Validate()
+	if err != nil {
+		return DefaultConfig(), wrapError(err, path)
+	}
+
+	return conf, nil
+}
+
+// SaveAuthConfig replaces the "auth" object of the config file at the given
+// path, keeping the other values as they are. The file is created if missing
+// and replaced atomically with the permission 0600.
+func SaveAuthConfig(path string, auth AuthConfig) error {
+	fields := make(map[string]json.RawMessage)
+
+	info, err := os.Stat(path)
+	if err == nil {
+		err = checkConfigFile(path, info)
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return wrapError(err, "failed to read config file")
+		}
+
+		err = json.Unmarshal(data, &fields)
+		if err != nil {
+			return wrapError(err, "failed to parse config file "+path)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return wrapError(err, "failed to stat config file")
+	}
+
+	fields["auth"], err = json.Marshal(auth)
+	if err != nil {
+		return wrapError(err, "failed to encode auth config")
+	}
+
+	data, err := json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return wrapError(err, "failed to encode config file")
+	}
+
+	return writeFileAtomic(path, append(data, '\n'), configFileMode)
+}
+
+// writeFileAtomic writes the data to a temporary file in the same directory
+// and renames it to the path, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return wrapError(err, "failed to create temporary file")
+	}
+
+	defer os.Remove(tmp.Name()) //nolint:errcheck // no-op after a successful rename
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return wrapError(err, "failed to write "+path)
+	}
+
+	return wrapError(os.Rename(tmp.Name(), path), "failed to replace "+path)
+}
+
+// checkConfigFile checks the type, permission and owner of the config file.
+func checkConfigFile(path string, info os.FileInfo) error {
+	if !info.Mode().IsRegular() {
+		return wrapError(ErrConfigNotRegular, path)
+	}
+
+	if !checkFileModeSupported {
+		return nil
+	}
+
+	if info.Mode().Perm() != configFileMode {
+		return fmt.Errorf("%w: %s has %#o", ErrConfigPermission, path, info.Mode().Perm())
+	}
+
+	if !isOwnedByCurrentUser(info) {
+		return wrapError(ErrConfigOwner, path)
+	}
+
+	return nil
+}
+
+// decodeStrict decodes a single JSON value and rejects unknown fields and
+// trailing data.
+func decodeStrict(data []byte, dest any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dest)
+	if err != nil {
+		return wrapError(err, "invalid JSON")
+	}
+
+	_, err = dec.Token()
+	if !errors.Is(err, io.EOF) {
+		return ErrConfigTrailing
+	}
+
+	return nil
+}
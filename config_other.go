@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// checkFileModeSupported is true if the file mode and owner of the config file
+// can be checked on this platform. Unix file modes are not available here.
+const checkFileModeSupported = false
+
+// isOwnedByCurrentUser always returns true as the owner cannot be checked on
+// this platform.
+func isOwnedByCurrentUser(_ os.FileInfo) bool {
+	return true
+}
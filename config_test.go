@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for LoadConfig
+// ============================================================================
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfigFile(t, `{
+		"server": {
+			"host": "127.0.0.1",
+			"port": "8080",
+			"readTimeout": "1m30s",
+			"shutdownTimeout": "500ms"
+		},
+		"admin": {"port": "8081"},
+		"allowlistPath": "/data/allowlist.txt",
+		"auth": {"seed": "deadbeef"}
+	}`, 0o600)
+
+	conf, err := LoadConfig(path)
+
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", conf.Server.Host)
+	assert.Equal(t, "8080", conf.Server.Port)
+	assert.Equal(t, adminHostDefault, conf.Admin.Host)
+	assert.Equal(t, "8081", conf.Admin.Port)
+	assert.Equal(t, 90*time.Second, conf.Server.ReadTimeout)
+	assert.Equal(t, 500*time.Millisecond, conf.Server.ShutdownTimeout)
+	assert.Equal(t, "/data/allowlist.txt", conf.AllowlistPath)
+	assert.Equal(t, "deadbeef", conf.Auth.Seed)
+
+	// Absent fields fall back to the defaults
+	assert.Equal(t, readHeaderTimeout, conf.Server.ReadHeaderTimeout)
+	assert.Equal(t, writeTimeout, conf.Server.WriteTimeout)
+	assert.Equal(t, idleTimeout, conf.Server.IdleTimeout)
+}
+
+func TestLoadConfig_empty_object(t *testing.T) {
+	t.Parallel()
+
+	conf, err := LoadConfig(writeConfigFile(t, `{}`, 0o600))
+
+	require.NoError(t, err)
+	assert.Equal(t, DefaultConfig(), conf)
+}
+
+func TestLoadConfig_permission(t *testing.T) {
+	t.Parallel()
+
+	if !checkFileModeSupported {
+		t.Skip("file mode is not supported on this platform")
+	}
+
+	for _, mode := range []os.FileMode{0o644, 0o640, 0o400, 0o700, 0o666} {
+		t.Run(mode.String(), func(t *testing.T) {
+			t.Parallel()
+
+			_, err := LoadConfig(writeConfigFile(t, `{}`, mode))
+
+			require.ErrorIs(t, err, ErrConfigPermission)
+		})
+	}
+}
+
+func TestLoadConfig_owner(t *testing.T) {
+	t.Parallel()
+
+	if !checkFileModeSupported || os.Getuid() != 0 {
+		t.Skip("changing the file owner requires root on Unix")
+	}
+
+	path := writeConfigFile(t, `{}`, 0o600)
+	nobody := 65534
+
+	require.NoError(t, os.Chown(path, nobody, nobody))
+
+	_, err := LoadConfig(path)
+
+	require.ErrorIs(t, err, ErrConfigOwner)
+}
+
+func TestLoadConfig_invalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "unknown key", data: `{"unknown": true}`},
+		{name: "unknown server key", data: `{"server": {"hots": "localhost"}}`},
+		{name: "unknown auth key", data: `{"auth": {"sed": "x"}}`},
+		{name: "duration as number", data: `{"server": {"readTimeout": 30}}`},
+		{name: "invalid duration", data: `{"server": {"readTimeout": "30 seconds"}}`},
+		{name: "negative duration", data: `{"server": {"readTimeout": "-1s"}}`},
+		{name: "invalid port", data: `{"server": {"port": "http"}}`},
+		{name: "port out of range", data: `{"server": {"port": "70000"}}`},
+		{name: "trailing data", data: `{} {}`},
+		{name: "broken JSON", data: `{"server": `},
+		{name: "invalid role", data: `{"auth": {"users": [{"username": "alice", "role": "root"}]}}`},
+		{name: "invalid username", data: `{"auth": {"users": [{"username": "a b", "role": "admin"}]}}`},
+		{name: "unknown query log type", data: `{"queryLog": {"type": "console"}}`},
+		{name: "query log without target", data: `{"queryLog": {"type": "csv"}}`},
+		{name: "zero poll interval", data: `{"queryLog": {"pollInterval": "0s"}}`},
+		{name: "blocky URL without scheme", data: `{"blocky": {"urls": ["blocky:4000"]}}`},
+		{name: "negative blocky retries", data: `{"blocky": {"retries": -1}}`},
+		{name: "zero blocky max pause", data: `{"blocky": {"maxPause": "0s"}}`},
+		{name: "invalid list name", data: `{"lists": [{"name": "Kids", "path": "kids.txt"}]}`},
+		{name: "list without inputs", data: `{"lists": [{"name": "kids"}]}`},
+		{
+			name: "duplicate list name",
+			data: `{"lists": [{"name": "kids", "path": "a.txt"}, {"name": "kids", "path": "b.txt"}]}`,
+		},
+		{name: "unknown include", data: `{"lists": [{"name": "kids", "path": "a.txt", "include": ["base"]}]}`},
+		{name: "list including itself", data: `{"lists": [{"name": "kids", "include": ["kids"]}]}`},
+		{
+			name: "include cycle",
+			data: `{"lists": [{"name": "a", "path": "a.txt", "include": ["b"]},
+				{"name": "b", "include": ["c"]}, {"name": "c", "path": "c.txt", "include": ["a"]}]}`,
+		},
+		{name: "source without scheme", data: `{"lists": [{"name": "kids", "sources": ["example.com/list.txt"]}]}`},
+		{name: "invalid exclusion", data: `{"lists": [{"name": "kids", "path": "a.txt", "exclude": ["*.com"]}]}`},
+		{name: "zero remote timeout", data: `{"remote": {"timeout": "0s"}}`},
+		{name: "client with a space", data: `{"lists": [{"name": "kids", "path": "a.txt", "clients": ["my tablet"]}]}`},
+		{
+			name: "duplicate client",
+			data: `{"lists": [{"name": "kids", "path": "a.txt", "clients": ["tablet", "tablet"]}]}`,
+		},
+		{name: "public URL without scheme", data: `{"publicURL": "alotame:5963"}`},
+		{
+			name: "duplicate user",
+			data: `{"auth": {"users": [{"username": "a", "role": "admin"}, {"username": "a", "role": "viewer"}]}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			conf, err := LoadConfig(writeConfigFile(t, test.data, 0o600))
+
+			require.Error(t, err)
+			assert.Equal(t, DefaultConfig(), conf)
+		})
+	}
+}
+
+func TestLoadConfig_migrate_username(t *testing.T) {
+	t.Parallel()
+
+	conf, err := LoadConfig(writeConfigFile(t, `{"auth": {
+		"seed": "seed", "username": "alice", "recoveryCodes": ["hash"],
+		"users": [{"username": "bob", "role": "viewer"}]
+	}}`, 0o600))
+
+	require.NoError(t, err)
+	assert.Equal(t, []UserConfig{
+		{Username: "alice", Role: RoleAdmin, RecoveryCodes: []string{"hash"}},
+		{Username: "bob", Role: RoleViewer, RecoveryCodes: nil},
+	}, conf.Auth.Users)
+	assert.Empty(t, conf.Auth.Username)
+	assert.Empty(t, conf.Auth.RecoveryCodes)
+}
+
+func TestLoadConfig_missing_file(t *testing.T) {
+	t.Parallel()
+
+	_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.json"))
+
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestLoadConfig_directory(t *testing.T) {
+	t.Parallel()
+
+	_, err := LoadConfig(t.TempDir())
+
+	require.ErrorIs(t, err, ErrConfigNotRegular)
+}
+
+// ============================================================================
+//  Tests for JSON encoding
+// ============================================================================
+
+func TestConfig_json_round_trip(t *testing.T) {
+	t.Parallel()
+
+	conf := DefaultConfig()
+	conf.Server.ReadTimeout = 90 * time.Second
+	conf.AllowlistPath = "allowlist.txt"
+
+	data, err := json.Marshal(conf)
+
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"readTimeout":"1m30s"`)
+
+	decoded := DefaultConfig()
+
+	require.NoError(t, decodeStrict(data, &decoded))
+	assert.Equal(t, conf, decoded)
+}
+
+// ============================================================================
+//  Tests for SaveAuthConfig
+// ============================================================================
+
+func TestSaveAuthConfig(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfigFile(t, `{"server": {"port": "8080"}, "auth": {"seed": "old"}}`, 0o600)
+
+	err := SaveAuthConfig(path, testAuthConfig("new-seed", "alice"))
+	require.NoError(t, err)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, configFileMode, info.Mode().Perm())
+
+	conf, err := LoadConfig(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, "8080", conf.Server.Port, "other values must be kept")
+	assert.Equal(t, "new-seed", conf.Auth.Seed)
+	assert.Equal(t, testAuthConfig("new-seed", "alice").Users, conf.Auth.Users)
+}
+
+func TestSaveAuthConfig_new_file(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	require.NoError(t, SaveAuthConfig(path, testAuthConfig("seed", "alice")))
+
+	conf, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, "seed", conf.Auth.Seed)
+}
+
+func TestSaveAuthConfig_insecure_file(t *testing.T) {
+	t.Parallel()
+
+	if !checkFileModeSupported {
+		t.Skip("file mode is not supported on this platform")
+	}
+
+	path := writeConfigFile(t, `{}`, 0o644)
+
+	err := SaveAuthConfig(path, testAuthConfig("seed", "alice"))
+
+	require.ErrorIs(t, err, ErrConfigPermission)
+}
+
+// ============================================================================
+//  Test Helpers
+// ============================================================================
+
+// writeConfigFile writes the data to a config file with the given mode in a
+// temporary directory and returns its path.
+func writeConfigFile(t *testing.T, data string, mode os.FileMode) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	require.NoError(t, os.WriteFile(path, []byte(data), mode))
+	// WriteFile is affected by umask
+	require.NoError(t, os.Chmod(path, mode))
+
+	return path
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// checkFileModeSupported is true if the file mode and owner of the config file
+// can be checked on this platform.
+const checkFileModeSupported = true
+
+// isOwnedByCurrentUser returns true if the file is owned by the user running
+// the process.
+func isOwnedByCurrentUser(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	return ok && int(stat.Uid) == os.Getuid()
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Domain name limits (RFC 1035).
+const (
+	maxDomainLength = 253
+	maxLabelLength  = 63
+)
+
+// Errors returned by NormalizeDomain and reported in diagnostics.
+var (
+	ErrDomainEmpty           = errors.New("empty domain")
+	ErrDomainTooLong         = errors.New("domain exceeds 253 characters")
+	ErrDomainTrailingGarbage = errors.New("unexpected text after domain")
+	ErrDomainNumericTLD      = errors.New("top-level label is all numeric")
+	ErrDomainDuplicate       = errors.New("duplicate entry")
+	ErrLabelEmpty            = errors.New("empty label")
+	ErrLabelTooLong          = errors.New("label exceeds 63 characters")
+	ErrLabelHyphen           = errors.New("label starts or ends with a hyphen")
+	ErrLabelInvalidChar      = errors.New("label contains an invalid character")
+)
+
+// ============================================================================
+//  Types
+// ============================================================================
+
+// Severity is the severity of a Diagnostic.
+type Severity string
+
+// Severity levels of diagnostics.
+const (
+	// SeverityError means the line is dropped from the served allowlist.
+	SeverityError Severity = "error"
+	// SeverityWarning means the line is not broken but needs attention, such
+	// as duplicates and suspicious names. The line is dropped.
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic describes a problem found in a line of the allowlist source.
+type Diagnostic struct {
+	// Line is the 1-based line number in the source.
+	Line int
+	// Text is the original text of the line.
+	Text string
+	// Severity of the problem.
+	Severity Severity
+	// Err is the reason. Use errors.Is to check the kind of problem.
+	Err error
+}
+
+// String returns the diagnostic in "line N: severity: reason: text" format.
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("line %d: %s: %v: %q", d.Line, d.Severity, d.Err, d.Text)
+}
+
+// AllowlistEntry is a valid entry of the allowlist.
+type AllowlistEntry struct {
+	// Line is the 1-based line number in the source.
+	Line int
+	// Raw is the entry as written in the source, without comments.
+	Raw string
+	// Kind of the entry: domain, wildcard or regex.
+	Kind EntryKind
+	// Domain is the canonical form of the entry served to Blocky. The domain
+	// name, wildcard or regex. Non-ASCII names are in punycode form.
+	Domain string
+	// Unicode is the human-readable form of Domain. Same as Domain for
+	// ASCII-only names and regexes.
+	Unicode string
+	// Comment is the trailing comment of the line, if any.
+	Comment string
+}
+
+// ParsedAllowlist is the result of ParseAllowlist.
+type ParsedAllowlist struct {
+	Entries     []AllowlistEntry
+	Diagnostics []Diagnostic
+}
+
+// Bytes returns the canonical allowlist with one entry per line.
+func (p ParsedAllowlist) Bytes() []byte {
+	var buf bytes.Buffer
+
+	for _, entry := range p.Entries {
+		buf.WriteString(entry.Domain)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes()
+}
+
+// HasErrors returns true if any diagnostic has the SeverityError level.
+func (p ParsedAllowlist) HasErrors() bool {
+	for _, diag := range p.Diagnostics {
+		if diag.Severity == SeverityError {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ============================================================================
+//  Parser
+// ============================================================================
+
+// ParseAllowlist parses the raw allowlist into canonical entries.
+//
+// Each line is a domain name, a wildcard ("*.example.com") or a regex enclosed
+// in slashes ("/^cdn[0-9]+\.example\.com$/") as in Blocky's list format.
+// Empty lines and lines starting with "#" are ignored. A "#" after the entry
+// starts a trailing comment. Invalid entries are dropped and duplicates are
+// removed, both reported as diagnostics with the line number and reason.
+func ParseAllowlist(data []byte) ParsedAllowlist {
+	var parsed ParsedAllowlist
+
+	seen := make(map[string]int) // canonical domain -> line number
+
+	for idx, text := range strings.Split(string(data), "\n") {
+		lineNum := idx + 1
+		text = strings.TrimSuffix(text, "\r")
+
+		entry, ok, err := parseLine(text)
+		if err != nil {
+			parsed.Diagnostics = append(parsed.Diagnostics, Diagnostic{
+				Line: lineNum, Text: text, Severity: severityOf(err), Err: err,
+			})
+
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		if first, found := seen[entry.Domain]; found {
+			parsed.Diagnostics = append(parsed.Diagnostics, Diagnostic{
+				Line: lineNum, Text: text, Severity: SeverityWarning,
+				Err: fmt.Errorf("%w of line %d", ErrDomainDuplicate, first),
+			})
+
+			continue
+		}
+
+		entry.Line = lineNum
+		seen[entry.Domain] = lineNum
+		parsed.Entries = append(parsed.Entries, entry)
+	}
+
+	return parsed
+}
+
+// parseLine parses a line of the allowlist. It returns false if the line has
+// no entry, such as empty or comment lines.
+func parseLine(text string) (AllowlistEntry, bool, error) {
+	raw, comment, err := splitComment(strings.TrimSpace(text))
+	if err != nil {
+		return AllowlistEntry{}, false, err
+	}
+
+	if raw == "" {
+		return AllowlistEntry{}, false, nil
+	}
+
+	if strings.ContainsAny(raw, " \t") {
+		return AllowlistEntry{}, false, ErrDomainTrailingGarbage
+	}
+
+	entry := AllowlistEntry{Line: 0, Raw: raw, Kind: EntryDomain, Domain: "", Unicode: "", Comment: comment}
+
+	switch {
+	case strings.HasPrefix(raw, "/"):
+		entry.Kind = EntryRegex
+		entry.Domain, err = raw, ValidateRegex(raw)
+	case strings.Contains(raw, "*"):
+		entry.Kind = EntryWildcard
+		entry.Domain, err = NormalizeWildcard(raw)
+	default:
+		entry.Domain, err = NormalizeDomain(raw)
+	}
+
+	if err != nil {
+		return AllowlistEntry{}, false, err
+	}
+
+	entry.Unicode = entry.Domain
+	if entry.Kind != EntryRegex && isIDN(entry.Domain) {
+		entry.Unicode = idnToUnicode(entry.Domain)
+	}
+
+	return entry, true, nil
+}
+
+// splitComment splits the line into the entry and the trailing comment. A
+// regex may contain "#", so the regex is cut before looking for the comment.
+func splitComment(line string) (string, string, error) {
+	entry, rest := "", line
+
+	if strings.HasPrefix(line, "/") {
+		var err error
+
+		entry, rest, err = cutRegex(line)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	extra, comment, _ := strings.Cut(rest, "#")
+
+	return strings.TrimSpace(entry + extra), strings.TrimSpace(comment), nil
+}
+
+// severityOf returns the severity of the error found while parsing a line.
+func severityOf(err error) Severity {
+	if errors.Is(err, ErrDomainMixedScript) || errors.Is(err, ErrDomainConfusable) {
+		return SeverityWarning
+	}
+
+	return SeverityError
+}
+
+// ============================================================================
+//  Validation and Normalization
+// ============================================================================
+
+// NormalizeDomain validates the domain name and returns its canonical form:
+// lowercased, without the trailing dot and internationalized names converted
+// to punycode.
+func NormalizeDomain(raw string) (string, error) {
+	domain := strings.ToLower(strings.TrimSpace(raw))
+	domain = strings.TrimSuffix(domain, ".")
+
+	if domain == "" {
+		return "", ErrDomainEmpty
+	}
+
+	if isIDN(domain) {
+		ascii, err := idnToASCII(domain)
+		if err != nil {
+			return "", err
+		}
+
+		domain = ascii
+	}
+
+	if len(domain) > maxDomainLength {
+		return "", ErrDomainTooLong
+	}
+
+	labels := strings.Split(domain, ".")
+
+	for _, label := range labels {
+		err := validateLabel(label)
+		if err != nil {
+			return "", fmt.Errorf("%w: %q", err, label)
+		}
+	}
+
+	if isNumeric(labels[len(labels)-1]) {
+		return "", ErrDomainNumericTLD
+	}
+
+	return domain, nil
+}
+
+// validateLabel checks a lowercased label of a domain name. Letters, digits,
+// hyphens and underscores (used by service names such as "_dmarc") are
+// allowed.
+func validateLabel(label string) error {
+	if label == "" {
+		return ErrLabelEmpty
+	}
+
+	if len(label) > maxLabelLength {
+		return ErrLabelTooLong
+	}
+
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return ErrLabelHyphen
+	}
+
+	for _, char := range label {
+		isAllowed := (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') ||
+			char == '-' || char == '_'
+		if !isAllowed {
+			return ErrLabelInvalidChar
+		}
+	}
+
+	return nil
+}
+
+func isNumeric(label string) bool {
+	for _, char := range label {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+
+	return label != ""
+}
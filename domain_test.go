@@ -0,0 +1,164 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for NormalizeDomain
+// ============================================================================
+
+func TestNormalizeDomain_valid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{input: "github.com", expect: "github.com"},
+		{input: "GitHub.com", expect: "github.com"},
+		{input: "github.com.", expect: "github.com"},
+		{input: "  Example.COM.  ", expect: "example.com"},
+		{input: "_dmarc.example.com", expect: "_dmarc.example.com"},
+		{input: "xn--r8jz45g.jp", expect: "xn--r8jz45g.jp"},
+		{input: "localhost", expect: "localhost"},
+		{input: "1.example.com", expect: "1.example.com"},
+		{input: strings.Repeat("a", maxLabelLength) + ".com", expect: strings.Repeat("a", maxLabelLength) + ".com"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := NormalizeDomain(test.input)
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, actual)
+		})
+	}
+}
+
+func TestNormalizeDomain_invalid(t *testing.T) {
+	t.Parallel()
+
+	longDomain := strings.Repeat(strings.Repeat("a", 50)+".", 5) + "com"
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr error
+	}{
+		{name: "empty", input: "", wantErr: ErrDomainEmpty},
+		{name: "dot only", input: ".", wantErr: ErrDomainEmpty},
+		{name: "too long", input: longDomain, wantErr: ErrDomainTooLong},
+		{name: "empty label", input: "example..com", wantErr: ErrLabelEmpty},
+		{name: "leading dot", input: ".example.com", wantErr: ErrLabelEmpty},
+		{name: "label too long", input: strings.Repeat("a", maxLabelLength+1) + ".com", wantErr: ErrLabelTooLong},
+		{name: "leading hyphen", input: "-example.com", wantErr: ErrLabelHyphen},
+		{name: "trailing hyphen", input: "example-.com", wantErr: ErrLabelHyphen},
+		{name: "invalid char", input: "exa$mple.com", wantErr: ErrLabelInvalidChar},
+		{name: "URL", input: "https://example.com/", wantErr: ErrLabelInvalidChar},
+		{name: "IP address", input: "192.168.0.1", wantErr: ErrDomainNumericTLD},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := NormalizeDomain(test.input)
+
+			require.ErrorIs(t, err, test.wantErr)
+			assert.Empty(t, actual)
+		})
+	}
+}
+
+// ============================================================================
+//  Tests for ParseAllowlist
+// ============================================================================
+
+func TestParseAllowlist(t *testing.T) {
+	t.Parallel()
+
+	data := strings.Join([]string{
+		"# Sample Allowlist",     // 1: comment
+		"github.com",             // 2: valid
+		"GitHub.com",             // 3: duplicate of line 2
+		"github.com.",            // 4: duplicate of line 2
+		"",                       // 5: empty
+		"Example.com # our site", // 6: valid with comment
+		"yahoo.com foo",          // 7: trailing garbage
+		"bad..domain",            // 8: invalid
+		"  yahoo.com\r",          // 9: valid with CRLF
+		"",                       // 10: end of file
+	}, "\n")
+
+	parsed := ParseAllowlist([]byte(data))
+
+	require.Len(t, parsed.Entries, 3)
+
+	expectEntries := []AllowlistEntry{
+		{Line: 2, Raw: "github.com", Kind: EntryDomain, Domain: "github.com", Unicode: "github.com", Comment: ""},
+		{Line: 6, Raw: "Example.com", Kind: EntryDomain, Domain: "example.com", Unicode: "example.com", Comment: "our site"},
+		{Line: 9, Raw: "yahoo.com", Kind: EntryDomain, Domain: "yahoo.com", Unicode: "yahoo.com", Comment: ""},
+	}
+
+	assert.Equal(t, expectEntries, parsed.Entries)
+
+	require.Len(t, parsed.Diagnostics, 4)
+
+	expect := []struct {
+		line     int
+		severity Severity
+		err      error
+	}{
+		{line: 3, severity: SeverityWarning, err: ErrDomainDuplicate},
+		{line: 4, severity: SeverityWarning, err: ErrDomainDuplicate},
+		{line: 7, severity: SeverityError, err: ErrDomainTrailingGarbage},
+		{line: 8, severity: SeverityError, err: ErrLabelEmpty},
+	}
+
+	for idx, exp := range expect {
+		diag := parsed.Diagnostics[idx]
+
+		assert.Equal(t, exp.line, diag.Line)
+		assert.Equal(t, exp.severity, diag.Severity)
+		require.ErrorIs(t, diag.Err, exp.err)
+	}
+
+	assert.Contains(t, parsed.Diagnostics[0].Err.Error(), "of line 2")
+	assert.True(t, parsed.HasErrors())
+	assert.Equal(t, "github.com\nexample.com\nyahoo.com\n", string(parsed.Bytes()))
+}
+
+func TestParseAllowlist_no_errors(t *testing.T) {
+	t.Parallel()
+
+	parsed := ParseAllowlist([]byte(allowlist))
+
+	assert.False(t, parsed.HasErrors())
+	assert.Empty(t, parsed.Diagnostics)
+	assert.Equal(t, "github.com\nexample.com\nyahoo.com\n", string(parsed.Bytes()))
+}
+
+func TestParseAllowlist_empty(t *testing.T) {
+	t.Parallel()
+
+	parsed := ParseAllowlist(nil)
+
+	assert.Empty(t, parsed.Entries)
+	assert.Empty(t, parsed.Diagnostics)
+	assert.Empty(t, parsed.Bytes())
+}
+
+func TestDiagnostic_String(t *testing.T) {
+	t.Parallel()
+
+	diag := Diagnostic{Line: 7, Text: "yahoo.com foo", Severity: SeverityError, Err: ErrDomainTrailingGarbage}
+
+	assert.Equal(t, `line 7: error: unexpected text after domain: "yahoo.com foo"`, diag.String())
+}
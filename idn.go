@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+// Errors of internationalized domain names.
+var (
+	ErrDomainIDN         = errors.New("invalid internationalized domain name")
+	ErrDomainMixedScript = errors.New("label mixes scripts")
+	ErrDomainConfusable  = errors.New("label is confusable with Latin letters")
+)
+
+// idnaProfile converts internationalized domain names for DNS lookup. It is
+// the same as idna.Lookup but allows underscores like the ASCII validation.
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.Transitional(false),
+	idna.StrictDomainName(false),
+)
+
+// Script combinations allowed in a label besides a single script. These are
+// the "Highly Restrictive" sets of Unicode Technical Standard #39.
+var allowedScriptSets = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"}, // Japanese
+	{"Latin", "Han", "Bopomofo"},             // Chinese
+	{"Latin", "Han", "Hangul"},               // Korean
+}
+
+// latinLookalikes are Cyrillic and Greek letters that look like Latin letters.
+// A label made only of these letters is likely a spoof such as "аррӏе".
+const latinLookalikes = "аеорсухіјѕԁӏԛԝвкмнтьзбѵһ" + "αορνικτυχεβηζ"
+
+// ============================================================================
+//  Functions
+// ============================================================================
+
+// isIDN returns true if the domain has non-ASCII characters or punycode
+// labels.
+func isIDN(domain string) bool {
+	for _, char := range domain {
+		if char > unicode.MaxASCII {
+			return true
+		}
+	}
+
+	return strings.HasPrefix(domain, "xn--") || strings.Contains(domain, ".xn--")
+}
+
+// idnToASCII converts the internationalized domain name to its punycode form
+// as Blocky matches on the ASCII wire form. For example "例え.jp" becomes
+// "xn--r8jz45g.jp".
+//
+// Labels mixing scripts or confusable with Latin letters are rejected with
+// ErrDomainMixedScript or ErrDomainConfusable.
+func idnToASCII(domain string) (string, error) {
+	ascii, err := idnaProfile.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrDomainIDN, err)
+	}
+
+	uni, err := idnaProfile.ToUnicode(ascii)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrDomainIDN, err)
+	}
+
+	for label := range strings.SplitSeq(uni, ".") {
+		err = checkScripts(label)
+		if err != nil {
+			return "", fmt.Errorf("%w: %q (%s)", err, uni, ascii)
+		}
+	}
+
+	return ascii, nil
+}
+
+// idnToUnicode returns the Unicode form of the domain for display. It returns
+// the domain as is if it cannot be converted.
+func idnToUnicode(domain string) string {
+	uni, err := idnaProfile.ToUnicode(domain)
+	if err != nil {
+		return domain
+	}
+
+	return uni
+}
+
+// checkScripts checks that the label does not mix scripts other than the
+// allowed combinations and is not made only of Latin look-alike letters.
+func checkScripts(label string) error {
+	scripts := labelScripts(label)
+	if len(scripts) > 1 && !isAllowedScriptSet(scripts) {
+		return fmt.Errorf("%w (%s)", ErrDomainMixedScript, strings.Join(scripts, ", "))
+	}
+
+	if len(scripts) == 1 && (scripts[0] == "Cyrillic" || scripts[0] == "Greek") &&
+		strings.Trim(label, latinLookalikes+"0123456789-") == "" {
+		return ErrDomainConfusable
+	}
+
+	return nil
+}
+
+// labelScripts returns the sorted names of the scripts used in the label,
+// ignoring characters common to all scripts such as digits and hyphens.
+func labelScripts(label string) []string {
+	found := make(map[string]struct{})
+
+	for _, char := range label {
+		if unicode.In(char, unicode.Common, unicode.Inherited) {
+			continue
+		}
+
+		for name, table := range unicode.Scripts {
+			if unicode.Is(table, char) {
+				found[name] = struct{}{}
+
+				break
+			}
+		}
+	}
+
+	scripts := make([]string, 0, len(found))
+	for name := range found {
+		scripts = append(scripts, name)
+	}
+
+	slices.Sort(scripts)
+
+	return scripts
+}
+
+func isAllowedScriptSet(scripts []string) bool {
+	for _, allowed := range allowedScriptSets {
+		isSubset := true
+
+		for _, script := range scripts {
+			if !slices.Contains(allowed, script) {
+				isSubset = false
+
+				break
+			}
+		}
+
+		if isSubset {
+			return true
+		}
+	}
+
+	return false
+}
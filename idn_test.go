@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for IDN conversion
+// ============================================================================
+
+func TestNormalizeDomain_idn(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{input: "例え.jp", expect: "xn--r8jz45g.jp"},
+		{input: "例え.JP.", expect: "xn--r8jz45g.jp"},
+		{input: "XN--R8JZ45G.jp", expect: "xn--r8jz45g.jp"},
+		{input: "ドメイン名例.jp", expect: "xn--eckwd4c7cu47r2wf.jp"},
+		{input: "日本語jp.example", expect: "xn--jp-5t7du0ck91h.example"},
+		{input: "bücher.de", expect: "xn--bcher-kva.de"},
+		{input: "Ｅｘａｍｐｌｅ.com", expect: "example.com"}, // full-width letters
+		{input: "한국.kr", expect: "xn--3e0b707e.kr"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := NormalizeDomain(test.input)
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, actual)
+		})
+	}
+}
+
+func TestNormalizeDomain_idn_rejected(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr error
+	}{
+		{name: "Latin and Cyrillic", input: "pаypal.com", wantErr: ErrDomainMixedScript},
+		{name: "Latin and Greek", input: "gοοgle.com", wantErr: ErrDomainMixedScript},
+		{name: "Cyrillic look-alike", input: "аррӏе.com", wantErr: ErrDomainConfusable},
+		{name: "punycode look-alike", input: "xn--80ak6aa92e.com", wantErr: ErrDomainConfusable},
+		{name: "invalid punycode", input: "xn--a.com", wantErr: ErrDomainIDN},
+		{name: "bidi rule", input: "\u05d0a.com", wantErr: ErrDomainIDN},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := NormalizeDomain(test.input)
+
+			require.ErrorIs(t, err, test.wantErr)
+			assert.Empty(t, actual)
+		})
+	}
+}
+
+func TestNormalizeDomain_idn_error_shows_both_forms(t *testing.T) {
+	t.Parallel()
+
+	_, err := NormalizeDomain("pаypal.com")
+
+	require.ErrorIs(t, err, ErrDomainMixedScript)
+	assert.Contains(t, err.Error(), "pаypal.com")
+	assert.Contains(t, err.Error(), "xn--pypal-4ve.com")
+	assert.Contains(t, err.Error(), "Cyrillic, Latin")
+}
+
+func TestParseAllowlist_idn(t *testing.T) {
+	t.Parallel()
+
+	data := "例え.jp\nxn--r8jz45g.jp\npаypal.com\nexample.com\n"
+
+	parsed := ParseAllowlist([]byte(data))
+
+	require.Len(t, parsed.Entries, 2)
+	assert.Equal(t, "xn--r8jz45g.jp", parsed.Entries[0].Domain)
+	assert.Equal(t, "例え.jp", parsed.Entries[0].Unicode)
+	assert.Equal(t, "example.com", parsed.Entries[1].Unicode)
+
+	require.Len(t, parsed.Diagnostics, 2)
+	require.ErrorIs(t, parsed.Diagnostics[0].Err, ErrDomainDuplicate)
+	require.ErrorIs(t, parsed.Diagnostics[1].Err, ErrDomainMixedScript)
+	assert.Equal(t, SeverityWarning, parsed.Diagnostics[1].Severity)
+	assert.Equal(t, "xn--r8jz45g.jp\nexample.com\n", string(parsed.Bytes()))
+}
+
+func TestIdnToUnicode(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "例え.jp", idnToUnicode("xn--r8jz45g.jp"))
+	assert.Equal(t, "example.com", idnToUnicode("example.com"))
+	assert.Equal(t, "xn--a.com", idnToUnicode("xn--a.com"), "invalid input should be returned as is")
+}
+
+func TestLabelScripts(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"Han", "Hiragana", "Latin"}, labelScripts("日本のsite-1"))
+	assert.Empty(t, labelScripts("123-456"))
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// Systemd socket activation (see sd_listen_fds(3)).
+const (
+	// listenFdsStart is the first file descriptor passed by systemd.
+	listenFdsStart = 3
+	envListenPID   = "LISTEN_PID"
+	envListenFDs   = "LISTEN_FDS"
+	envListenNames = "LISTEN_FDNAMES"
+)
+
+// unixSocketModeDefault is the default file mode of the Unix domain socket.
+// Only the owner and the group (e.g. the reverse proxy) can connect.
+const unixSocketModeDefault os.FileMode = 0o660
+
+// Errors of listeners.
+var (
+	ErrSocketPathInUse = errors.New("unix socket path exists and is not a socket")
+	ErrListenFDs       = errors.New("invalid systemd socket activation environment")
+)
+
+// ============================================================================
+//  Listeners
+// ============================================================================
+
+// listen opens the listeners to serve on. In order of priority:
+//
+//  1. Listeners passed by systemd socket activation (see systemdListeners)
+//  2. Unix domain socket at conf.UnixSocket
+//  3. TCP at conf.Addr()
+func listen(ctx context.Context, conf ServerConfig, activated []net.Listener) ([]net.Listener, error) {
+	if len(activated) > 0 {
+		slog.Info("using systemd socket activation", "count", len(activated))
+
+		return activated, nil
+	}
+
+	if conf.UnixSocket != "" {
+		listener, err := listenUnix(ctx, conf)
+		if err != nil {
+			return nil, err
+		}
+
+		return []net.Listener{listener}, nil
+	}
+
+	listener, err := new(net.ListenConfig).Listen(ctx, "tcp", conf.Addr())
+	if err != nil {
+		return nil, wrapError(err, "failed to listen")
+	}
+
+	return []net.Listener{listener}, nil
+}
+
+// listenUnix listens on the Unix domain socket and sets its mode and owner.
+// A stale socket file left by a previous run is removed.
+func listenUnix(ctx context.Context, conf ServerConfig) (net.Listener, error) {
+	path := conf.UnixSocket
+
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, wrapError(ErrSocketPathInUse, path)
+		}
+
+		err = os.Remove(path)
+		if err != nil {
+			return nil, wrapError(err, "failed to remove stale socket")
+		}
+	}
+
+	listener, err := new(net.ListenConfig).Listen(ctx, "unix", path)
+	if err != nil {
+		return nil, wrapError(err, "failed to listen on unix socket")
+	}
+
+	err = os.Chmod(path, conf.UnixSocketMode)
+	if err == nil && conf.UnixSocketOwner != "" {
+		err = chownSocket(path, conf.UnixSocketOwner)
+	}
+
+	if err != nil {
+		_ = listener.Close() // also removes the socket file
+
+		return nil, wrapError(err, "failed to set unix socket permission")
+	}
+
+	return listener, nil
+}
+
+// chownSocket changes the owner of the socket. The owner is given as "user",
+// "user:group" or ":group". Names and numeric IDs are accepted.
+func chownSocket(path, owner string) error {
+	userName, groupName, _ := strings.Cut(owner, ":")
+	uid, gid := -1, -1
+
+	if userName != "" {
+		usr, err := user.Lookup(userName)
+		if err != nil {
+			usr, err = user.LookupId(userName)
+		}
+
+		if err != nil {
+			return wrapError(err, "unknown user")
+		}
+
+		uid, _ = strconv.Atoi(usr.Uid)
+	}
+
+	if groupName != "" {
+		grp, err := user.LookupGroup(groupName)
+		if err != nil {
+			grp, err = user.LookupGroupId(groupName)
+		}
+
+		if err != nil {
+			return wrapError(err, "unknown group")
+		}
+
+		gid, _ = strconv.Atoi(grp.Gid)
+	}
+
+	return wrapError(os.Chown(path, uid, gid), "failed to change owner")
+}
+
+// ============================================================================
+//  Systemd Socket Activation
+// ============================================================================
+
+// systemdListeners returns the listeners passed by systemd socket activation
+// grouped by server name. It returns nil if the process was not socket
+// activated.
+//
+// Sockets named "admin" by FileDescriptorName= in LISTEN_FDNAMES are for the
+// admin server and all the others are for the public server.
+//
+// The LISTEN_* variables are unset so that child processes do not inherit
+// them.
+func systemdListeners(pid int, getenv func(string) string) (map[string][]net.Listener, error) {
+	count, err := parseListenFDs(pid, getenv)
+	if err != nil || count == 0 {
+		return nil, err
+	}
+
+	names := strings.Split(getenv(envListenNames), ":")
+
+	for _, key := range []string{envListenPID, envListenFDs, envListenNames} {
+		_ = os.Unsetenv(key)
+	}
+
+	files := make([]*os.File, count)
+	for idx := range files {
+		fd := listenFdsStart + idx
+		files[idx] = os.NewFile(uintptr(fd), "listen-fd-"+strconv.Itoa(fd))
+	}
+
+	listeners, err := listenersFromFiles(files)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupListeners(listeners, names), nil
+}
+
+// groupListeners groups the listeners by server name according to the
+// LISTEN_FDNAMES names of the same index.
+func groupListeners(listeners []net.Listener, names []string) map[string][]net.Listener {
+	grouped := make(map[string][]net.Listener)
+
+	for idx, listener := range listeners {
+		name := serverPublic
+		if idx < len(names) && names[idx] == serverAdmin {
+			name = serverAdmin
+		}
+
+		grouped[name] = append(grouped[name], listener)
+	}
+
+	return grouped
+}
+
+// parseListenFDs returns the number of file descriptors passed by systemd to
+// the process of the given pid.
+func parseListenFDs(pid int, getenv func(string) string) (int, error) {
+	pidStr, fdsStr := getenv(envListenPID), getenv(envListenFDs)
+	if pidStr == "" || fdsStr == "" {
+		return 0, nil
+	}
+
+	// The variables are for another process, such as our parent
+	if pidStr != strconv.Itoa(pid) {
+		return 0, nil
+	}
+
+	count, err := strconv.Atoi(fdsStr)
+	if err != nil || count < 0 {
+		return 0, fmt.Errorf("%w: %s=%q", ErrListenFDs, envListenFDs, fdsStr)
+	}
+
+	return count, nil
+}
+
+// listenersFromFiles returns listeners for the given socket files. The files
+// are closed.
+func listenersFromFiles(files []*os.File) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(files))
+
+	for _, file := range files {
+		// FileListener duplicates the fd, so the original is closed
+		listener, err := net.FileListener(file)
+		_ = file.Close()
+
+		if err != nil {
+			closeListeners(listeners)
+
+			return nil, wrapError(err, "invalid socket passed by systemd")
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// listenerURL returns the URL of the path on the listener for logging.
+func listenerURL(listener net.Listener, isTLS bool, path string) string {
+	addr := listener.Addr()
+	if addr.Network() != "tcp" {
+		return addr.Network() + ":" + addr.String()
+	}
+
+	if isTLS {
+		return "https://" + addr.String() + path
+	}
+
+	return "http://" + addr.String() + path
+}
+
+// closeListeners closes the listeners that are not served yet.
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		_ = listener.Close()
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for Unix domain socket
+// ============================================================================
+
+func TestListenUnix(t *testing.T) {
+	t.Parallel()
+
+	conf := DefaultServerConfig()
+	conf.UnixSocket = shortSocketPath(t)
+	conf.UnixSocketMode = 0o600
+
+	listener, err := listenUnix(context.Background(), conf)
+	require.NoError(t, err)
+
+	info, err := os.Lstat(conf.UnixSocket)
+	require.NoError(t, err)
+
+	assert.Equal(t, fs.ModeSocket, info.Mode().Type())
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	assert.Equal(t, "unix:"+conf.UnixSocket, listenerURL(listener, false, "/allowlist.txt"))
+
+	require.NoError(t, listener.Close())
+
+	_, err = os.Lstat(conf.UnixSocket)
+	require.ErrorIs(t, err, os.ErrNotExist, "socket file should be removed on close")
+}
+
+func TestListenUnix_owner(t *testing.T) {
+	t.Parallel()
+
+	conf := DefaultServerConfig()
+	conf.UnixSocket = shortSocketPath(t)
+	conf.UnixSocketOwner = strconv.Itoa(os.Getuid()) + ":" + strconv.Itoa(os.Getgid())
+
+	listener, err := listenUnix(context.Background(), conf)
+	require.NoError(t, err)
+
+	defer listener.Close()
+
+	conf.UnixSocketOwner = "no-such-user-for-alotame"
+
+	_, err = listenUnix(context.Background(), conf)
+	require.Error(t, err)
+}
+
+func TestListenUnix_stale_socket(t *testing.T) {
+	t.Parallel()
+
+	path := shortSocketPath(t)
+
+	// Leave a stale socket file behind
+	stale, err := new(net.ListenConfig).Listen(context.Background(), "unix", path)
+	require.NoError(t, err)
+
+	stale.(*net.UnixListener).SetUnlinkOnClose(false) //nolint:forcetypeassert // always a unix listener
+	require.NoError(t, stale.Close())
+
+	conf := DefaultServerConfig()
+	conf.UnixSocket = path
+
+	listener, err := listenUnix(context.Background(), conf)
+
+	require.NoError(t, err)
+	require.NoError(t, listener.Close())
+}
+
+func TestListenUnix_path_in_use(t *testing.T) {
+	t.Parallel()
+
+	conf := DefaultServerConfig()
+	conf.UnixSocket = writeTempFile(t, "not a socket")
+
+	_, err := listenUnix(context.Background(), conf)
+
+	require.ErrorIs(t, err, ErrSocketPathInUse)
+}
+
+func TestRun_unix_socket(t *testing.T) {
+	t.Parallel()
+
+	prov := &fakeAllowlistProvider{
+		data:    []byte("test.com\n"),
+		hash:    fastHash("test.com\n"),
+		getErr:  nil,
+		hashErr: nil,
+	}
+	conf := DefaultConfig()
+	conf.Server.UnixSocket = shortSocketPath(t)
+	conf.Server.ShutdownTimeout = 1 * time.Second
+	conf.Admin.UnixSocket = shortSocketPath(t)
+	conf.Admin.ShutdownTimeout = 1 * time.Second
+
+	quit := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- run(prov, testAdminServices(t, "", conf), conf, quit)
+	}()
+
+	transport := new(http.Transport)
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return new(net.Dialer).DialContext(ctx, "unix", conf.Server.UnixSocket)
+	}
+
+	client := new(http.Client)
+	client.Transport = transport
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://alotame/allowlist.txt", nil)
+	require.NoError(t, err)
+
+	// The socket file may exist before the server accepts connections on it
+	var resp *http.Response
+
+	require.Eventually(t, func() bool {
+		resp, err = client.Do(req)
+
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	assert.Equal(t, "test.com\n", string(body))
+
+	quit <- os.Interrupt
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return in time")
+	}
+}
+
+// ============================================================================
+//  Tests for systemd socket activation
+// ============================================================================
+
+func TestParseListenFDs(t *testing.T) {
+	t.Parallel()
+
+	pid := 1234
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		expect  int
+		wantErr bool
+	}{
+		{name: "not activated", env: nil, expect: 0, wantErr: false},
+		{name: "activated", env: map[string]string{envListenPID: "1234", envListenFDs: "2"}, expect: 2, wantErr: false},
+		{name: "other process", env: map[string]string{envListenPID: "1", envListenFDs: "2"}, expect: 0, wantErr: false},
+		{name: "invalid count", env: map[string]string{envListenPID: "1234", envListenFDs: "x"}, expect: 0, wantErr: true},
+		{name: "negative", env: map[string]string{envListenPID: "1234", envListenFDs: "-1"}, expect: 0, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			count, err := parseListenFDs(pid, fakeEnv(test.env))
+
+			if test.wantErr {
+				require.ErrorIs(t, err, ErrListenFDs)
+			} else {
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, test.expect, count)
+		})
+	}
+}
+
+func TestSystemdListeners_not_activated(t *testing.T) {
+	t.Parallel()
+
+	listeners, err := systemdListeners(os.Getpid(), fakeEnv(nil))
+
+	require.NoError(t, err)
+	assert.Empty(t, listeners)
+}
+
+func TestListenersFromFiles(t *testing.T) {
+	t.Parallel()
+
+	// Simulate a socket passed by systemd
+	orig, err := new(net.ListenConfig).Listen(context.Background(), "tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer orig.Close()
+
+	file, err := orig.(*net.TCPListener).File() //nolint:forcetypeassert // always a TCP listener
+	require.NoError(t, err)
+
+	listeners, err := listenersFromFiles([]*os.File{file})
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+
+	defer listeners[0].Close()
+
+	assert.Equal(t, orig.Addr().String(), listeners[0].Addr().String())
+	assert.Equal(t, "http://"+orig.Addr().String()+"/allowlist.txt", listenerURL(listeners[0], false, "/allowlist.txt"))
+}
+
+func TestListenersFromFiles_not_socket(t *testing.T) {
+	t.Parallel()
+
+	file, err := os.Open(writeTempFile(t, "not a socket"))
+	require.NoError(t, err)
+
+	_, err = listenersFromFiles([]*os.File{file})
+
+	require.Error(t, err)
+}
+
+// ============================================================================
+//  Test Helpers
+// ============================================================================
+
+// shortSocketPath returns a socket path in a temporary directory. Unix socket
+// paths are limited to about 100 bytes, so t.TempDir may be too long.
+func shortSocketPath(t *testing.T) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "alotame")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	return filepath.Join(dir, "alotame.sock")
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Path of the named allowlists on the public server. Each list is served at
+// /lists/{name}.txt.
+const (
+	listsPathPrefix = "/lists/"
+	listsPathSuffix = ".txt"
+)
+
+// listNamePattern is the pattern of a list name. The name is a part of the URL
+// and of the Blocky config, so it is kept simple.
+var listNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Errors of the named allowlists.
+var (
+	ErrListName     = errors.New("list name must be lowercase letters, digits, '-' or '_'")
+	ErrListNotFound = errors.New("list not found")
+	ErrListCycle    = errors.New("lists include each other")
+)
+
+// ============================================================================
+//  List Set
+// ============================================================================
+
+// ListSet holds the named allowlists, such as one per client group of Blocky.
+// The files of the lists are reloaded on change like the main allowlist, and
+// the remote sources are fetched at the refresh interval.
+type ListSet struct {
+	lists  []*NamedList
+	byName map[string]*NamedList
+	// sources are the remote sources of all the lists. A URL used by several
+	// lists is fetched once.
+	sources []*RemoteAllowlistProvider
+}
+
+// NewListSet returns the lists of the config. The config must be valid. The
+// files are not read until Reload or the first Snapshot of a list, and the
+// remote sources are not fetched until Reload.
+func NewListSet(confs []ListConfig, remote RemoteConfig) *ListSet {
+	set := new(ListSet)
+	set.byName = make(map[string]*NamedList, len(confs))
+
+	sources := make(map[string]*RemoteAllowlistProvider)
+
+	for _, conf := range confs {
+		list := new(NamedList)
+		list.name = conf.Name
+		list.base = conf.Base
+		list.clients = conf.Clients
+
+		if conf.Path != "" {
+			list.file = NewReloadingAllowlistProvider(NewFileAllowlistProvider(conf.Path), nil)
+		}
+
+		for _, url := range conf.Sources {
+			source, ok := sources[url]
+			if !ok {
+				source = NewRemoteAllowlistProvider(url, remote)
+				sources[url] = source
+				set.sources = append(set.sources, source)
+			}
+
+			list.sources = append(list.sources, source)
+		}
+
+		for _, raw := range conf.Exclude {
+			// Exclusions are validated with the config
+			if entry, ok, err := parseLine(raw); ok && err == nil {
+				list.exclude = append(list.exclude, entry)
+			}
+		}
+
+		set.lists = append(set.lists, list)
+		set.byName[conf.Name] = list
+	}
+
+	for idx, conf := range confs {
+		for _, name := range conf.Include {
+			set.lists[idx].include = append(set.lists[idx].include, set.byName[name])
+		}
+	}
+
+	return set
+}
+
+// Lists returns the lists in the configured order.
+func (s *ListSet) Lists() []*NamedList {
+	return s.lists
+}
+
+// Get returns the list of the name.
+func (s *ListSet) Get(name string) (*NamedList, bool) {
+	list, ok := s.byName[name]
+
+	return list, ok
+}
+
+// Reload reads all the list files and fetches the remote sources. The errors
+// are logged and returned joined, and the files that failed are read again on
+// the next change and the sources on the next retry.
+func (s *ListSet) Reload(ctx context.Context) error {
+	errs := make([]error, 0, len(s.lists))
+	sourceErrs := make([]error, len(s.sources))
+
+	var wg sync.WaitGroup
+
+	for idx, source := range s.sources {
+		wg.Go(func() { sourceErrs[idx] = wrapError(source.Reload(ctx), "source "+source.URL()) })
+	}
+
+	for _, list := range s.lists {
+		if list.file != nil {
+			errs = append(errs, wrapError(list.file.Reload(ctx), "list "+list.name))
+		}
+	}
+
+	wg.Wait()
+
+	return errors.Join(append(errs, sourceErrs...)...)
+}
+
+// Watch reloads each list file whenever it changes and refreshes the remote
+// sources. It blocks until the context is canceled.
+func (s *ListSet) Watch(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for _, list := range s.lists {
+		if list.file != nil {
+			wg.Go(func() { list.file.Watch(ctx) })
+		}
+	}
+
+	for _, source := range s.sources {
+		wg.Go(func() { source.Watch(ctx) })
+	}
+
+	wg.Wait()
+}
+
+// ServeHTTP serves the list of the "file" path value, such as "kids.txt".
+func (s *ListSet) ServeHTTP(resWriter http.ResponseWriter, req *http.Request) {
+	name, ok := strings.CutSuffix(req.PathValue("file"), listsPathSuffix)
+
+	list, found := s.byName[name]
+	if !ok || !found {
+		http.NotFound(resWriter, req)
+
+		return
+	}
+
+	newAllowlistHandler(list)(resWriter, req)
+}
+
+// findIncludeCycle returns the names of the lists of an include cycle, from
+// and back to the same list, or nil if there is none.
+func findIncludeCycle(confs []ListConfig) []string {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	includes := make(map[string][]string, len(confs))
+	for _, conf := range confs {
+		includes[conf.Name] = conf.Include
+	}
+
+	state := make(map[string]int, len(confs))
+	path := make([]string, 0, len(confs))
+
+	var visit func(name string) []string
+
+	visit = func(name string) []string {
+		switch state[name] {
+		case visiting:
+			return append(slices.Clone(path[slices.Index(path, name):]), name)
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+
+		for _, included := range includes[name] {
+			if cycle := visit(included); cycle != nil {
+				return cycle
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[name] = visited
+
+		return nil
+	}
+
+	for _, conf := range confs {
+		if cycle := visit(conf.Name); cycle != nil {
+			return cycle
+		}
+	}
+
+	return nil
+}
+
+// ============================================================================
+//  Named List
+// ============================================================================
+
+// NamedList is an allowlist composed of a file, remote sources and other
+// lists, minus its exclusions.
+//
+// The served snapshot is the entries of the file, then of the sources, then
+// of the included lists, without duplicates and without the entries removed
+// by the exclusions. It is rebuilt only when any of the inputs changes, so its
+// ETag changes only if the served entries change.
+type NamedList struct {
+	name string
+	base bool
+	// file is the allowlist file of the list, nil for a list composed of
+	// other lists and sources only.
+	file    *ReloadingAllowlistProvider
+	sources []*RemoteAllowlistProvider
+	include []*NamedList
+	exclude []AllowlistEntry
+	// clients are the clients of Blocky the list is for.
+	clients []string
+
+	mu       sync.Mutex
+	resolved *ResolvedList
+}
+
+// ResolvedList is the flattened result of a list and where each of its
+// entries came from.
+type ResolvedList struct {
+	// Snapshot is the served allowlist.
+	Snapshot AllowlistSnapshot
+	// Entries are the served entries in the served order.
+	Entries []ResolvedEntry
+	// Excluded are the entries removed by the exclusions of the list or of
+	// the included lists, and not served by other inputs.
+	Excluded []ExcludedEntry
+	// key identifies the versions of all the inputs of the list.
+	key string
+}
+
+// ResolvedEntry is an entry of a list and where it came from.
+type ResolvedEntry struct {
+	AllowlistEntry
+
+	// Source is the file or URL the entry was read from, at its Line.
+	Source string
+	// Via are the lists the entry was included through, from the list
+	// itself to the list of the source.
+	Via []string
+}
+
+// ExcludedEntry is an entry removed by an exclusion.
+type ExcludedEntry struct {
+	ResolvedEntry
+
+	// By is the exclusion and List the list it belongs to.
+	By   string
+	List string
+}
+
+// listInput is a file or a remote source of a list.
+type listInput struct {
+	source string
+	loaded *loadedAllowlist
+}
+
+// Name returns the name of the list.
+func (l *NamedList) Name() string {
+	return l.name
+}
+
+// Base returns true if the list is meant to be included by other lists rather
+// than used on its own.
+func (l *NamedList) Base() bool {
+	return l.base
+}
+
+// Includes returns the names of the included lists.
+func (l *NamedList) Includes() []string {
+	names := make([]string, 0, len(l.include))
+	for _, list := range l.include {
+		names = append(names, list.name)
+	}
+
+	return names
+}
+
+// Clients returns the clients of Blocky the list is for.
+func (l *NamedList) Clients() []string {
+	return l.clients
+}
+
+// File returns the provider of the file of the list, or nil if it has none.
+func (l *NamedList) File() *ReloadingAllowlistProvider {
+	return l.file
+}
+
+// Sources returns the remote sources of the list.
+func (l *NamedList) Sources() []*RemoteAllowlistProvider {
+	return l.sources
+}
+
+// Excludes returns the exclusions of the list.
+func (l *NamedList) Excludes() []string {
+	raws := make([]string, 0, len(l.exclude))
+	for _, entry := range l.exclude {
+		raws = append(raws, entry.Domain)
+	}
+
+	return raws
+}
+
+// Snapshot returns the served entries of the list and its ETag.
+func (l *NamedList) Snapshot(ctx context.Context) (AllowlistSnapshot, error) {
+	resolved, err := l.Resolve(ctx)
+	if err != nil {
+		return AllowlistSnapshot{}, err
+	}
+
+	return resolved.Snapshot, nil
+}
+
+// Resolve returns the flattened result of the list. It fails if the file or a
+// source of the list or of any included list has never loaded, rather than
+// serving a part of the list.
+func (l *NamedList) Resolve(ctx context.Context) (*ResolvedList, error) {
+	inputs := make([]listInput, 0, 1+len(l.sources))
+	included := make([]*ResolvedList, 0, len(l.include))
+
+	var key strings.Builder
+
+	if l.file != nil {
+		loaded, err := l.file.served(ctx)
+		if err != nil {
+			return nil, wrapError(err, "list "+l.name)
+		}
+
+		inputs = append(inputs, listInput{source: l.file.Path(), loaded: loaded})
+
+		key.WriteString(loaded.snap.ETag + ",")
+	}
+
+	for _, source := range l.sources {
+		loaded, err := source.served(ctx)
+		if err != nil {
+			return nil, wrapError(err, "list "+l.name+": source "+source.URL())
+		}
+
+		inputs = append(inputs, listInput{source: source.URL(), loaded: loaded})
+
+		key.WriteString(loaded.snap.ETag + ",")
+	}
+
+	for _, list := range l.include {
+		resolved, err := list.Resolve(ctx)
+		if err != nil {
+			return nil, wrapError(err, "list "+l.name)
+		}
+
+		included = append(included, resolved)
+
+		key.WriteString("(" + resolved.key + "),")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.resolved != nil && l.resolved.key == key.String() {
+		return l.resolved, nil
+	}
+
+	l.resolved = l.flatten(key.String(), inputs, included)
+
+	slog.Debug("list rebuilt", "list", l.name, "etag", l.resolved.Snapshot.ETag,
+		"entries", len(l.resolved.Entries), "excluded", len(l.resolved.Excluded))
+
+	return l.resolved, nil
+}
+
+// flatten merges the entries of the inputs and of the included lists, and
+// removes the duplicates and the excluded entries.
+func (l *NamedList) flatten(key string, inputs []listInput, included []*ResolvedList) *ResolvedList {
+	candidates := make([]ResolvedEntry, 0)
+
+	for _, input := range inputs {
+		for _, entry := range input.loaded.parsed.Entries {
+			candidates = append(candidates, ResolvedEntry{AllowlistEntry: entry, Source: input.source, Via: []string{l.name}})
+		}
+	}
+
+	for _, list := range included {
+		for _, entry := range list.Entries {
+			entry.Via = append([]string{l.name}, entry.Via...)
+			candidates = append(candidates, entry)
+		}
+	}
+
+	var (
+		data     bytes.Buffer
+		seen     = make(map[string]bool, len(candidates))
+		resolved = &ResolvedList{Snapshot: AllowlistSnapshot{Data: nil, ETag: ""}, Entries: nil, Excluded: nil, key: key}
+	)
+
+	for _, entry := range candidates {
+		if seen[entry.Domain] {
+			continue
+		}
+
+		seen[entry.Domain] = true
+
+		if by, ok := l.excludedBy(entry.AllowlistEntry); ok {
+			resolved.Excluded = append(resolved.Excluded, ExcludedEntry{ResolvedEntry: entry, By: by, List: l.name})
+
+			continue
+		}
+
+		resolved.Entries = append(resolved.Entries, entry)
+
+		data.WriteString(entry.Domain)
+		data.WriteByte('\n')
+	}
+
+	// The exclusions of the included lists, unless the entry is served here
+	for _, list := range included {
+		for _, excluded := range list.Excluded {
+			if !seen[excluded.Domain] {
+				seen[excluded.Domain] = true
+
+				excluded.Via = append([]string{l.name}, excluded.Via...)
+				resolved.Excluded = append(resolved.Excluded, excluded)
+			}
+		}
+	}
+
+	resolved.Snapshot = AllowlistSnapshot{Data: data.Bytes(), ETag: fastHash(data.String())}
+
+	return resolved
+}
+
+// excludedBy returns the exclusion of the list removing the entry, if any.
+func (l *NamedList) excludedBy(entry AllowlistEntry) (string, bool) {
+	for _, exclusion := range l.exclude {
+		if excludes(exclusion, entry) {
+			return exclusion.Domain, true
+		}
+	}
+
+	return "", false
+}
+
+// excludes returns true if the exclusion removes the entry: the same entry,
+// or for a wildcard exclusion, the domains and wildcards under its base
+// domain. A regex entry is removed by the same regex only.
+func excludes(exclusion, entry AllowlistEntry) bool {
+	if exclusion.Domain == entry.Domain {
+		return true
+	}
+
+	if exclusion.Kind != EntryWildcard || entry.Kind == EntryRegex {
+		return false
+	}
+
+	base := strings.TrimPrefix(exclusion.Domain, wildcardPrefix)
+	domain := strings.TrimPrefix(entry.Domain, wildcardPrefix)
+
+	return domain == base || strings.HasSuffix(domain, "."+base)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Title of the lists pages.
+const titleLists = "Lists"
+
+// ============================================================================
+//  Lists Handlers
+// ============================================================================
+
+// listsPage is the data of the lists pages.
+type listsPage struct {
+	// Lists are the lists of the index page.
+	Lists []listView
+	// List is the list shown with its resolved entries, nil on the index page.
+	List *listView
+	// CanExport is true if the user may get the Blocky config.
+	CanExport bool
+}
+
+// listView is a named list and how it is composed.
+type listView struct {
+	Name       string
+	ServedPath string
+	Base       bool
+	// File is the path of the file of the list, empty if it has none.
+	File     string
+	Clients  []string
+	Includes []string
+	Sources  []listSource
+	Excludes []string
+	// Resolved is the flattened list, nil if it cannot be built for Error.
+	Resolved *ResolvedList
+	Error    string
+}
+
+// listSource is a remote source of a list and the result of its fetches.
+type listSource struct {
+	ReloadStatus
+
+	URL string
+}
+
+// listsHandlers serves the resolved view of the named lists, so that one can
+// tell which input of a composed list serves an entry.
+type listsHandlers struct {
+	lists        *ListSet
+	blockyConfig []byte
+	pages        *pageRenderer
+}
+
+// registerListsHandlers registers the lists pages to the mux. Anyone signed in
+// can view them, and only admins can get the Blocky config with the clients.
+func registerListsHandlers(mux *http.ServeMux, svc *adminServices, pages *pageRenderer) {
+	handlers := &listsHandlers{lists: svc.lists, blockyConfig: svc.blockyConfig, pages: pages}
+
+	mux.HandleFunc("GET /admin/lists", svc.requireSession(RoleViewer, handlers.getLists))
+	mux.HandleFunc("GET /admin/lists/{name}", svc.requireSession(RoleViewer, handlers.getList))
+	mux.HandleFunc("GET /admin/lists/blocky.yml", svc.requireSession(RoleAdmin, handlers.getBlockyConfig))
+}
+
+// getLists lists the named lists with their composition and entry count.
+func (h *listsHandlers) getLists(resWriter http.ResponseWriter, req *http.Request) {
+	sess, _ := sessionFromContext(req.Context())
+	data := &listsPage{Lists: nil, List: nil, CanExport: sess.Role.Allows(RoleAdmin)}
+
+	for _, list := range h.lists.Lists() {
+		data.Lists = append(data.Lists, h.view(req, list))
+	}
+
+	h.render(resWriter, req, http.StatusOK, "", data)
+}
+
+// getList shows the served entries of the list with where each came from,
+// and the entries removed by the exclusions.
+func (h *listsHandlers) getList(resWriter http.ResponseWriter, req *http.Request) {
+	list, ok := h.lists.Get(req.PathValue("name"))
+	if !ok {
+		h.render(resWriter, req, http.StatusNotFound,
+			fmt.Errorf("%w: %q", ErrListNotFound, req.PathValue("name")).Error(), nil)
+
+		return
+	}
+
+	view := h.view(req, list)
+
+	h.render(resWriter, req, http.StatusOK, "", &listsPage{Lists: nil, List: &view, CanExport: false})
+}
+
+// getBlockyConfig returns the blocking section of the config.yml of Blocky
+// serving the lists to their clients. See BlockyBlockingConfig.
+func (h *listsHandlers) getBlockyConfig(resWriter http.ResponseWriter, _ *http.Request) {
+	header := resWriter.Header()
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	header.Set("Cache-Control", "no-store")
+
+	_, _ = resWriter.Write(h.blockyConfig)
+}
+
+// view returns the list with its resolved entries.
+func (h *listsHandlers) view(req *http.Request, list *NamedList) listView {
+	view := listView{
+		Name: list.Name(), ServedPath: listsPathPrefix + list.Name() + listsPathSuffix, Base: list.Base(), File: "",
+		Clients: list.Clients(), Includes: list.Includes(), Sources: nil, Excludes: list.Excludes(), Resolved: nil, Error: "",
+	}
+
+	if file := list.File(); file != nil {
+		view.File = file.Path()
+	}
+
+	for _, source := range list.Sources() {
+		view.Sources = append(view.Sources, listSource{ReloadStatus: source.Status(), URL: source.URL()})
+	}
+
+	resolved, err := list.Resolve(req.Context())
+	if err != nil {
+		view.Error = err.Error()
+	} else {
+		view.Resolved = resolved
+	}
+
+	return view
+}
+
+func (h *listsHandlers) render(resWriter http.ResponseWriter, req *http.Request, status int, errMsg string,
+	data *listsPage,
+) {
+	sess, _ := sessionFromContext(req.Context())
+
+	h.pages.render(resWriter, status, "lists.html", page{ //nolint:exhaustruct // optional
+		Title: titleLists, Error: errMsg, Username: sess.Username, Role: sess.Role, CSRFToken: sess.CSRFToken,
+		Lists: data,
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for Lists Handlers
+// ============================================================================
+
+func TestListsHandlers(t *testing.T) {
+	t.Parallel()
+
+	basePath := writeTempFile(t, "github.com\nyoutube.com\n")
+
+	kids := testListConfig("kids", "", "base")
+	kids.Exclude = []string{"youtube.com"}
+	kids.Clients = []string{"tablet"}
+
+	conf := testAdminConfig("parent")
+	conf.Auth.Users = append(conf.Auth.Users, UserConfig{Username: "teen", Role: RoleViewer, RecoveryCodes: nil})
+	conf.Lists = []ListConfig{testListConfig("base", basePath), kids}
+
+	svc := testAdminServices(t, "", conf)
+	handler := newAdminHandler(new(StaticAllowlistProvider), svc)
+	teen := testSignIn(t, svc, "teen")
+
+	rec := serveAdmin(handler, http.MethodGet, "/admin/lists", nil, teen)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `<a href="/admin/lists/kids">kids</a>`)
+	assert.Contains(t, rec.Body.String(), "<td> +base -youtube.com</td>\n<td>tablet</td>")
+	assert.NotContains(t, rec.Body.String(), "/admin/lists/blocky.yml")
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/lists/kids", nil, teen)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Served at <code>/lists/kids.txt</code> with 1 entries")
+	assert.Contains(t, rec.Body.String(), "<li>List <a href=\"/admin/lists/base\">base</a></li>")
+	assert.Contains(t, rec.Body.String(), "<td><code>github.com</code></td>\n<td><code>"+basePath+"</code> line 1</td>\n"+
+		"<td>kids → base</td>")
+	assert.Contains(t, rec.Body.String(), "<td><code>youtube.com</code> of kids</td>")
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/lists/adults", nil, teen)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrListNotFound.Error())
+
+	// The Blocky config has the clients, so only admins can get it
+	rec = serveAdmin(handler, http.MethodGet, "/admin/lists/blocky.yml", nil, teen)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	parent := testSignIn(t, svc, "parent")
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/lists", nil, parent)
+	assert.Contains(t, rec.Body.String(), `<a href="/admin/lists/blocky.yml">`)
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/lists/blocky.yml", nil, parent)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "    \"tablet\":\n      - _catch-all\n      - kids\n")
+
+	// A list without a file has nothing to edit
+	rec = serveAdmin(handler, http.MethodGet, "/admin/allowlist?list=kids", nil, teen)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/allowlist?list=base", nil, teen)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), `href="/admin/allowlist?list=kids"`)
+}
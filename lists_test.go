@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for ListSet
+// ============================================================================
+
+func TestListSet(t *testing.T) {
+	t.Parallel()
+
+	basePath := writeTempFile(t, "# shared by all\ngithub.com\nexample.com\n")
+	kidsPath := writeTempFile(t, "example.com\nkhanacademy.org\n")
+	iotPath := writeTempFile(t, "time.example.net\n")
+
+	lists := NewListSet([]ListConfig{
+		testListConfig("base", basePath), testListConfig("kids", kidsPath, "base"), testListConfig("iot", iotPath),
+	}, DefaultConfig().Remote)
+	require.NoError(t, lists.Reload(context.Background()))
+
+	kids, ok := lists.Get("kids")
+	require.True(t, ok)
+	assert.Equal(t, []string{"base"}, kids.Includes())
+
+	// The entries of the list come first, and duplicates are served once
+	snap, err := kids.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "example.com\nkhanacademy.org\ngithub.com\n", string(snap.Data))
+
+	iot, _ := lists.Get("iot")
+	iotSnap, err := iot.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "time.example.net\n", string(iotSnap.Data))
+	assert.NotEqual(t, snap.ETag, iotSnap.ETag)
+
+	// A change of the base list changes the lists including it
+	require.NoError(t, os.WriteFile(basePath, []byte("github.com\nwikipedia.org\n"), 0o600))
+	require.NoError(t, lists.Reload(context.Background()))
+
+	changed, err := kids.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "example.com\nkhanacademy.org\ngithub.com\nwikipedia.org\n", string(changed.Data))
+	assert.NotEqual(t, snap.ETag, changed.ETag)
+
+	same, err := iot.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, iotSnap.ETag, same.ETag, "lists not including the base list must keep their ETag")
+}
+
+func TestListSet_ServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	lists := NewListSet([]ListConfig{testListConfig("kids", writeTempFile(t, "example.com\n"))}, DefaultConfig().Remote)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET "+listsPathPrefix+"{file}", lists)
+
+	for _, test := range []struct {
+		path   string
+		expect int
+	}{
+		{path: "/lists/kids.txt", expect: http.StatusOK},
+		{path: "/lists/kids", expect: http.StatusNotFound},
+		{path: "/lists/adults.txt", expect: http.StatusNotFound},
+		{path: "/lists/", expect: http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+		assert.Equal(t, test.expect, rec.Code, test.path)
+
+		if test.expect == http.StatusOK {
+			assert.Equal(t, "example.com\n", rec.Body.String())
+			assert.NotEmpty(t, rec.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestNamedList_Snapshot_broken_include(t *testing.T) {
+	t.Parallel()
+
+	lists := NewListSet([]ListConfig{
+		testListConfig("base", writeTempFile(t, "# no entries\n")),
+		testListConfig("kids", writeTempFile(t, "example.com\n"), "base"),
+	}, DefaultConfig().Remote)
+
+	kids, _ := lists.Get("kids")
+
+	// Serving a part of the list would block the entries of the base list
+	_, err := kids.Snapshot(context.Background())
+
+	require.ErrorIs(t, err, ErrNoValidAllowlist)
+	assert.Contains(t, err.Error(), "list base")
+}
+
+func TestNamedList_Resolve(t *testing.T) {
+	t.Parallel()
+
+	var shared atomic.Value
+
+	shared.Store("# shared by the school\nkhanacademy.org\nyoutube.com\nm.youtube.com\n")
+
+	remote := httptest.NewServer(http.HandlerFunc(func(resWriter http.ResponseWriter, _ *http.Request) {
+		_, _ = resWriter.Write([]byte(shared.Load().(string)))
+	}))
+	t.Cleanup(remote.Close)
+
+	basePath := writeTempFile(t, "github.com\nyoutube.com\n")
+
+	school := testListConfig("school", "")
+	school.Sources = []string{remote.URL + "/school.txt"}
+
+	// A list composed of other lists only, defined before them
+	kids := testListConfig("kids", "", "family", "school")
+	kids.Exclude = []string{"*.youtube.com"}
+
+	lists := NewListSet([]ListConfig{
+		kids, testListConfig("base", basePath), testListConfig("family", writeTempFile(t, "example.com\n"), "base"),
+		school,
+	}, DefaultConfig().Remote)
+	require.NoError(t, lists.Reload(context.Background()))
+
+	list, _ := lists.Get("kids")
+	resolved, err := list.Resolve(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, "example.com\ngithub.com\nkhanacademy.org\n", string(resolved.Snapshot.Data))
+	assert.Equal(t, []ResolvedEntry{
+		{AllowlistEntry: resolved.Entries[0].AllowlistEntry, Source: lists.byName["family"].File().Path(),
+			Via: []string{"kids", "family"}},
+		{AllowlistEntry: resolved.Entries[1].AllowlistEntry, Source: basePath, Via: []string{"kids", "family", "base"}},
+		{AllowlistEntry: resolved.Entries[2].AllowlistEntry, Source: remote.URL + "/school.txt",
+			Via: []string{"kids", "school"}},
+	}, resolved.Entries)
+	assert.Equal(t, 2, resolved.Entries[2].Line)
+
+	// The first input serving an entry is reported for the excluded ones too
+	require.Len(t, resolved.Excluded, 2)
+	assert.Equal(t, "youtube.com", resolved.Excluded[0].Domain)
+	assert.Equal(t, basePath, resolved.Excluded[0].Source)
+	assert.Equal(t, "m.youtube.com", resolved.Excluded[1].Domain)
+	assert.Equal(t, "*.youtube.com", resolved.Excluded[1].By)
+	assert.Equal(t, "kids", resolved.Excluded[1].List)
+
+	same, err := list.Resolve(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, resolved, same, "the list must be rebuilt only on change")
+
+	// A change of the remote source changes the lists including it
+	shared.Store("khanacademy.org\nwikipedia.org\n")
+	require.NoError(t, lists.Reload(context.Background()))
+
+	changed, err := list.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "example.com\ngithub.com\nkhanacademy.org\nwikipedia.org\n", string(changed.Data))
+	assert.NotEqual(t, resolved.Snapshot.ETag, changed.ETag)
+}
+
+func TestNamedList_Resolve_exclusion_of_included_list(t *testing.T) {
+	t.Parallel()
+
+	base := testListConfig("base", writeTempFile(t, "github.com\nyoutube.com\n"))
+	base.Exclude = []string{"youtube.com"}
+
+	lists := NewListSet([]ListConfig{
+		base,
+		testListConfig("teens", writeTempFile(t, "youtube.com\n"), "base"),
+		testListConfig("kids", writeTempFile(t, "example.com\n"), "base"),
+	}, DefaultConfig().Remote)
+
+	// An entry excluded by an included list is served if the list has it
+	teens, _ := lists.Get("teens")
+	resolved, err := teens.Resolve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "youtube.com\ngithub.com\n", string(resolved.Snapshot.Data))
+	assert.Empty(t, resolved.Excluded)
+
+	kids, _ := lists.Get("kids")
+	resolved, err = kids.Resolve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "example.com\ngithub.com\n", string(resolved.Snapshot.Data))
+	require.Len(t, resolved.Excluded, 1)
+	assert.Equal(t, []string{"kids", "base"}, resolved.Excluded[0].Via)
+	assert.Equal(t, "base", resolved.Excluded[0].List)
+}
+
+func TestNamedList_Resolve_source_never_fetched(t *testing.T) {
+	t.Parallel()
+
+	kids := testListConfig("kids", writeTempFile(t, "example.com\n"))
+	kids.Sources = []string{"http://127.0.0.1:1/school.txt"}
+
+	lists := NewListSet([]ListConfig{kids}, DefaultConfig().Remote)
+	list, _ := lists.Get("kids")
+
+	_, err := list.Snapshot(context.Background())
+
+	require.ErrorIs(t, err, ErrNoValidAllowlist)
+	assert.Contains(t, err.Error(), "source http://127.0.0.1:1/school.txt")
+}
+
+// ============================================================================
+//  Tests for findIncludeCycle
+// ============================================================================
+
+func TestFindIncludeCycle(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, findIncludeCycle([]ListConfig{
+		testListConfig("kids", "", "base", "school"), testListConfig("school", "", "base"), testListConfig("base", "a"),
+	}))
+	assert.Equal(t, []string{"kids", "kids"}, findIncludeCycle([]ListConfig{testListConfig("kids", "", "kids")}))
+	assert.Equal(t, []string{"b", "c", "b"}, findIncludeCycle([]ListConfig{
+		testListConfig("a", "", "b"), testListConfig("b", "", "c"), testListConfig("c", "", "b"),
+	}))
+}
+
+// ============================================================================
+//  Tests for excludes
+// ============================================================================
+
+func TestExcludes(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		exclusion string
+		entry     string
+		expect    bool
+	}{
+		{exclusion: "youtube.com", entry: "youtube.com", expect: true},
+		{exclusion: "youtube.com", entry: "m.youtube.com", expect: false},
+		{exclusion: "youtube.com", entry: "*.youtube.com", expect: false},
+		{exclusion: "*.youtube.com", entry: "youtube.com", expect: true},
+		{exclusion: "*.youtube.com", entry: "m.youtube.com", expect: true},
+		{exclusion: "*.youtube.com", entry: "*.m.youtube.com", expect: true},
+		{exclusion: "*.youtube.com", entry: "notyoutube.com", expect: false},
+		{exclusion: "*.youtube.com", entry: "/^youtube\\.com$/", expect: false},
+		{exclusion: "/^youtube\\.com$/", entry: "/^youtube\\.com$/", expect: true},
+	} {
+		exclusion, _, err := parseLine(test.exclusion)
+		require.NoError(t, err)
+
+		entry, _, err := parseLine(test.entry)
+		require.NoError(t, err)
+
+		assert.Equal(t, test.expect, excludes(exclusion, entry), test.exclusion+" "+test.entry)
+	}
+}
+
+// testListConfig returns the config of a list of the file including the
+// lists.
+func testListConfig(name, path string, include ...string) ListConfig {
+	return ListConfig{Name: name, Path: path, Base: false, Include: include, Sources: nil, Exclude: nil, Clients: nil}
+}
@@ -8,8 +8,10 @@ import (
 	"crypto/sha3"
 	"encoding/hex"
 	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -35,6 +37,16 @@ const (
 	hostDefault = "0.0.0.0" // allow external access
 )
 
+// Environment variable names. See settings in cli.go for the others.
+const (
+	// envAllowlistPath is the allowlist file path. If not set in any way, the
+	// sample allowlist is served.
+	envAllowlistPath = "ALOTAME_ALLOWLIST_PATH"
+	// envConfigPath is the JSON config file path. If not set in any way, the
+	// default configuration is used.
+	envConfigPath = "ALOTAME_CONFIG_PATH"
+)
+
 // Server timeout configuration.
 const (
 	readHeaderTimeout = 10 * time.Second
@@ -86,6 +98,21 @@ type ServerConfig struct {
 	WriteTimeout      time.Duration
 	IdleTimeout       time.Duration
 	ShutdownTimeout   time.Duration
+	// UnixSocket is the path of the Unix domain socket to listen on instead
+	// of TCP host:port. Empty to use TCP.
+	UnixSocket string
+	// UnixSocketMode is the file mode of the Unix domain socket.
+	UnixSocketMode os.FileMode
+	// UnixSocketOwner is the owner of the Unix domain socket in "user",
+	// "user:group" or ":group" form. Empty to keep the process owner.
+	UnixSocketOwner string
+	// TLSCert and TLSKey are the paths of the PEM encoded certificate and key
+	// to serve HTTPS. Empty to serve plain HTTP.
+	TLSCert string
+	TLSKey  string
+	// TLSSelfSigned generates a self-signed certificate at TLSCert and TLSKey
+	// if both files do not exist.
+	TLSSelfSigned bool
 }
 
 // DefaultServerConfig returns the default server configuration.
@@ -98,9 +125,20 @@ func DefaultServerConfig() ServerConfig {
 		WriteTimeout:      writeTimeout,
 		IdleTimeout:       idleTimeout,
 		ShutdownTimeout:   shutdownTimeout,
+		UnixSocket:        "",
+		UnixSocketMode:    unixSocketModeDefault,
+		UnixSocketOwner:   "",
+		TLSCert:           "",
+		TLSKey:            "",
+		TLSSelfSigned:     false,
 	}
 }
 
+// IsTLS returns true if the server is configured to serve HTTPS.
+func (c ServerConfig) IsTLS() bool {
+	return c.TLSCert != "" || c.TLSKey != ""
+}
+
 // Addr returns the server address in "host:port" format.
 func (c ServerConfig) Addr() string {
 	return c.Host + ":" + c.Port
@@ -111,34 +149,216 @@ func (c ServerConfig) Addr() string {
 // ============================================================================
 
 func main() {
-	prov := new(StaticAllowlistProvider)
-	conf := DefaultServerConfig()
+	if len(os.Args) > 1 && os.Args[1] == cmdResetTOTP {
+		err := runResetTOTP(os.Args[2:], os.Getenv, os.Stdout)
+		if !errors.Is(err, flag.ErrHelp) {
+			exitOnError(err)
+		}
+
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == cmdBlockyConfig {
+		err := runBlockyConfig(os.Args[2:], os.Getenv, os.Stdout)
+		if !errors.Is(err, flag.ErrHelp) {
+			exitOnError(err)
+		}
+
+		return
+	}
+
+	opts, err := parseCommandLine(os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	exitOnError(err)
+
+	if opts.PrintConfig {
+		exitOnError(printConfig(os.Stdout, opts.Config))
+
+		return
+	}
+
+	prov := newAllowlistProvider(opts.Config.AllowlistPath)
+	svc, err := newAdminServices(opts.ConfigPath, opts.Config)
+	exitOnError(err)
+
 	quit := setupSignalHandler()
 
-	exitOnError(run(prov, conf, quit))
+	exitOnError(run(prov, svc, opts.Config, quit))
 }
 
-// run starts the HTTP server and blocks until a quit signal is received or
-// the server fails to start.
-func run(prov AllowlistProvider, conf ServerConfig, quit <-chan os.Signal) error {
-	mux := http.NewServeMux()
-	mux.HandleFunc("GET /allowlist.txt", newAllowlistHandler(prov))
+// run starts the HTTP servers and blocks until a quit signal is received or
+// any of the servers fails.
+//
+// The public server serves only the allowlist endpoint, so it is safe to expose
+// to Blocky. The admin server serves the admin UI and APIs on another address.
+// Both share the provider, and failure of either shuts down both.
+func run(prov AllowlistProvider, svc *adminServices, conf Config, quit <-chan os.Signal) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	server := newHTTPServer(conf, mux)
-	serverErr := make(chan error, 1)
+	startWatcher(ctx, prov)
+	startLists(ctx, svc.lists)
+	startQueryLog(ctx, svc.queryLog, time.Duration(conf.QueryLog.PollInterval))
+	startBlocky(ctx, svc.blocky)
 
-	go startServer(server, conf.Addr(), serverErr)
+	publicMux := http.NewServeMux()
+	publicMux.HandleFunc("GET /allowlist.txt", newAllowlistHandler(prov))
+	publicMux.Handle("GET "+listsPathPrefix+"{file}", svc.lists)
 
-	dummyLen := 16
-	_ = secureHash("test", dummyLen) // dummy call to avoid unused function error. Will implement soon.
+	specs := []serverSpec{
+		{name: serverPublic, conf: conf.Server, handler: publicMux, path: "/allowlist.txt"},
+		{name: serverAdmin, conf: conf.Admin, handler: newAdminHandler(prov, svc), path: "/admin/login"},
+	}
+
+	activated, err := systemdListeners(os.Getpid(), os.Getenv)
+	if err != nil {
+		return err
+	}
+
+	serverErr := make(chan error, len(specs))
+	servers := make([]runningServer, 0, len(specs))
+
+	for _, spec := range specs {
+		running, err := serve(ctx, spec, activated[spec.name], serverErr)
+		if err != nil {
+			return errors.Join(err, shutdownServers(servers))
+		}
+
+		servers = append(servers, running)
+	}
 
 	select {
 	case <-quit:
-		slog.Info("shutting down server...")
+		slog.Info("shutting down servers...")
 
-		return shutdownServer(server, conf.ShutdownTimeout)
+		return shutdownServers(servers)
 	case err := <-serverErr:
-		return err
+		return errors.Join(err, shutdownServers(servers))
+	}
+}
+
+// serverSpec describes an HTTP server to start.
+type serverSpec struct {
+	name    string
+	conf    ServerConfig
+	handler http.Handler
+	// path is the main endpoint to log.
+	path string
+}
+
+// runningServer is a started HTTP server.
+type runningServer struct {
+	name   string
+	server *http.Server
+	conf   ServerConfig
+}
+
+// serve opens the listeners of the server and starts serving in background.
+// The activated listeners passed by systemd are used if any. Errors while
+// serving are sent to errCh.
+func serve(ctx context.Context, spec serverSpec, activated []net.Listener, errCh chan<- error) (runningServer, error) {
+	running := runningServer{name: spec.name, server: newHTTPServer(spec.conf, spec.handler), conf: spec.conf}
+
+	listeners, err := listen(ctx, spec.conf, activated)
+	if err != nil {
+		return running, wrapError(err, spec.name+" server")
+	}
+
+	if spec.conf.IsTLS() {
+		running.server.TLSConfig, err = newTLSConfig(spec.conf)
+		if err != nil {
+			closeListeners(listeners)
+
+			return running, wrapError(err, spec.name+" server")
+		}
+	}
+
+	for _, listener := range listeners {
+		go startServer(running.server, listener, spec.path, errCh)
+	}
+
+	return running, nil
+}
+
+// shutdownServers gracefully shuts down all the servers.
+func shutdownServers(servers []runningServer) error {
+	errs := make([]error, 0, len(servers))
+
+	for _, running := range servers {
+		err := shutdownServer(running.server, running.conf.ShutdownTimeout)
+		if err != nil {
+			errs = append(errs, wrapError(err, running.name+" server"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// newAllowlistProvider returns a file-backed provider for the given path that
+// reloads the file on change. If the path is empty, it returns the static
+// provider with the sample allowlist.
+func newAllowlistProvider(path string) AllowlistProvider { //nolint:ireturn // the implementation depends on the path
+	if path == "" {
+		return new(StaticAllowlistProvider)
+	}
+
+	slog.Info("using allowlist file", "path", path)
+
+	prov := NewReloadingAllowlistProvider(NewFileAllowlistProvider(path), nil)
+
+	// Errors are logged and the server keeps trying on the next change
+	_ = prov.Reload(context.Background())
+
+	return prov
+}
+
+// allowlistWatcher is implemented by providers that reload the allowlist in
+// the background.
+type allowlistWatcher interface {
+	Watch(ctx context.Context)
+}
+
+// startWatcher starts the background reload of the provider if supported.
+func startWatcher(ctx context.Context, prov AllowlistProvider) {
+	if watcher, ok := prov.(allowlistWatcher); ok {
+		go watcher.Watch(ctx)
+	}
+}
+
+// startLists loads the named allowlists and reloads them on change in
+// background, if any.
+func startLists(ctx context.Context, lists *ListSet) {
+	if len(lists.Lists()) == 0 {
+		return
+	}
+
+	slog.Info("serving named allowlists", "lists", len(lists.Lists()))
+
+	// Errors are logged and each list is read again on its next change
+	_ = lists.Reload(ctx)
+
+	go lists.Watch(ctx)
+}
+
+// startQueryLog starts reading the query log in background if enabled.
+func startQueryLog(ctx context.Context, reader QueryLogReader, interval time.Duration) {
+	if reader != nil {
+		slog.Info("reading query log", "interval", interval)
+
+		go watchQueryLog(ctx, reader, interval)
+	}
+}
+
+// startBlocky starts sending the list refresh requests to Blocky in background
+// if any instance is configured.
+func startBlocky(ctx context.Context, client *BlockyClient) {
+	if client.Enabled() {
+		slog.Info("refreshing Blocky on allowlist changes", "instances", len(client.Status()))
+
+		go client.Run(ctx)
 	}
 }
 
@@ -150,18 +370,31 @@ func setupSignalHandler() <-chan os.Signal {
 	return quit
 }
 
-// startServer runs the HTTP server and sends any error to the provided channel.
-func startServer(server *http.Server, addr string, errCh chan<- error) {
-	slog.Info("starting server", "addr", "http://"+addr+"/allowlist.txt")
+// startServer runs the HTTP server on the listener and sends any error to the
+// provided channel. The error is dropped if the channel is full, since the
+// first error already stops all the servers. It serves HTTPS if the server has
+// a TLS configuration. The path is the main endpoint of the server to log.
+func startServer(server *http.Server, listener net.Listener, path string, errCh chan<- error) {
+	isTLS := server.TLSConfig != nil
+
+	slog.Info("starting server", "addr", listenerURL(listener, isTLS, path))
+
+	var err error
+
+	if isTLS {
+		err = server.ServeTLS(listener, "", "") // certificates are given by TLSConfig
+	} else {
+		err = server.Serve(listener)
+	}
 
-	err := server.ListenAndServe()
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		slog.Error("server error:", "error", err)
 
-		errCh <- err
+		select {
+		case errCh <- err:
+		default: // another listener already failed
+		}
 	}
-
-	close(errCh)
 }
 
 // shutdownServer gracefully shuts down the server with a timeout.
@@ -210,6 +443,7 @@ func newAllowlistHandler(prov AllowlistProvider) http.HandlerFunc {
 		// Get snapshot atomically to ensure data and ETag consistency
 		snap, err := prov.Snapshot(req.Context())
 		if err != nil {
+			slog.Error("failed to load allowlist", "error", err)
 			http.Error(respW, "failed to load allowlist",
 				http.StatusInternalServerError)
 
@@ -264,6 +498,25 @@ func secureHash(data string, length int) string {
 	return hex.EncodeToString(hashed[:])
 }
 
+// backoff returns the wait before the next attempt after the failures. It is
+// the interval without failures, and doubles from the delay up to maxDelay
+// otherwise.
+func backoff(failures int, delay, maxDelay, interval time.Duration) time.Duration {
+	if failures == 0 {
+		return interval
+	}
+
+	for range failures - 1 {
+		if delay >= maxDelay {
+			break
+		}
+
+		delay *= 2
+	}
+
+	return min(delay, maxDelay)
+}
+
 func wrapError(err error, msg string) error {
 	if err == nil {
 		return nil
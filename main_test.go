@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -55,6 +56,9 @@ func TestDefaultServerConfig(t *testing.T) {
 	assert.Equal(t, writeTimeout, conf.WriteTimeout)
 	assert.Equal(t, idleTimeout, conf.IdleTimeout)
 	assert.Equal(t, shutdownTimeout, conf.ShutdownTimeout)
+	assert.Empty(t, conf.UnixSocket)
+	assert.Equal(t, unixSocketModeDefault, conf.UnixSocketMode)
+	assert.Empty(t, conf.UnixSocketOwner)
 }
 
 func TestServerConfig_Addr(t *testing.T) {
@@ -256,6 +260,32 @@ func TestNewAllowlistHandler_if_none_match(t *testing.T) {
 	}
 }
 
+// ============================================================================
+//  Tests for newAllowlistProvider
+// ============================================================================
+
+func TestNewAllowlistProvider(t *testing.T) {
+	t.Parallel()
+
+	assert.IsType(t, new(StaticAllowlistProvider), newAllowlistProvider(""))
+	assert.IsType(t, new(ReloadingAllowlistProvider),
+		newAllowlistProvider(writeTempFile(t, "example.com\n")))
+}
+
+// ============================================================================
+//  Tests for backoff
+// ============================================================================
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, time.Minute, backoff(0, time.Second, 5*time.Minute, time.Minute))
+	assert.Equal(t, time.Second, backoff(1, time.Second, 5*time.Minute, time.Minute))
+	assert.Equal(t, 8*time.Second, backoff(4, time.Second, 5*time.Minute, time.Minute))
+	assert.Equal(t, 5*time.Minute, backoff(20, time.Second, 5*time.Minute, time.Minute))
+	assert.Equal(t, 5*time.Minute, backoff(1000, time.Second, 5*time.Minute, time.Minute))
+}
+
 // ============================================================================
 //  Tests for wrapError
 // ============================================================================
@@ -307,6 +337,37 @@ func TestWrapError_DeadlineExceeded(t *testing.T) {
 	require.ErrorIs(t, err, context.DeadlineExceeded, "expected context.DeadlineExceeded")
 }
 
+// ============================================================================
+//  Tests for startServer
+// ============================================================================
+
+func TestStartServer_error_channel_full(t *testing.T) {
+	t.Parallel()
+
+	listener, err := new(net.ListenConfig).Listen(context.Background(), "tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, listener.Close())
+
+	// Another listener of the server already failed
+	errCh := make(chan error, 1)
+	errCh <- errOriginal
+
+	done := make(chan struct{})
+
+	go func() {
+		startServer(newHTTPServer(DefaultServerConfig(), http.NewServeMux()), listener, "/", errCh)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("startServer blocked on the full error channel")
+	}
+
+	require.ErrorIs(t, <-errCh, errOriginal)
+}
+
 // ============================================================================
 //  Tests for run
 // ============================================================================
@@ -320,16 +381,18 @@ func TestRun_graceful_shutdown(t *testing.T) {
 		getErr:  nil,
 		hashErr: nil,
 	}
-	conf := DefaultServerConfig()
-	conf.Port = "0" // Use random available port
-	conf.ShutdownTimeout = 1 * time.Second
+	conf := DefaultConfig()
+	conf.Server.Port = "0" // Use random available port
+	conf.Server.ShutdownTimeout = 1 * time.Second
+	conf.Admin.Port = "0"
+	conf.Admin.ShutdownTimeout = 1 * time.Second
 
 	quit := make(chan os.Signal, 1)
 
 	done := make(chan error, 1)
 
 	go func() {
-		done <- run(prov, conf, quit)
+		done <- run(prov, testAdminServices(t, "", conf), conf, quit)
 	}()
 
 	// Give server time to start
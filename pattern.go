@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Errors of wildcard and regex entries.
+var (
+	ErrWildcardInvalid   = errors.New("wildcard must be in \"*.example.com\" form")
+	ErrWildcardTooBroad  = errors.New("wildcard covers a whole public suffix")
+	ErrRegexUnterminated = errors.New("regex is not terminated with \"/\"")
+	ErrRegexEmpty        = errors.New("empty regex")
+	ErrRegexInvalid      = errors.New("invalid regex")
+	ErrRegexTooBroad     = errors.New("regex matches unrelated domains")
+)
+
+// EntryKind is the kind of an allowlist entry as understood by Blocky.
+type EntryKind string
+
+// Kinds of allowlist entries.
+const (
+	// EntryDomain is a plain domain name such as "example.com".
+	EntryDomain EntryKind = "domain"
+	// EntryWildcard is a domain and all its subdomains such as "*.example.com".
+	EntryWildcard EntryKind = "wildcard"
+	// EntryRegex is a regular expression enclosed in slashes such as
+	// "/^cdn[0-9]+\.example\.com$/".
+	EntryRegex EntryKind = "regex"
+)
+
+// wildcardPrefix is the prefix of wildcard entries.
+const wildcardPrefix = "*."
+
+// regexProbes are made-up domain names that no entry is meant to allow. A
+// regex that matches any of them matches whole TLDs or any domain, such as
+// "/.*/", "/\./", "/(com|net)$/" or "/^www\./". Some are letters only, so
+// that character classes such as "[a-z]+" do not slip through.
+var regexProbes = []string{
+	"qzxprobe.com",
+	"www.qzxprobe.net",
+	"qzxprobe.org",
+	"q7zx0-probe.com",
+	"xn--q7zx0-probe.jp",
+	"q7zx0.probe.net",
+	"very.long.sub.domain.q7zx0-probe.org",
+	"0-9.q7zx0-probe.io",
+	"a.b",
+}
+
+// ============================================================================
+//  Wildcard
+// ============================================================================
+
+// NormalizeWildcard validates the wildcard entry and returns its canonical
+// form. The base domain is normalized by NormalizeDomain.
+//
+// Wildcards covering a whole public suffix such as "*.com" or "*.co.uk" are
+// rejected with ErrWildcardTooBroad.
+func NormalizeWildcard(raw string) (string, error) {
+	base, ok := strings.CutPrefix(strings.TrimSpace(raw), wildcardPrefix)
+	if !ok || strings.Contains(base, "*") {
+		return "", ErrWildcardInvalid
+	}
+
+	domain, err := NormalizeDomain(base)
+	if err != nil {
+		return "", err
+	}
+
+	suffix, _ := publicsuffix.PublicSuffix(domain)
+	if suffix == domain {
+		return "", ErrWildcardTooBroad
+	}
+
+	return wildcardPrefix + domain, nil
+}
+
+// ============================================================================
+//  Regex
+// ============================================================================
+
+// cutRegex splits the line into the regex enclosed in slashes and the rest.
+// The closing slash is the first unescaped "/" after the opening one.
+func cutRegex(line string) (string, string, error) {
+	escaped := false
+
+	for idx := 1; idx < len(line); idx++ {
+		switch {
+		case escaped:
+			escaped = false
+		case line[idx] == '\\':
+			escaped = true
+		case line[idx] == '/':
+			return line[:idx+1], line[idx+1:], nil
+		}
+	}
+
+	return "", "", ErrRegexUnterminated
+}
+
+// ValidateRegex validates the regex entry enclosed in slashes. It must compile
+// with Go's regexp package as Blocky does, and must not match any of a set of
+// unrelated domains.
+func ValidateRegex(entry string) error {
+	pattern := strings.TrimSuffix(strings.TrimPrefix(entry, "/"), "/")
+	if pattern == "" {
+		return ErrRegexEmpty
+	}
+
+	expr, err := regexp.Compile(pattern)
+	if err != nil {
+		return errors.Join(ErrRegexInvalid, err)
+	}
+
+	for _, probe := range regexProbes {
+		if expr.MatchString(probe) {
+			return ErrRegexTooBroad
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for NormalizeWildcard
+// ============================================================================
+
+func TestNormalizeWildcard(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input   string
+		expect  string
+		wantErr error
+	}{
+		{input: "*.example.com", expect: "*.example.com", wantErr: nil},
+		{input: "*.CDN.Example.com.", expect: "*.cdn.example.com", wantErr: nil},
+		{input: "*.例え.jp", expect: "*.xn--r8jz45g.jp", wantErr: nil},
+		{input: "*", expect: "", wantErr: ErrWildcardInvalid},
+		{input: "*.", expect: "", wantErr: ErrDomainEmpty},
+		{input: "*example.com", expect: "", wantErr: ErrWildcardInvalid},
+		{input: "cdn.*.example.com", expect: "", wantErr: ErrWildcardInvalid},
+		{input: "*.*.example.com", expect: "", wantErr: ErrWildcardInvalid},
+		{input: "*.com", expect: "", wantErr: ErrWildcardTooBroad},
+		{input: "*.co.uk", expect: "", wantErr: ErrWildcardTooBroad},
+		{input: "*.exa$mple.com", expect: "", wantErr: ErrLabelInvalidChar},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := NormalizeWildcard(test.input)
+
+			if test.wantErr != nil {
+				require.ErrorIs(t, err, test.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, test.expect, actual)
+		})
+	}
+}
+
+// ============================================================================
+//  Tests for ValidateRegex
+// ============================================================================
+
+func TestValidateRegex(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input   string
+		wantErr error
+	}{
+		{input: `/^cdn[0-9]+\.example\.com$/`, wantErr: nil},
+		{input: `/example\.(com|net)$/`, wantErr: nil},
+		{input: `//`, wantErr: ErrRegexEmpty},
+		{input: `/(unclosed/`, wantErr: ErrRegexInvalid},
+		{input: `/(?<=a)b/`, wantErr: ErrRegexInvalid}, // look-behind is not supported by Go
+		{input: `/.*/`, wantErr: ErrRegexTooBroad},
+		{input: `/./`, wantErr: ErrRegexTooBroad},
+		{input: `/^/`, wantErr: ErrRegexTooBroad},
+		{input: `/com|/`, wantErr: ErrRegexTooBroad},
+		{input: `/[a-z]*/`, wantErr: ErrRegexTooBroad},
+		{input: `/\./`, wantErr: ErrRegexTooBroad},
+		{input: `/^.*\..*$/`, wantErr: ErrRegexTooBroad},
+		{input: `/(com|net|org|jp)$/`, wantErr: ErrRegexTooBroad},
+		{input: `/^[a-z0-9-]+\.jp$/`, wantErr: ErrRegexTooBroad},
+		{input: `/^[a-z]+\.com$/`, wantErr: ErrRegexTooBroad},
+		{input: `/^[a-z]+\.(com|net|org)$/`, wantErr: ErrRegexTooBroad},
+		{input: `/^www\./`, wantErr: ErrRegexTooBroad},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			t.Parallel()
+
+			err := ValidateRegex(test.input)
+			if test.wantErr == nil {
+				require.NoError(t, err)
+
+				return
+			}
+
+			require.ErrorIs(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestCutRegex(t *testing.T) {
+	t.Parallel()
+
+	regex, rest, err := cutRegex(`/a\/b#c/ # comment / with slash`)
+
+	require.NoError(t, err)
+	assert.Equal(t, `/a\/b#c/`, regex)
+	assert.Equal(t, ` # comment / with slash`, rest)
+
+	_, _, err = cutRegex(`/abc`)
+
+	require.ErrorIs(t, err, ErrRegexUnterminated)
+}
+
+// ============================================================================
+//  Tests for ParseAllowlist with wildcards and regexes
+// ============================================================================
+
+func TestParseAllowlist_patterns(t *testing.T) {
+	t.Parallel()
+
+	data := `# CDNs
+*.CDN.example.com  # images
+/^img[0-9]+\.example\.net$/ # numbered hosts
+*.例え.jp
+/.*/
+*.com
+/^(broken/
+/^a#b\.example\.com$/
+/example\.org$/ trailing
+*.cdn.example.com.
+`
+
+	parsed := ParseAllowlist([]byte(data))
+
+	require.Len(t, parsed.Entries, 4)
+
+	expect := []AllowlistEntry{
+		{Line: 2, Raw: "*.CDN.example.com", Kind: EntryWildcard, Domain: "*.cdn.example.com",
+			Unicode: "*.cdn.example.com", Comment: "images"},
+		{Line: 3, Raw: `/^img[0-9]+\.example\.net$/`, Kind: EntryRegex, Domain: `/^img[0-9]+\.example\.net$/`,
+			Unicode: `/^img[0-9]+\.example\.net$/`, Comment: "numbered hosts"},
+		{Line: 4, Raw: "*.例え.jp", Kind: EntryWildcard, Domain: "*.xn--r8jz45g.jp",
+			Unicode: "*.例え.jp", Comment: ""},
+		{Line: 8, Raw: `/^a#b\.example\.com$/`, Kind: EntryRegex, Domain: `/^a#b\.example\.com$/`,
+			Unicode: `/^a#b\.example\.com$/`, Comment: ""},
+	}
+
+	assert.Equal(t, expect, parsed.Entries)
+
+	expectErrs := []error{
+		ErrRegexTooBroad,         // line 5
+		ErrWildcardTooBroad,      // line 6
+		ErrRegexInvalid,          // line 7
+		ErrDomainTrailingGarbage, // line 9
+		ErrDomainDuplicate,       // line 10
+	}
+
+	require.Len(t, parsed.Diagnostics, len(expectErrs))
+
+	for idx, expectErr := range expectErrs {
+		require.ErrorIs(t, parsed.Diagnostics[idx].Err, expectErr, parsed.Diagnostics[idx].String())
+	}
+
+	assert.Equal(t,
+		"*.cdn.example.com\n/^img[0-9]+\\.example\\.net$/\n*.xn--r8jz45g.jp\n/^a#b\\.example\\.com$/\n",
+		string(parsed.Bytes()))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrAllowlistIsDir is returned when the allowlist path points to a directory.
+var ErrAllowlistIsDir = errors.New("allowlist path is a directory")
+
+// FileAllowlistProvider reads the allowlist from a file on disk.
+//
+// The file is re-read and re-hashed only when its modification time or size
+// changes. So the ETag stays stable between edits.
+type FileAllowlistProvider struct {
+	path string
+
+	mu     sync.Mutex
+	snap   AllowlistSnapshot
+	mtime  time.Time
+	size   int64
+	loaded bool
+}
+
+// NewFileAllowlistProvider returns a new FileAllowlistProvider that reads the
+// allowlist from the given path. The file is not read until the first call of
+// Snapshot.
+func NewFileAllowlistProvider(path string) *FileAllowlistProvider {
+	prov := new(FileAllowlistProvider)
+	prov.path = path
+
+	return prov
+}
+
+// Path returns the path of the allowlist file.
+func (prov *FileAllowlistProvider) Path() string {
+	return prov.path
+}
+
+// Snapshot returns the allowlist data and its ETag. It re-reads the file only
+// if the file has changed since the last call.
+func (prov *FileAllowlistProvider) Snapshot(ctx context.Context) (AllowlistSnapshot, error) {
+	if ctx.Err() != nil {
+		return AllowlistSnapshot{}, wrapError(ctx.Err(), "context retrieval failed")
+	}
+
+	info, err := os.Stat(prov.path)
+	if err != nil {
+		return AllowlistSnapshot{}, wrapError(err, "failed to stat allowlist file")
+	}
+
+	if info.IsDir() {
+		return AllowlistSnapshot{}, wrapError(ErrAllowlistIsDir, prov.path)
+	}
+
+	prov.mu.Lock()
+	defer prov.mu.Unlock()
+
+	if prov.loaded && info.ModTime().Equal(prov.mtime) && info.Size() == prov.size {
+		return prov.snap, nil
+	}
+
+	data, err := os.ReadFile(prov.path)
+	if err != nil {
+		return AllowlistSnapshot{}, wrapError(err, "failed to read allowlist file")
+	}
+
+	// Keep the stat info taken before reading. If the file changes while
+	// reading, the next call detects the change and reads it again.
+	prov.snap = AllowlistSnapshot{Data: data, ETag: fastHash(string(data))}
+	prov.mtime = info.ModTime()
+	prov.size = info.Size()
+	prov.loaded = true
+
+	return prov.snap, nil
+}
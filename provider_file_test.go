@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for FileAllowlistProvider
+// ============================================================================
+
+func TestFileAllowlistProvider_Snapshot(t *testing.T) {
+	t.Parallel()
+
+	data := "example.com\ngithub.com\n"
+	path := writeTempFile(t, data)
+
+	prov := NewFileAllowlistProvider(path)
+
+	snap, err := prov.Snapshot(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, []byte(data), snap.Data)
+	assert.Equal(t, fastHash(data), snap.ETag)
+	assert.Equal(t, path, prov.Path())
+}
+
+func TestFileAllowlistProvider_Snapshot_etag_stable(t *testing.T) {
+	t.Parallel()
+
+	path := writeTempFile(t, "example.com\n")
+	prov := NewFileAllowlistProvider(path)
+
+	snap1, err := prov.Snapshot(context.Background())
+	require.NoError(t, err)
+
+	snap2, err := prov.Snapshot(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, snap1.ETag, snap2.ETag)
+}
+
+func TestFileAllowlistProvider_Snapshot_detects_change(t *testing.T) {
+	t.Parallel()
+
+	path := writeTempFile(t, "example.com\n")
+	prov := NewFileAllowlistProvider(path)
+
+	snap1, err := prov.Snapshot(context.Background())
+	require.NoError(t, err)
+
+	newData := "example.com\ngithub.com\n"
+	require.NoError(t, os.WriteFile(path, []byte(newData), 0o600))
+
+	// Make sure the mtime differs even on file systems with coarse resolution
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, future, future))
+
+	snap2, err := prov.Snapshot(context.Background())
+	require.NoError(t, err)
+
+	assert.NotEqual(t, snap1.ETag, snap2.ETag)
+	assert.Equal(t, []byte(newData), snap2.Data)
+}
+
+func TestFileAllowlistProvider_Snapshot_missing_file(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "missing.txt")
+	prov := NewFileAllowlistProvider(path)
+
+	snap, err := prov.Snapshot(context.Background())
+
+	require.Error(t, err)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	assert.Contains(t, err.Error(), "failed to stat allowlist file")
+	assert.Contains(t, err.Error(), path)
+	assert.Empty(t, snap.Data)
+}
+
+func TestFileAllowlistProvider_Snapshot_directory(t *testing.T) {
+	t.Parallel()
+
+	prov := NewFileAllowlistProvider(t.TempDir())
+
+	_, err := prov.Snapshot(context.Background())
+
+	require.ErrorIs(t, err, ErrAllowlistIsDir)
+}
+
+func TestFileAllowlistProvider_Snapshot_canceled_context(t *testing.T) {
+	t.Parallel()
+
+	prov := NewFileAllowlistProvider(writeTempFile(t, "example.com\n"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := prov.Snapshot(ctx)
+
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Contains(t, err.Error(), "context retrieval failed")
+}
+
+func TestFileAllowlistProvider_with_handler_missing_file(t *testing.T) {
+	t.Parallel()
+
+	prov := NewFileAllowlistProvider(filepath.Join(t.TempDir(), "missing.txt"))
+	handler := newAllowlistHandler(prov)
+
+	req := httptest.NewRequest(http.MethodGet, "/allowlist.txt", nil)
+	rec := httptest.NewRecorder()
+
+	handler(rec, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), "failed to load allowlist")
+}
+
+// ============================================================================
+//  Test Helpers
+// ============================================================================
+
+// writeTempFile writes the data to a file in a temporary directory and returns
+// its path.
+func writeTempFile(t *testing.T, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "allowlist.txt")
+
+	err := os.WriteFile(path, []byte(data), 0o600)
+	require.NoError(t, err)
+
+	return path
+}
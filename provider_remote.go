@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of the remote allowlist sources.
+const (
+	remoteRefreshIntervalDefault = time.Hour
+	remoteTimeoutDefault         = 30 * time.Second
+	// remoteRetryDelay is the wait before the first retry after a failed
+	// fetch. It doubles on each failure up to remoteMaxBackoff.
+	remoteRetryDelay = 10 * time.Second
+	// remoteMaxBackoff is the longest wait between the retries. The refresh
+	// interval applies if it is shorter.
+	remoteMaxBackoff = 30 * time.Minute
+	// remoteMaxErrorBody is the size of the response body kept in the error.
+	remoteMaxErrorBody = 200
+	// remoteMaxBody is the maximum size of a remote allowlist.
+	remoteMaxBody = 8 << 20
+)
+
+// Errors of the remote allowlist sources.
+var (
+	ErrRemoteStatus   = errors.New("unexpected response from the remote allowlist")
+	ErrRemoteTooLarge = errors.New("remote allowlist is too large")
+)
+
+// RemoteAllowlistProvider serves the last known good version of an allowlist
+// fetched over HTTP, such as a list shared by another household.
+//
+// The allowlist is parsed like a file and its canonical form is served. It is
+// fetched again at the refresh interval with If-None-Match, and after a
+// failure with a backoff. A failed fetch keeps the previous version.
+type RemoteAllowlistProvider struct {
+	url      string
+	client   *http.Client
+	interval time.Duration
+	current  atomic.Pointer[loadedAllowlist]
+	// reloadMu serializes the fetches, so that a slow response of an older
+	// version does not replace the snapshot stored by a later one.
+	reloadMu sync.Mutex
+
+	mu     sync.Mutex
+	status ReloadStatus
+	// etag is the ETag header of the served version.
+	etag     string
+	failures int
+}
+
+// NewRemoteAllowlistProvider returns the provider of the URL. It does not
+// fetch the allowlist. Call Reload to fetch the initial version and Watch to
+// refresh it.
+func NewRemoteAllowlistProvider(url string, conf RemoteConfig) *RemoteAllowlistProvider {
+	prov := new(RemoteAllowlistProvider)
+	prov.url = url
+	prov.client = &http.Client{Timeout: time.Duration(conf.Timeout)} //nolint:exhaustruct // defaults
+	prov.interval = time.Duration(conf.RefreshInterval)
+
+	return prov
+}
+
+// URL returns the URL of the allowlist.
+func (prov *RemoteAllowlistProvider) URL() string {
+	return prov.url
+}
+
+// Snapshot returns the last known good snapshot.
+func (prov *RemoteAllowlistProvider) Snapshot(ctx context.Context) (AllowlistSnapshot, error) {
+	loaded, err := prov.served(ctx)
+	if err != nil {
+		return AllowlistSnapshot{}, err
+	}
+
+	return loaded.snap, nil
+}
+
+// served returns the last known good allowlist. Unlike files, it does not
+// fetch the allowlist never fetched yet, so that requests to Alotame do not
+// wait for the remote server.
+func (prov *RemoteAllowlistProvider) served(ctx context.Context) (*loadedAllowlist, error) {
+	if ctx.Err() != nil {
+		return nil, wrapError(ctx.Err(), "context retrieval failed")
+	}
+
+	if loaded := prov.current.Load(); loaded != nil {
+		return loaded, nil
+	}
+
+	prov.mu.Lock()
+	defer prov.mu.Unlock()
+
+	if prov.status.LastError != nil {
+		return nil, errors.Join(ErrNoValidAllowlist, prov.status.LastError)
+	}
+
+	return nil, ErrNoValidAllowlist
+}
+
+// Reload fetches the allowlist and swaps the served snapshot on success. On
+// failure, the previous snapshot is kept. Concurrent calls run one at a time.
+func (prov *RemoteAllowlistProvider) Reload(ctx context.Context) error {
+	prov.reloadMu.Lock()
+	defer prov.reloadMu.Unlock()
+
+	prov.mu.Lock()
+	etag := prov.etag
+	prov.mu.Unlock()
+
+	loaded, header, err := prov.fetch(ctx, etag)
+
+	prov.mu.Lock()
+	defer prov.mu.Unlock()
+
+	prov.status.LastAttempt = time.Now()
+	prov.status.LastError = err
+
+	if err != nil {
+		prov.failures++
+
+		slog.Error("failed to fetch remote allowlist, keeping the last good one",
+			"url", prov.url, "error", err, "etag", prov.status.ETag, "failures", prov.failures)
+
+		return wrapError(err, "failed to fetch remote allowlist")
+	}
+
+	prov.failures = 0
+	// The served version is current even if not modified
+	prov.status.LastSuccess = prov.status.LastAttempt
+
+	// Not modified since the served version
+	if loaded == nil {
+		return nil
+	}
+
+	prov.etag = header
+	prov.status.Diagnostics = loaded.parsed.Diagnostics
+
+	if old := prov.current.Load(); old != nil && old.snap.ETag == loaded.snap.ETag {
+		return nil
+	}
+
+	prov.current.Store(loaded)
+	prov.status.ETag = loaded.snap.ETag
+
+	slog.Info("remote allowlist loaded", "url", prov.url, "etag", loaded.snap.ETag,
+		"entries", len(loaded.parsed.Entries), "problems", len(loaded.parsed.Diagnostics))
+
+	return nil
+}
+
+// fetch requests the allowlist and returns it with the ETag header. It
+// returns nil if the allowlist of the ETag has not been modified.
+func (prov *RemoteAllowlistProvider) fetch(ctx context.Context, etag string) (*loadedAllowlist, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, prov.url, http.NoBody)
+	if err != nil {
+		return nil, "", wrapError(err, "failed to create request")
+	}
+
+	if etag != "" && prov.current.Load() != nil {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	res, err := prov.client.Do(req)
+	if err != nil {
+		return nil, "", wrapError(err, "failed to request")
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified && req.Header.Get("If-None-Match") != "" {
+		return nil, etag, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, remoteMaxErrorBody))
+
+		return nil, "", fmt.Errorf("%w: %s: %s", ErrRemoteStatus, res.Status, strings.TrimSpace(string(body)))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, remoteMaxBody+1))
+	if err != nil {
+		return nil, "", wrapError(err, "failed to read response")
+	}
+
+	if len(data) > remoteMaxBody {
+		return nil, "", ErrRemoteTooLarge
+	}
+
+	err = validateAllowlist(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	loaded := &loadedAllowlist{snap: AllowlistSnapshot{Data: nil, ETag: ""}, parsed: ParseAllowlist(data)}
+	if len(loaded.parsed.Entries) == 0 {
+		return nil, "", ErrAllowlistEmpty
+	}
+
+	canonical := loaded.parsed.Bytes()
+	loaded.snap = AllowlistSnapshot{Data: canonical, ETag: fastHash(string(canonical))}
+
+	return loaded, res.Header.Get("ETag"), nil
+}
+
+// Status returns the result of the latest fetches.
+func (prov *RemoteAllowlistProvider) Status() ReloadStatus {
+	prov.mu.Lock()
+	defer prov.mu.Unlock()
+
+	return prov.status
+}
+
+// Watch fetches the allowlist at the refresh interval, and sooner after a
+// failure. It blocks until the context is canceled.
+func (prov *RemoteAllowlistProvider) Watch(ctx context.Context) {
+	for {
+		prov.mu.Lock()
+		wait := backoff(prov.failures, remoteRetryDelay, min(remoteMaxBackoff, prov.interval), prov.interval)
+		prov.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return
+		case <-timer.C:
+			_ = prov.Reload(ctx) // failures are logged and kept in the status
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for RemoteAllowlistProvider
+// ============================================================================
+
+func TestRemoteAllowlistProvider(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu          sync.Mutex
+		body        = "Example.com # comment\ngithub.com\n"
+		status      = http.StatusOK
+		conditional int
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(resWriter http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if req.Header.Get("If-None-Match") == `"v1"` && body == "Example.com # comment\ngithub.com\n" {
+			conditional++
+
+			resWriter.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		resWriter.Header().Set("ETag", `"v1"`)
+		resWriter.WriteHeader(status)
+		_, _ = resWriter.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	prov := NewRemoteAllowlistProvider(server.URL+"/list.txt", DefaultConfig().Remote)
+	assert.Equal(t, server.URL+"/list.txt", prov.URL())
+
+	// Not fetched until Reload
+	_, err := prov.Snapshot(context.Background())
+	require.ErrorIs(t, err, ErrNoValidAllowlist)
+
+	require.NoError(t, prov.Reload(context.Background()))
+
+	snap, err := prov.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "example.com\ngithub.com\n", string(snap.Data))
+	assert.Equal(t, snap.ETag, prov.Status().ETag)
+
+	// Not modified, which is a success too
+	fetched := prov.Status().LastSuccess
+
+	time.Sleep(time.Millisecond)
+	require.NoError(t, prov.Reload(context.Background()))
+	assert.Equal(t, 1, conditional)
+	assert.True(t, prov.Status().LastSuccess.After(fetched), "last success should be the not modified fetch")
+	assert.Equal(t, prov.Status().LastAttempt, prov.Status().LastSuccess)
+
+	// A failure keeps the last good version
+	mu.Lock()
+	body, status = "gone", http.StatusInternalServerError
+	mu.Unlock()
+
+	err = prov.Reload(context.Background())
+	require.ErrorIs(t, err, ErrRemoteStatus)
+	assert.Contains(t, err.Error(), "gone")
+	require.ErrorIs(t, prov.Status().LastError, ErrRemoteStatus)
+
+	kept, err := prov.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, snap, kept)
+
+	// An allowlist without entries is not served
+	mu.Lock()
+	body, status = "# nothing\n", http.StatusOK
+	mu.Unlock()
+
+	require.ErrorIs(t, prov.Reload(context.Background()), ErrAllowlistEmpty)
+
+	mu.Lock()
+	body = "wikipedia.org\n"
+	mu.Unlock()
+
+	require.NoError(t, prov.Reload(context.Background()))
+
+	changed, err := prov.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "wikipedia.org\n", string(changed.Data))
+	assert.NoError(t, prov.Status().LastError)
+}
+
+func TestRemoteAllowlistProvider_too_large(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(resWriter http.ResponseWriter, _ *http.Request) {
+		_, _ = resWriter.Write([]byte(strings.Repeat("example.com\n", remoteMaxBody/12+1)))
+	}))
+	t.Cleanup(server.Close)
+
+	prov := NewRemoteAllowlistProvider(server.URL, DefaultConfig().Remote)
+
+	require.ErrorIs(t, prov.Reload(context.Background()), ErrRemoteTooLarge)
+
+	_, err := prov.Snapshot(context.Background())
+	require.ErrorIs(t, err, ErrNoValidAllowlist)
+	require.ErrorIs(t, err, ErrRemoteTooLarge)
+}
+
+func TestRemoteAllowlistProvider_Reload_concurrent(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu       sync.Mutex
+		version  int
+		inflight int
+		overlaps int
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(resWriter http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		version++
+		inflight++
+		overlaps += inflight - 1
+		body := fmt.Sprintf("v%d.example.com\n", version)
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+		_, _ = resWriter.Write([]byte(body))
+
+		mu.Lock()
+		inflight--
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	prov := NewRemoteAllowlistProvider(server.URL, DefaultConfig().Remote)
+
+	var wg sync.WaitGroup
+
+	for range 8 {
+		wg.Go(func() { assert.NoError(t, prov.Reload(context.Background())) })
+	}
+
+	wg.Wait()
+
+	// The fetches do not overlap, so the last one is served
+	snap, err := prov.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, overlaps)
+	assert.Equal(t, "v8.example.com\n", string(snap.Data))
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Query log defaults.
+const (
+	queryLogPollIntervalDefault = 10 * time.Second
+	queryLogRetentionDefault    = 7 * 24 * time.Hour
+)
+
+// Limits of the blocked domain index, so that a flood of random names cannot
+// exhaust the memory.
+const (
+	maxBlockedDomains = 10000
+	maxBlockedClients = 20
+)
+
+// Layout of the timestamps in Blocky's query log, in the local time of Blocky.
+const queryLogTimeLayout = "2006-01-02 15:04:05"
+
+// responseTypeBlocked is the response type of the queries blocked by Blocky.
+const responseTypeBlocked = "BLOCKED"
+
+// Columns of a row of Blocky's CSV query log. Newer versions of Blocky append
+// more columns, such as the hostname of the instance.
+const (
+	colTime = iota
+	colClientIP
+	colClientNames
+	colDuration
+	colReason
+	colQuestion
+	colAnswer
+	colResponseCode
+	colResponseType
+	queryLogMinColumns
+)
+
+// Errors of query log parsing.
+var (
+	ErrQueryLogColumns = errors.New("query log row has too few columns")
+	ErrQueryLogTime    = errors.New("invalid query log timestamp")
+)
+
+// ============================================================================
+//  Query Log Readers
+// ============================================================================
+
+// QueryLogStatus holds the result of the latest reads of the query log.
+type QueryLogStatus struct {
+	// LastPoll is the time of the latest read.
+	LastPoll time.Time
+	// LastError is the error of the latest read. Nil if succeeded.
+	LastError error
+	// Entries is the number of entries read since the start.
+	Entries int
+	// Invalid is the number of lines that failed to parse since the start.
+	Invalid int
+}
+
+// QueryLogReader reads the entries of Blocky's query log added since the
+// previous poll into the blocked domain index.
+type QueryLogReader interface {
+	// Poll reads the new entries. It is not called concurrently.
+	Poll(ctx context.Context) error
+	// Status returns the result of the latest polls.
+	Status() QueryLogStatus
+}
+
+// newQueryLogReader returns the reader of the configured query log, or nil if
+// reading the query log is disabled.
+func newQueryLogReader(conf QueryLogConfig, index *BlockedIndex) (QueryLogReader, error) { //nolint:ireturn // by type
+	switch conf.Type {
+	case "":
+		return nil, nil //nolint:nilnil // disabled
+	case queryLogCSV, queryLogCSVClient:
+		return NewFileQueryLogTailer(conf.Target, conf.Type, index)
+	case queryLogMySQL, queryLogPostgreSQL, queryLogTimescale:
+		db, err := openQueryLogDB(conf.Type, conf.Target)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewDBQueryLogReader(db, conf.Type, index)
+	default:
+		return nil, wrapError(ErrQueryLogType, conf.Type)
+	}
+}
+
+// watchQueryLog polls the reader at the interval until the context is
+// canceled. Errors are logged when they change and kept in the status.
+func watchQueryLog(ctx context.Context, reader QueryLogReader, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastErr string
+
+	for {
+		err := reader.Poll(ctx)
+
+		switch {
+		case err != nil && err.Error() != lastErr:
+			slog.Warn("failed to read query log", "error", err)
+
+			lastErr = err.Error()
+		case err == nil && lastErr != "":
+			slog.Info("query log is readable again")
+
+			lastErr = ""
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ============================================================================
+//  Query Log Entries
+// ============================================================================
+
+// QueryLogEntry is a DNS query logged by Blocky.
+type QueryLogEntry struct {
+	Time     time.Time
+	ClientIP string
+	// ClientNames are the names Blocky resolved for the client, if any.
+	ClientNames []string
+	// Domain is the queried name without the trailing dot.
+	Domain string
+	// Reason is the response reason such as "BLOCKED (ads)" or "CACHED".
+	Reason string
+	// ResponseType is "BLOCKED", "RESOLVED", "CACHED" and so on.
+	ResponseType string
+}
+
+// Blocked returns true if Blocky blocked the query.
+func (e QueryLogEntry) Blocked() bool {
+	return e.ResponseType == responseTypeBlocked
+}
+
+// Client returns the name of the client, or its IP address if unnamed.
+func (e QueryLogEntry) Client() string {
+	if len(e.ClientNames) > 0 {
+		return strings.Join(e.ClientNames, ", ")
+	}
+
+	return e.ClientIP
+}
+
+// parseQueryLogLine parses a tab-separated line of Blocky's "csv" and
+// "csv-client" query log. The timestamp is in the given location.
+func parseQueryLogLine(line string, loc *time.Location) (QueryLogEntry, error) {
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.Comma = '\t'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	record, err := reader.Read()
+	if err != nil {
+		return QueryLogEntry{}, wrapError(err, "failed to parse query log row")
+	}
+
+	if len(record) < queryLogMinColumns {
+		return QueryLogEntry{}, fmt.Errorf("%w: %d", ErrQueryLogColumns, len(record))
+	}
+
+	logged, err := time.ParseInLocation(queryLogTimeLayout, record[colTime], loc)
+	if err != nil {
+		return QueryLogEntry{}, fmt.Errorf("%w: %q", ErrQueryLogTime, record[colTime])
+	}
+
+	return QueryLogEntry{
+		Time:         logged,
+		ClientIP:     record[colClientIP],
+		ClientNames:  splitClientNames(record[colClientNames]),
+		Domain:       strings.TrimSuffix(record[colQuestion], "."),
+		Reason:       record[colReason],
+		ResponseType: record[colResponseType],
+	}, nil
+}
+
+// splitClientNames splits the client names joined with "; " by Blocky.
+func splitClientNames(names string) []string {
+	split := make([]string, 0, 1)
+
+	for name := range strings.SplitSeq(names, ";") {
+		if name = strings.TrimSpace(name); name != "" {
+			split = append(split, name)
+		}
+	}
+
+	return split
+}
+
+// ============================================================================
+//  Blocked Domain Index
+// ============================================================================
+
+// BlockedDomain is a domain name blocked by Blocky.
+type BlockedDomain struct {
+	// Domain is the normalized name in punycode form.
+	Domain string
+	// Unicode is the human-readable form of Domain.
+	Unicode   string
+	Hits      int
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// Clients are the clients that queried the name, up to maxBlockedClients.
+	Clients []string
+	// Reason is the response reason of the latest query.
+	Reason string
+}
+
+// BlockedIndex counts the domains recently blocked by Blocky. Domains not seen
+// within the retention are dropped. It is safe for concurrent use.
+type BlockedIndex struct {
+	retention time.Duration
+
+	mu      sync.Mutex
+	domains map[string]*BlockedDomain
+}
+
+// NewBlockedIndex returns an empty index keeping the domains for the
+// retention since they were last seen.
+func NewBlockedIndex(retention time.Duration) *BlockedIndex {
+	index := new(BlockedIndex)
+	index.retention = retention
+	index.domains = make(map[string]*BlockedDomain)
+
+	return index
+}
+
+// Add counts the entry if it is a blocked query. It returns false if the entry
+// is ignored, such as allowed queries and invalid names.
+func (idx *BlockedIndex) Add(entry QueryLogEntry) bool {
+	if !entry.Blocked() {
+		return false
+	}
+
+	domain, err := NormalizeDomain(entry.Domain)
+	if err != nil {
+		return false
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	blocked, ok := idx.domains[domain]
+	if !ok {
+		if len(idx.domains) >= maxBlockedDomains {
+			idx.evictOldestLocked()
+		}
+
+		blocked = &BlockedDomain{
+			Domain: domain, Unicode: idnToUnicode(domain), Hits: 0, FirstSeen: entry.Time, LastSeen: entry.Time,
+			Clients: nil, Reason: "",
+		}
+		idx.domains[domain] = blocked
+	}
+
+	blocked.Hits++
+
+	if entry.Time.Before(blocked.FirstSeen) {
+		blocked.FirstSeen = entry.Time
+	}
+
+	if !entry.Time.Before(blocked.LastSeen) {
+		blocked.LastSeen = entry.Time
+		blocked.Reason = entry.Reason
+	}
+
+	client := entry.Client()
+	if client != "" && len(blocked.Clients) < maxBlockedClients && !slices.Contains(blocked.Clients, client) {
+		blocked.Clients = append(blocked.Clients, client)
+	}
+
+	return true
+}
+
+// Domains returns the domains blocked within the retention before now, the
+// most recently seen first. Older domains are dropped from the index.
+func (idx *BlockedIndex) Domains(now time.Time) []BlockedDomain {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	cutoff := now.Add(-idx.retention)
+	domains := make([]BlockedDomain, 0, len(idx.domains))
+
+	for name, blocked := range idx.domains {
+		if blocked.LastSeen.Before(cutoff) {
+			delete(idx.domains, name)
+
+			continue
+		}
+
+		copied := *blocked
+		copied.Clients = slices.Clone(blocked.Clients)
+		domains = append(domains, copied)
+	}
+
+	slices.SortFunc(domains, func(a, b BlockedDomain) int {
+		if cmp := b.LastSeen.Compare(a.LastSeen); cmp != 0 {
+			return cmp
+		}
+
+		return strings.Compare(a.Domain, b.Domain)
+	})
+
+	return domains
+}
+
+// Len returns the number of domains in the index.
+func (idx *BlockedIndex) Len() int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	return len(idx.domains)
+}
+
+// evictOldestLocked drops the least recently seen domain. The caller must hold
+// mu.
+func (idx *BlockedIndex) evictOldestLocked() {
+	var oldest *BlockedDomain
+
+	for _, blocked := range idx.domains {
+		if oldest == nil || blocked.LastSeen.Before(oldest.LastSeen) {
+			oldest = blocked
+		}
+	}
+
+	if oldest != nil {
+		delete(idx.domains, oldest.Domain)
+	}
+}
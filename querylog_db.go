@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq" // registers the "postgres" driver
+)
+
+// Types of Blocky's queryLog written to a database.
+const (
+	queryLogMySQL      = "mysql"
+	queryLogPostgreSQL = "postgresql"
+	// queryLogTimescale is PostgreSQL with the TimescaleDB extension.
+	queryLogTimescale = "timescale"
+)
+
+// Settings of the database query log reader.
+const (
+	// queryLogOverlap is how far back each poll reads again. Blocky writes the
+	// entries in batches, so entries may be inserted after newer ones.
+	queryLogOverlap = 2 * time.Minute
+	// queryLogBatchSize is the maximum number of rows read by a query.
+	queryLogBatchSize = 5000
+	// queryLogMaxConns is the maximum number of connections to the database.
+	queryLogMaxConns = 2
+)
+
+// queryLogColumns are the columns of the log_entries table of Blocky read by
+// the database reader.
+const queryLogColumns = "request_ts, client_ip, client_name, reason, response_type, question_name"
+
+// ErrQueryLogStuck is returned if more rows than a batch have the same time.
+var ErrQueryLogStuck = errors.New("too many query log entries at the same time")
+
+// ============================================================================
+//  Database Query Log Reader
+// ============================================================================
+
+// DBQueryLogReader polls the log_entries table Blocky writes with the "mysql",
+// "postgresql" and "timescale" query log types, and adds the blocked queries
+// to the index.
+//
+// The table has no sequential ID, so the cursor is the time of the latest
+// entry read. Each poll reads the blocked entries from queryLogOverlap before
+// the cursor to catch the ones inserted late, and skips those already read.
+type DBQueryLogReader struct {
+	db    *sql.DB
+	query string
+	index *BlockedIndex
+	// cursor is the time of the latest entry read.
+	cursor time.Time
+	// seen are the entries read after cursor - queryLogOverlap.
+	seen map[queryLogKey]bool
+	// now returns the current time. Replaced in tests.
+	now func() time.Time
+
+	mu     sync.Mutex
+	status QueryLogStatus
+}
+
+// queryLogKey identifies an entry of the table.
+type queryLogKey struct {
+	time     time.Time
+	clientIP string
+	domain   string
+}
+
+// NewDBQueryLogReader returns a reader of the log_entries table of the
+// database. The placeholders of the query are in the style of the type.
+// It starts reading the entries within the retention of the index.
+func NewDBQueryLogReader(db *sql.DB, logType string, index *BlockedIndex) (*DBQueryLogReader, error) {
+	var from, limit string
+
+	switch logType {
+	case queryLogMySQL:
+		from, limit = "?", "?"
+	case queryLogPostgreSQL, queryLogTimescale:
+		from, limit = "$1", "$2"
+	default:
+		return nil, wrapError(ErrQueryLogType, logType)
+	}
+
+	reader := new(DBQueryLogReader)
+	reader.db = db
+	reader.query = "SELECT " + queryLogColumns + " FROM log_entries" +
+		" WHERE response_type = '" + responseTypeBlocked + "' AND request_ts >= " + from +
+		" ORDER BY request_ts LIMIT " + limit
+	reader.index = index
+	reader.seen = make(map[queryLogKey]bool)
+	reader.now = time.Now
+	reader.cursor = reader.now().Add(-index.retention)
+
+	return reader, nil
+}
+
+// openQueryLogDB opens the database of the query log type with the DSN in the
+// queryLog.target format of Blocky.
+func openQueryLogDB(logType, dsn string) (*sql.DB, error) {
+	driver := "postgres"
+
+	if logType == queryLogMySQL {
+		driver = "mysql"
+
+		// Scan DATETIME as time.Time
+		conf, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return nil, wrapError(err, "invalid MySQL DSN")
+		}
+
+		conf.ParseTime = true
+		dsn = conf.FormatDSN()
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, wrapError(err, "failed to open query log database")
+	}
+
+	db.SetMaxOpenConns(queryLogMaxConns)
+	db.SetMaxIdleConns(1)
+
+	return db, nil
+}
+
+// Status returns the result of the latest polls.
+func (r *DBQueryLogReader) Status() QueryLogStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.status
+}
+
+// Poll reads the blocked entries inserted since the previous poll.
+func (r *DBQueryLogReader) Poll(ctx context.Context) error {
+	entries, err := r.poll(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status.LastPoll = r.now()
+	r.status.LastError = err
+	r.status.Entries += entries
+
+	return err
+}
+
+func (r *DBQueryLogReader) poll(ctx context.Context) (int, error) {
+	from := r.cursor.Add(-queryLogOverlap)
+	entries := 0
+
+	for {
+		batch, last, err := r.readBatch(ctx, from)
+		entries += batch.added
+
+		if err != nil {
+			return entries, err
+		}
+
+		if batch.rows < queryLogBatchSize {
+			break
+		}
+
+		if !last.After(from) {
+			return entries, fmt.Errorf("%w: %s", ErrQueryLogStuck, from)
+		}
+
+		from = last
+	}
+
+	// Forget the entries that the next poll does not read again
+	for key := range r.seen {
+		if key.time.Before(r.cursor.Add(-queryLogOverlap)) {
+			delete(r.seen, key)
+		}
+	}
+
+	return entries, nil
+}
+
+// queryLogBatch is the result of a query of the rows.
+type queryLogBatch struct {
+	rows  int
+	added int
+}
+
+// readBatch reads a batch of the rows from the time, and returns the time of
+// the last row.
+func (r *DBQueryLogReader) readBatch(ctx context.Context, from time.Time) (queryLogBatch, time.Time, error) {
+	batch := queryLogBatch{rows: 0, added: 0}
+	last := from
+
+	rows, err := r.db.QueryContext(ctx, r.query, from, queryLogBatchSize)
+	if err != nil {
+		return batch, last, wrapError(err, "failed to query log_entries")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			entry      QueryLogEntry
+			clientName sql.NullString
+		)
+
+		err = rows.Scan(&entry.Time, &entry.ClientIP, &clientName, &entry.Reason, &entry.ResponseType,
+			&entry.Domain)
+		if err != nil {
+			return batch, last, wrapError(err, "failed to scan log_entries")
+		}
+
+		batch.rows++
+		last = entry.Time
+
+		entry.ClientNames = splitClientNames(clientName.String)
+		entry.Domain = strings.TrimSuffix(entry.Domain, ".")
+
+		key := queryLogKey{time: entry.Time, clientIP: entry.ClientIP, domain: entry.Domain}
+		if r.seen[key] {
+			continue
+		}
+
+		r.seen[key] = true
+		batch.added++
+
+		r.index.Add(entry)
+
+		if entry.Time.After(r.cursor) {
+			r.cursor = entry.Time
+		}
+	}
+
+	return batch, last, wrapError(rows.Err(), "failed to read log_entries")
+}
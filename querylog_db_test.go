@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for DBQueryLogReader
+// ============================================================================
+
+func TestDBQueryLogReader_Poll(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().Truncate(time.Second)
+	fake := new(fakeLogDB)
+	fake.insert(
+		fakeLogRow{time: now.Add(-2 * time.Hour), clientName: "laptop", domain: "old.example.", blocked: true},
+		fakeLogRow{time: now.Add(-time.Minute), clientName: "laptop; laptop.lan", domain: "ads.example.", blocked: true},
+		fakeLogRow{time: now.Add(-time.Minute), clientName: "", domain: "github.com.", blocked: false},
+		fakeLogRow{time: now, clientName: "tablet", domain: "ads.example.", blocked: true},
+	)
+
+	index := NewBlockedIndex(time.Hour)
+	reader, err := NewDBQueryLogReader(sql.OpenDB(fake), queryLogPostgreSQL, index)
+	require.NoError(t, err)
+
+	require.NoError(t, reader.Poll(context.Background()))
+
+	domains := index.Domains(now)
+	require.Len(t, domains, 1)
+	assert.Equal(t, "ads.example", domains[0].Domain)
+	assert.Equal(t, 2, domains[0].Hits)
+	assert.Equal(t, []string{"laptop, laptop.lan", "tablet"}, domains[0].Clients)
+	assert.Contains(t, fake.lastQuery(), "request_ts >= $1")
+
+	// Entries inserted late with an older time are read, and the others are
+	// not counted again
+	fake.insert(fakeLogRow{time: now.Add(-30 * time.Second), clientName: "tv", domain: "ads.example.", blocked: true})
+
+	require.NoError(t, reader.Poll(context.Background()))
+
+	domains = index.Domains(now)
+	require.Len(t, domains, 1)
+	assert.Equal(t, 3, domains[0].Hits)
+
+	status := reader.Status()
+	assert.Equal(t, 3, status.Entries)
+	require.NoError(t, status.LastError)
+	assert.False(t, status.LastPoll.IsZero())
+}
+
+func TestDBQueryLogReader_Poll_batches(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().Truncate(time.Second)
+	fake := new(fakeLogDB)
+
+	for i := range queryLogBatchSize + 10 {
+		fake.insert(fakeLogRow{
+			time: now.Add(-time.Duration(i) * time.Millisecond), clientName: "", domain: "ads.example.", blocked: true,
+		})
+	}
+
+	index := NewBlockedIndex(time.Hour)
+	reader, err := NewDBQueryLogReader(sql.OpenDB(fake), queryLogMySQL, index)
+	require.NoError(t, err)
+
+	require.NoError(t, reader.Poll(context.Background()))
+	assert.Equal(t, queryLogBatchSize+10, reader.Status().Entries)
+	assert.Contains(t, fake.lastQuery(), "request_ts >= ?")
+}
+
+func TestDBQueryLogReader_Poll_errors(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	fake := new(fakeLogDB)
+
+	for range queryLogBatchSize {
+		fake.insert(fakeLogRow{time: now, clientName: "", domain: "ads.example.", blocked: true})
+	}
+
+	reader, err := NewDBQueryLogReader(sql.OpenDB(fake), queryLogTimescale, NewBlockedIndex(time.Hour))
+	require.NoError(t, err)
+	require.ErrorIs(t, reader.Poll(context.Background()), ErrQueryLogStuck)
+
+	fake = new(fakeLogDB)
+	fake.err = errFakeLogDB
+
+	reader, err = NewDBQueryLogReader(sql.OpenDB(fake), queryLogPostgreSQL, NewBlockedIndex(time.Hour))
+	require.NoError(t, err)
+	require.ErrorIs(t, reader.Poll(context.Background()), errFakeLogDB)
+	require.ErrorIs(t, reader.Status().LastError, errFakeLogDB)
+
+	_, err = NewDBQueryLogReader(sql.OpenDB(fake), queryLogCSV, NewBlockedIndex(time.Hour))
+	require.ErrorIs(t, err, ErrQueryLogType)
+}
+
+func TestOpenQueryLogDB(t *testing.T) {
+	t.Parallel()
+
+	// Opening does not connect
+	for _, logType := range []string{queryLogMySQL, queryLogPostgreSQL, queryLogTimescale} {
+		reader, err := newQueryLogReader(QueryLogConfig{
+			Type: logType, Target: "blocky:secret@tcp(127.0.0.1:1)/blocky", PollInterval: 0, Retention: 0,
+		}, NewBlockedIndex(time.Hour))
+
+		require.NoError(t, err, logType)
+		assert.IsType(t, new(DBQueryLogReader), reader)
+	}
+
+	_, err := openQueryLogDB(queryLogMySQL, "not a DSN")
+	require.Error(t, err)
+}
+
+// ============================================================================
+//  Fake Database
+// ============================================================================
+
+var errFakeLogDB = errors.New("fake database error")
+
+// fakeLogRow is a row of the log_entries table.
+type fakeLogRow struct {
+	time       time.Time
+	clientName string
+	domain     string
+	blocked    bool
+}
+
+// fakeLogDB is a database/sql connector of a database with the log_entries
+// table of Blocky. It answers the query of DBQueryLogReader.
+type fakeLogDB struct {
+	mu      sync.Mutex
+	rows    []fakeLogRow
+	queries []string
+	err     error
+}
+
+func (db *fakeLogDB) insert(rows ...fakeLogRow) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.rows = append(db.rows, rows...)
+}
+
+func (db *fakeLogDB) lastQuery() string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.queries[len(db.queries)-1]
+}
+
+func (db *fakeLogDB) Connect(context.Context) (driver.Conn, error) {
+	return &fakeLogConn{db: db}, nil
+}
+
+func (db *fakeLogDB) Driver() driver.Driver {
+	return fakeLogDriver{}
+}
+
+type fakeLogDriver struct{}
+
+func (fakeLogDriver) Open(string) (driver.Conn, error) {
+	return nil, errFakeLogDB
+}
+
+type fakeLogConn struct {
+	db *fakeLogDB
+}
+
+func (c *fakeLogConn) Prepare(string) (driver.Stmt, error) { return nil, errFakeLogDB }
+func (c *fakeLogConn) Close() error                        { return nil }
+func (c *fakeLogConn) Begin() (driver.Tx, error)           { return nil, errFakeLogDB }
+
+// QueryContext returns the blocked rows from the time of the first argument,
+// up to the limit of the second one, ordered by time.
+func (c *fakeLogConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.db.queries = append(c.db.queries, query)
+
+	if c.db.err != nil {
+		return nil, c.db.err
+	}
+
+	from, _ := args[0].Value.(time.Time)
+	limit, _ := args[1].Value.(int64)
+
+	matched := make([]fakeLogRow, 0, len(c.db.rows))
+
+	for _, row := range c.db.rows {
+		if row.blocked && !row.time.Before(from) {
+			matched = append(matched, row)
+		}
+	}
+
+	slices.SortStableFunc(matched, func(a, b fakeLogRow) int { return a.time.Compare(b.time) })
+
+	return &fakeLogRows{rows: matched[:min(len(matched), int(limit))]}, nil
+}
+
+type fakeLogRows struct {
+	rows []fakeLogRow
+}
+
+func (r *fakeLogRows) Columns() []string {
+	return []string{"request_ts", "client_ip", "client_name", "reason", "response_type", "question_name"}
+}
+
+func (r *fakeLogRows) Close() error { return nil }
+
+func (r *fakeLogRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+
+	var clientName driver.Value
+	if row.clientName != "" {
+		clientName = row.clientName
+	}
+
+	dest[0], dest[1], dest[2], dest[3], dest[4], dest[5] = row.time, "192.168.1.2", clientName, "BLOCKED (ads)",
+		responseTypeBlocked, row.domain
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of Blocky's queryLog written to files.
+const (
+	// queryLogCSV is one file per day for all the clients.
+	queryLogCSV = "csv"
+	// queryLogCSVClient is one file per day and client.
+	queryLogCSVClient = "csv-client"
+)
+
+// queryLogAllClients is the client part of the file names of the "csv" type.
+const queryLogAllClients = "ALL"
+
+// queryLogFileName matches the file names of Blocky's query log, such as
+// "2026-01-11_ALL.log", capturing the date and the client.
+var queryLogFileName = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})_(.+)\.log$`)
+
+// ErrQueryLogType is returned for an unknown query log type.
+var ErrQueryLogType = errors.New("unknown query log type")
+
+// ============================================================================
+//  File Query Log Tailer
+// ============================================================================
+
+// FileQueryLogTailer follows the query log files Blocky writes with the "csv"
+// and "csv-client" types, and adds the blocked queries to the index.
+//
+// Each file is read from where the previous read stopped, and only complete
+// lines are read, so that a line being written is read on the next poll.
+// Files of the days older than the retention of the index are skipped.
+type FileQueryLogTailer struct {
+	dir       string
+	logType   string
+	index     *BlockedIndex
+	retention time.Duration
+	// loc is the time zone of the timestamps. Blocky writes the local time.
+	loc *time.Location
+	// now returns the current time. Replaced in tests.
+	now func() time.Time
+	// offsets are the sizes of the files already read by path.
+	offsets map[string]int64
+
+	mu     sync.Mutex
+	status QueryLogStatus
+}
+
+// NewFileQueryLogTailer returns a tailer of the query log files of the type in
+// the directory. It does not read the files until Poll or Watch is called.
+func NewFileQueryLogTailer(dir, logType string, index *BlockedIndex) (*FileQueryLogTailer, error) {
+	if logType != queryLogCSV && logType != queryLogCSVClient {
+		return nil, wrapError(ErrQueryLogType, logType)
+	}
+
+	tailer := new(FileQueryLogTailer)
+	tailer.dir = dir
+	tailer.logType = logType
+	tailer.index = index
+	tailer.retention = index.retention
+	tailer.loc = time.Local
+	tailer.now = time.Now
+	tailer.offsets = make(map[string]int64)
+
+	return tailer, nil
+}
+
+// Status returns the result of the latest reads.
+func (t *FileQueryLogTailer) Status() QueryLogStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.status
+}
+
+// Poll reads the lines added to the files since the previous poll. It must
+// not be called concurrently.
+func (t *FileQueryLogTailer) Poll(ctx context.Context) error {
+	entries, invalid, err := t.poll(ctx)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.LastPoll = t.now()
+	t.status.LastError = err
+	t.status.Entries += entries
+	t.status.Invalid += invalid
+
+	return err
+}
+
+func (t *FileQueryLogTailer) poll(ctx context.Context) (int, int, error) {
+	files, err := os.ReadDir(t.dir)
+	if err != nil {
+		return 0, 0, wrapError(err, "failed to list query log directory")
+	}
+
+	oldest := t.now().Add(-t.retention).In(t.loc).Format(time.DateOnly)
+	seen := make(map[string]bool, len(files))
+	entries, invalid := 0, 0
+
+	var errs []error
+
+	for _, file := range files {
+		match := queryLogFileName.FindStringSubmatch(file.Name())
+		if file.IsDir() || match == nil || match[1] < oldest ||
+			(match[2] == queryLogAllClients) != (t.logType == queryLogCSV) {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return entries, invalid, wrapError(ctx.Err(), "query log read canceled")
+		}
+
+		path := filepath.Join(t.dir, file.Name())
+		seen[path] = true
+
+		read, bad, err := t.readFile(path)
+		entries += read
+		invalid += bad
+
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Forget the files removed by Blocky's log retention
+	for path := range t.offsets {
+		if !seen[path] {
+			delete(t.offsets, path)
+		}
+	}
+
+	return entries, invalid, errors.Join(errs...)
+}
+
+// readFile reads the complete lines of the file after the offset, and returns
+// the numbers of the entries read and of the invalid lines.
+func (t *FileQueryLogTailer) readFile(path string) (int, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, wrapError(err, "failed to open query log")
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, wrapError(err, "failed to stat query log")
+	}
+
+	offset := t.offsets[path]
+	if info.Size() < offset {
+		// Truncated or replaced. Read again from the start.
+		offset = 0
+	}
+
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return 0, 0, wrapError(err, "failed to seek query log")
+	}
+
+	reader := bufio.NewReader(io.LimitReader(file, info.Size()-offset))
+	entries, invalid := 0, 0
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// EOF before a newline is a line being written
+			break
+		}
+
+		offset += int64(len(line))
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+
+		entry, err := parseQueryLogLine(line, t.loc)
+		if err != nil {
+			invalid++
+
+			continue
+		}
+
+		entries++
+
+		t.index.Add(entry)
+	}
+
+	t.offsets[path] = offset
+
+	return entries, invalid, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for FileQueryLogTailer
+// ============================================================================
+
+func TestFileQueryLogTailer_Poll(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	today := now.Format(time.DateOnly) + "_ALL.log"
+	dir := t.TempDir()
+
+	// Files of the other type, older than the retention and others are skipped
+	writeQueryLog(t, dir, now.Format(time.DateOnly)+"_laptop.log", queryLogLine(now, "client.example.", "BLOCKED"))
+	writeQueryLog(t, dir, now.AddDate(0, 0, -3).Format(time.DateOnly)+"_ALL.log",
+		queryLogLine(now, "old.example.", "BLOCKED"))
+	writeQueryLog(t, dir, "notes.txt", queryLogLine(now, "notes.example.", "BLOCKED"))
+
+	// The last line is being written
+	line := queryLogLine(now, "ads.example.com.", "BLOCKED")
+	writeQueryLog(t, dir, today, queryLogLine(now, "github.com.", "RESOLVED")+line+line[:10])
+
+	index := NewBlockedIndex(48 * time.Hour)
+	tailer, err := NewFileQueryLogTailer(dir, queryLogCSV, index)
+	require.NoError(t, err)
+
+	require.NoError(t, tailer.Poll(context.Background()))
+	assert.Equal(t, []string{"ads.example.com"}, blockedNames(index, now))
+	assert.Equal(t, 2, tailer.Status().Entries)
+
+	// The rest of the line and new lines are read on the next poll
+	appendQueryLog(t, dir, today, line[10:]+queryLogLine(now, "tracker.example.net.", "BLOCKED"))
+
+	require.NoError(t, tailer.Poll(context.Background()))
+	assert.ElementsMatch(t, []string{"ads.example.com", "tracker.example.net"}, blockedNames(index, now))
+
+	for _, blocked := range index.Domains(now) {
+		if blocked.Domain == "ads.example.com" {
+			assert.Equal(t, 2, blocked.Hits)
+		}
+	}
+
+	status := tailer.Status()
+	assert.Equal(t, 4, status.Entries)
+	assert.Zero(t, status.Invalid)
+	require.NoError(t, status.LastError)
+	assert.False(t, status.LastPoll.IsZero())
+
+	// Nothing new
+	require.NoError(t, tailer.Poll(context.Background()))
+	assert.Equal(t, 4, tailer.Status().Entries)
+}
+
+func TestFileQueryLogTailer_Poll_csv_client(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	dir := t.TempDir()
+
+	writeQueryLog(t, dir, now.Format(time.DateOnly)+"_ALL.log", queryLogLine(now, "all.example.", "BLOCKED"))
+	writeQueryLog(t, dir, now.Format(time.DateOnly)+"_laptop.log", queryLogLine(now, "laptop.example.", "BLOCKED"))
+	writeQueryLog(t, dir, now.Format(time.DateOnly)+"_tablet.log", queryLogLine(now, "tablet.example.", "BLOCKED"))
+
+	index := NewBlockedIndex(time.Hour)
+	tailer, err := NewFileQueryLogTailer(dir, queryLogCSVClient, index)
+	require.NoError(t, err)
+
+	require.NoError(t, tailer.Poll(context.Background()))
+	assert.ElementsMatch(t, []string{"laptop.example", "tablet.example"}, blockedNames(index, now))
+}
+
+func TestFileQueryLogTailer_Poll_truncated(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	name := now.Format(time.DateOnly) + "_ALL.log"
+	dir := t.TempDir()
+
+	writeQueryLog(t, dir, name, queryLogLine(now, "a.example.", "BLOCKED")+queryLogLine(now, "b.example.", "BLOCKED"))
+
+	index := NewBlockedIndex(time.Hour)
+	tailer, err := NewFileQueryLogTailer(dir, queryLogCSV, index)
+	require.NoError(t, err)
+	require.NoError(t, tailer.Poll(context.Background()))
+
+	writeQueryLog(t, dir, name, queryLogLine(now, "c.example.", "BLOCKED")+"garbage\n")
+
+	require.NoError(t, tailer.Poll(context.Background()))
+	assert.ElementsMatch(t, []string{"a.example", "b.example", "c.example"}, blockedNames(index, now))
+	assert.Equal(t, 1, tailer.Status().Invalid)
+}
+
+func TestFileQueryLogTailer_Poll_errors(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewFileQueryLogTailer(t.TempDir(), "console", NewBlockedIndex(time.Hour))
+	require.ErrorIs(t, err, ErrQueryLogType)
+
+	tailer, err := NewFileQueryLogTailer(filepath.Join(t.TempDir(), "missing"), queryLogCSV, NewBlockedIndex(time.Hour))
+	require.NoError(t, err)
+
+	err = tailer.Poll(context.Background())
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.ErrorIs(t, tailer.Status().LastError, os.ErrNotExist)
+}
+
+func TestWatchQueryLog(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	dir := t.TempDir()
+	index := NewBlockedIndex(time.Hour)
+
+	tailer, err := NewFileQueryLogTailer(dir, queryLogCSV, index)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go watchQueryLog(ctx, tailer, 10*time.Millisecond)
+
+	writeQueryLog(t, dir, now.Format(time.DateOnly)+"_ALL.log", queryLogLine(now, "ads.example.", "BLOCKED"))
+
+	assert.Eventually(t, func() bool { return index.Len() == 1 }, time.Second, 10*time.Millisecond)
+}
+
+// blockedNames returns the names in the index.
+func blockedNames(index *BlockedIndex, now time.Time) []string {
+	domains := index.Domains(now)
+	names := make([]string, 0, len(domains))
+
+	for _, blocked := range domains {
+		names = append(names, blocked.Domain)
+	}
+
+	return names
+}
+
+// writeQueryLog writes the query log file in the directory.
+func writeQueryLog(t *testing.T, dir, name, data string) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600))
+}
+
+// appendQueryLog appends the data to the query log file in the directory.
+func appendQueryLog(t *testing.T, dir, name, data string) {
+	t.Helper()
+
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+
+	_, err = file.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for parseQueryLogLine
+// ============================================================================
+
+func TestParseQueryLogLine(t *testing.T) {
+	t.Parallel()
+
+	line := "2026-01-11 08:30:15\t192.168.1.20\tlaptop; laptop.lan\t3\tBLOCKED (ads)\tads.example.com.\t" +
+		"0.0.0.0\tNOERROR\tBLOCKED\tA\tblocky-1"
+
+	entry, err := parseQueryLogLine(line, time.UTC)
+
+	require.NoError(t, err)
+	assert.Equal(t, QueryLogEntry{
+		Time:         time.Date(2026, 1, 11, 8, 30, 15, 0, time.UTC),
+		ClientIP:     "192.168.1.20",
+		ClientNames:  []string{"laptop", "laptop.lan"},
+		Domain:       "ads.example.com",
+		Reason:       "BLOCKED (ads)",
+		ResponseType: "BLOCKED",
+	}, entry)
+	assert.True(t, entry.Blocked())
+	assert.Equal(t, "laptop, laptop.lan", entry.Client())
+}
+
+func TestParseQueryLogLine_errors(t *testing.T) {
+	t.Parallel()
+
+	_, err := parseQueryLogLine("2026-01-11 08:30:15\t192.168.1.20\t\t3", time.UTC)
+	require.ErrorIs(t, err, ErrQueryLogColumns)
+
+	_, err = parseQueryLogLine(strings.Replace(queryLogLine(time.Now(), "a.com.", "BLOCKED"),
+		time.Now().Format(time.DateOnly), "yesterday", 1), time.UTC)
+	require.ErrorIs(t, err, ErrQueryLogTime)
+}
+
+// ============================================================================
+//  Tests for BlockedIndex
+// ============================================================================
+
+func TestBlockedIndex(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 11, 12, 0, 0, 0, time.UTC)
+	index := NewBlockedIndex(24 * time.Hour)
+
+	entries := []struct {
+		entry QueryLogEntry
+		added bool
+	}{
+		{entry: testBlockedEntry(now.Add(-time.Hour), "Ads.Example.com", "tablet"), added: true},
+		{entry: testBlockedEntry(now.Add(-2*time.Hour), "ads.example.com", "laptop"), added: true},
+		{entry: testBlockedEntry(now.Add(-time.Minute), "ads.example.com", "tablet"), added: true},
+		{entry: testBlockedEntry(now.Add(-30*time.Minute), "tracker.example.net", ""), added: true},
+		{entry: testBlockedEntry(now.Add(-48*time.Hour), "old.example.org", "laptop"), added: true},
+		{entry: testBlockedEntry(now, "bad..example", "laptop"), added: false},
+		{
+			entry: QueryLogEntry{
+				Time: now, ClientIP: "192.168.1.2", ClientNames: nil, Domain: "github.com", Reason: "CACHED",
+				ResponseType: "CACHED",
+			},
+			added: false,
+		},
+	}
+
+	for _, test := range entries {
+		assert.Equal(t, test.added, index.Add(test.entry), test.entry.Domain)
+	}
+
+	assert.Equal(t, 3, index.Len())
+
+	domains := index.Domains(now)
+
+	// The domain not seen within the retention is dropped
+	assert.Equal(t, 2, index.Len())
+	assert.Equal(t, []BlockedDomain{
+		{
+			Domain: "ads.example.com", Unicode: "ads.example.com", Hits: 3, FirstSeen: now.Add(-2 * time.Hour),
+			LastSeen: now.Add(-time.Minute), Clients: []string{"tablet", "laptop"}, Reason: "BLOCKED (ads)",
+		},
+		{
+			Domain: "tracker.example.net", Unicode: "tracker.example.net", Hits: 1,
+			FirstSeen: now.Add(-30 * time.Minute), LastSeen: now.Add(-30 * time.Minute), Clients: []string{"192.168.1.2"},
+			Reason: "BLOCKED (ads)",
+		},
+	}, domains)
+}
+
+func TestBlockedIndex_limit(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	index := NewBlockedIndex(time.Hour)
+
+	for i := range maxBlockedDomains + 1 {
+		index.Add(testBlockedEntry(now.Add(time.Duration(i)*time.Millisecond), fmt.Sprintf("d%d.example", i), ""))
+	}
+
+	domains := index.Domains(now)
+
+	require.Len(t, domains, maxBlockedDomains)
+	assert.Equal(t, fmt.Sprintf("d%d.example", maxBlockedDomains), domains[0].Domain)
+	assert.Equal(t, "d1.example", domains[len(domains)-1].Domain)
+}
+
+// ============================================================================
+//  Tests for newQueryLogReader
+// ============================================================================
+
+func TestNewQueryLogReader(t *testing.T) {
+	t.Parallel()
+
+	index := NewBlockedIndex(time.Hour)
+	conf := DefaultConfig().QueryLog
+
+	reader, err := newQueryLogReader(conf, index)
+	require.NoError(t, err)
+	assert.Nil(t, reader)
+
+	conf.Type, conf.Target = queryLogCSVClient, t.TempDir()
+
+	reader, err = newQueryLogReader(conf, index)
+	require.NoError(t, err)
+	assert.IsType(t, new(FileQueryLogTailer), reader)
+
+	conf.Type = "console"
+
+	_, err = newQueryLogReader(conf, index)
+	require.ErrorIs(t, err, ErrQueryLogType)
+}
+
+// testBlockedEntry returns a blocked query of the client from 192.168.1.2.
+func testBlockedEntry(logged time.Time, domain, client string) QueryLogEntry {
+	entry := QueryLogEntry{
+		Time: logged, ClientIP: "192.168.1.2", ClientNames: nil, Domain: domain, Reason: "BLOCKED (ads)",
+		ResponseType: responseTypeBlocked,
+	}
+
+	if client != "" {
+		entry.ClientNames = []string{client}
+	}
+
+	return entry
+}
+
+// queryLogLine returns a line of Blocky's CSV query log ending with a newline.
+func queryLogLine(logged time.Time, question, responseType string) string {
+	return strings.Join([]string{
+		logged.Format(queryLogTimeLayout), "192.168.1.2", "laptop", "1", responseType + " (ads)", question,
+		"0.0.0.0", "NOERROR", responseType, "A", "blocky",
+	}, "\t") + "\n"
+}
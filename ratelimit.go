@@ -0,0 +1,212 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Login rate limiting. Each username and each source IP has its own token
+// bucket, so neither guessing many codes of a user from many addresses nor
+// many users from one address gets far in the 10^6 code space.
+const (
+	// loginBurst is the number of attempts allowed at once.
+	loginBurst = 5
+	// loginRefill is the time to regain one attempt.
+	loginRefill = 20 * time.Second
+	// loginBackoffBase is the wait after the first failure. It doubles on
+	// each further failure up to loginBackoffMax.
+	loginBackoffBase = time.Second
+	loginBackoffMax  = 5 * time.Minute
+	// loginLockoutThreshold is the number of consecutive failures that locks
+	// the username or IP out for loginLockoutDuration.
+	loginLockoutThreshold = 10
+	loginLockoutDuration  = 15 * time.Minute
+)
+
+// Prefixes of the rate limit keys.
+const (
+	limitKeyUser = "user:"
+	limitKeyIP   = "ip:"
+)
+
+// ============================================================================
+//  Login Limiter
+// ============================================================================
+
+// Lockout is a username or source IP locked out after too many failures.
+type Lockout struct {
+	// Key is "user:<username>" or "ip:<address>".
+	Key      string
+	Failures int
+	Until    time.Time
+}
+
+// limitEntry is the state of a rate limit key.
+type limitEntry struct {
+	tokens   float64
+	updated  time.Time
+	failures int
+	// blockedUntil is the end of the backoff or lockout.
+	blockedUntil time.Time
+	locked       bool
+}
+
+// LoginLimiter limits login attempts per key with a token bucket, exponential
+// backoff after failures and a temporary lockout after too many failures.
+type LoginLimiter struct {
+	mu      sync.Mutex
+	entries map[string]*limitEntry
+}
+
+// NewLoginLimiter returns a new LoginLimiter.
+func NewLoginLimiter() *LoginLimiter {
+	limiter := new(LoginLimiter)
+	limiter.entries = make(map[string]*limitEntry)
+
+	return limiter
+}
+
+// Allow reports whether an attempt for all the keys is allowed now and uses
+// up a token of each key if so. Otherwise, it returns the time to wait.
+func (l *LoginLimiter) Allow(keys []string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pruneLocked(now)
+
+	var wait time.Duration
+
+	for _, key := range keys {
+		entry := l.entryLocked(key, now)
+
+		switch {
+		case now.Before(entry.blockedUntil):
+			wait = max(wait, entry.blockedUntil.Sub(now))
+		case entry.tokens < 1:
+			wait = max(wait, time.Duration((1-entry.tokens)*float64(loginRefill)))
+		}
+	}
+
+	if wait > 0 {
+		return wait, false
+	}
+
+	for _, key := range keys {
+		l.entries[key].tokens--
+	}
+
+	return 0, true
+}
+
+// Failure records a failed attempt for the keys and blocks them for an
+// exponentially growing time, or locks them out after too many failures.
+func (l *LoginLimiter) Failure(keys []string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		entry := l.entryLocked(key, now)
+		entry.failures++
+
+		if entry.failures >= loginLockoutThreshold {
+			wasLocked := entry.locked && now.Before(entry.blockedUntil)
+			entry.blockedUntil = now.Add(loginLockoutDuration)
+			entry.locked = true
+
+			if !wasLocked {
+				slog.Warn("login locked out after too many failures",
+					"key", key, "failures", entry.failures, "until", entry.blockedUntil)
+			}
+
+			continue
+		}
+
+		backoff := min(loginBackoffBase<<(entry.failures-1), loginBackoffMax)
+		entry.blockedUntil = now.Add(backoff)
+	}
+}
+
+// Success clears the failures of the keys.
+func (l *LoginLimiter) Success(keys []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		delete(l.entries, key)
+	}
+}
+
+// Lockouts returns the keys locked out now, sorted by key.
+func (l *LoginLimiter) Lockouts(now time.Time) []Lockout {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lockouts := make([]Lockout, 0)
+
+	for key, entry := range l.entries {
+		if entry.locked && now.Before(entry.blockedUntil) {
+			lockouts = append(lockouts, Lockout{Key: key, Failures: entry.failures, Until: entry.blockedUntil})
+		}
+	}
+
+	slices.SortFunc(lockouts, func(a, b Lockout) int { return strings.Compare(a.Key, b.Key) })
+
+	return lockouts
+}
+
+// entryLocked returns the entry of the key with its tokens refilled up to
+// now. The caller must hold mu.
+func (l *LoginLimiter) entryLocked(key string, now time.Time) *limitEntry {
+	entry, ok := l.entries[key]
+	if !ok {
+		entry = &limitEntry{tokens: loginBurst, updated: now, failures: 0, blockedUntil: time.Time{}, locked: false}
+		l.entries[key] = entry
+
+		return entry
+	}
+
+	if elapsed := now.Sub(entry.updated); elapsed > 0 {
+		entry.tokens = min(loginBurst, entry.tokens+float64(elapsed)/float64(loginRefill))
+		entry.updated = now
+	}
+
+	return entry
+}
+
+// pruneLocked drops the entries that have recovered fully, so that the map
+// does not grow with every address seen. The caller must hold mu.
+func (l *LoginLimiter) pruneLocked(now time.Time) {
+	for key, entry := range l.entries {
+		last := entry.updated
+		if entry.blockedUntil.After(last) {
+			last = entry.blockedUntil
+		}
+
+		if idle := now.Sub(last); idle > loginLockoutDuration && idle > loginRefill*loginBurst {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// loginLimitKeys returns the rate limit keys of the login attempt.
+//
+// Attempts over a unix socket or from a loopback address have no IP key. They
+// come from a reverse proxy on the same host, so the address is shared by all
+// the clients and failures of anyone would lock out all the admins. The
+// username is still limited.
+func loginLimitKeys(username, remoteAddr string) []string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return []string{limitKeyUser + username} // unix socket
+	}
+
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return []string{limitKeyUser + username}
+	}
+
+	return []string{limitKeyUser + username, limitKeyIP + host}
+}
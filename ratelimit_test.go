@@ -0,0 +1,166 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for LoginLimiter
+// ============================================================================
+
+func TestLoginLimiter_burst_and_refill(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewLoginLimiter()
+	keys := []string{"user:alice", "ip:192.0.2.1"}
+	now := time.Unix(1700000000, 0)
+
+	for range loginBurst {
+		_, ok := limiter.Allow(keys, now)
+		require.True(t, ok)
+	}
+
+	wait, ok := limiter.Allow(keys, now)
+	require.False(t, ok)
+	assert.Equal(t, loginRefill, wait)
+
+	_, ok = limiter.Allow(keys, now.Add(loginRefill))
+	require.True(t, ok, "one token is refilled")
+}
+
+func TestLoginLimiter_per_key(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewLoginLimiter()
+	now := time.Unix(1700000000, 0)
+
+	// Many usernames from one address
+	for idx := range loginBurst {
+		_, ok := limiter.Allow([]string{"user:" + string(rune('a'+idx)), "ip:192.0.2.1"}, now)
+		require.True(t, ok)
+	}
+
+	_, ok := limiter.Allow([]string{"user:z", "ip:192.0.2.1"}, now)
+	require.False(t, ok, "the address is limited")
+
+	_, ok = limiter.Allow([]string{"user:z", "ip:192.0.2.2"}, now)
+	require.True(t, ok, "other addresses are not limited")
+}
+
+func TestLoginLimiter_backoff(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewLoginLimiter()
+	keys := []string{"user:alice"}
+	now := time.Unix(1700000000, 0)
+
+	expect := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+
+	for _, backoff := range expect {
+		now = now.Add(loginRefill) // keep tokens available
+
+		_, ok := limiter.Allow(keys, now)
+		require.True(t, ok)
+
+		limiter.Failure(keys, now)
+
+		wait, ok := limiter.Allow(keys, now)
+		require.False(t, ok)
+		assert.Equal(t, backoff, wait)
+	}
+}
+
+func TestLoginLimiter_lockout(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewLoginLimiter()
+	keys := []string{"user:alice", "ip:192.0.2.1"}
+	now := time.Unix(1700000000, 0)
+
+	for range loginLockoutThreshold {
+		limiter.Failure(keys, now)
+	}
+
+	wait, ok := limiter.Allow(keys, now)
+	require.False(t, ok)
+	assert.Equal(t, loginLockoutDuration, wait)
+
+	lockouts := limiter.Lockouts(now)
+	require.Len(t, lockouts, 2)
+	assert.Equal(t, "ip:192.0.2.1", lockouts[0].Key)
+	assert.Equal(t, "user:alice", lockouts[1].Key)
+	assert.Equal(t, loginLockoutThreshold, lockouts[1].Failures)
+	assert.Equal(t, now.Add(loginLockoutDuration), lockouts[1].Until)
+
+	// Expires
+	later := now.Add(loginLockoutDuration + time.Second)
+
+	assert.Empty(t, limiter.Lockouts(later))
+
+	_, ok = limiter.Allow(keys, later)
+	require.True(t, ok)
+}
+
+func TestLoginLimiter_success_resets(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewLoginLimiter()
+	keys := []string{"user:alice"}
+	now := time.Unix(1700000000, 0)
+
+	limiter.Failure(keys, now)
+	limiter.Success(keys)
+
+	_, ok := limiter.Allow(keys, now)
+	require.True(t, ok)
+}
+
+func TestLoginLimiter_prune(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewLoginLimiter()
+	now := time.Unix(1700000000, 0)
+
+	limiter.Failure([]string{"ip:192.0.2.1"}, now)
+	limiter.Allow([]string{"ip:192.0.2.2"}, now.Add(loginLockoutDuration+loginBackoffBase+time.Second))
+
+	assert.NotContains(t, limiter.entries, "ip:192.0.2.1")
+	assert.Contains(t, limiter.entries, "ip:192.0.2.2")
+}
+
+func TestLoginLimitKeys(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"user:alice", "ip:192.0.2.1"}, loginLimitKeys("alice", "192.0.2.1:1234"))
+	assert.Equal(t, []string{"user:alice", "ip:2001:db8::1"}, loginLimitKeys("alice", "[2001:db8::1]:1234"))
+
+	// Behind a reverse proxy on the same host, the address is shared by all
+	// the clients
+	assert.Equal(t, []string{"user:alice"}, loginLimitKeys("alice", "@"))
+	assert.Equal(t, []string{"user:alice"}, loginLimitKeys("alice", ""))
+	assert.Equal(t, []string{"user:alice"}, loginLimitKeys("alice", "127.0.0.1:1234"))
+	assert.Equal(t, []string{"user:alice"}, loginLimitKeys("alice", "[::1]:1234"))
+}
+
+func TestLoginLimiter_reverse_proxy(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 11, 12, 0, 0, 0, time.UTC)
+	limiter := NewLoginLimiter()
+
+	// Someone fails to sign in as alice until locked out
+	for range loginLockoutThreshold {
+		limiter.Failure(loginLimitKeys("alice", "127.0.0.1:1234"), now)
+	}
+
+	_, ok := limiter.Allow(loginLimitKeys("alice", "127.0.0.1:1234"), now)
+	assert.False(t, ok)
+
+	// The other admins behind the same proxy can still sign in
+	_, ok = limiter.Allow(loginLimitKeys("bob", "127.0.0.1:1234"), now)
+	assert.True(t, ok)
+}
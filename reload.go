@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// Hot reload configuration.
+const (
+	// reloadPollInterval is the interval to check the allowlist file for
+	// changes when inotify is not available.
+	reloadPollInterval = 2 * time.Second
+	// reloadDebounce is the time to wait for further file events before
+	// reloading. Editors and tools often write a file in several steps.
+	reloadDebounce = 200 * time.Millisecond
+)
+
+// Errors returned by validateAllowlist.
+var (
+	ErrAllowlistEmpty       = errors.New("allowlist has no entries")
+	ErrAllowlistInvalidUTF8 = errors.New("allowlist is not valid UTF-8")
+	ErrAllowlistBinary      = errors.New("allowlist contains NUL bytes")
+	ErrNoValidAllowlist     = errors.New("no valid allowlist loaded yet")
+)
+
+// ============================================================================
+//  Types
+// ============================================================================
+
+// AllowlistValidator checks the raw allowlist data before it is served.
+type AllowlistValidator func(data []byte) error
+
+// ReloadStatus holds the result of the latest reload attempts.
+type ReloadStatus struct {
+	// LastAttempt is the time of the latest reload attempt.
+	LastAttempt time.Time
+	// LastSuccess is the time the currently served snapshot was loaded.
+	LastSuccess time.Time
+	// LastError is the error of the latest reload attempt. Nil if succeeded.
+	LastError error
+	// ETag is the ETag of the currently served snapshot.
+	ETag string
+	// Diagnostics of the latest reload attempt.
+	Diagnostics []Diagnostic
+}
+
+// loadedAllowlist is a parsed allowlist and the snapshot served from it.
+type loadedAllowlist struct {
+	snap   AllowlistSnapshot
+	parsed ParsedAllowlist
+}
+
+// ReloadingAllowlistProvider serves the last known good snapshot of a file
+// based allowlist and swaps it atomically when the file changes.
+//
+// The file is parsed by ParseAllowlist and the canonical form is served, so
+// the ETag changes only if the served entries change.
+//
+// If the new version of the file fails to load or validate, it keeps serving
+// the previous snapshot and reports the failure via Status and the log.
+type ReloadingAllowlistProvider struct {
+	source   *FileAllowlistProvider
+	validate AllowlistValidator
+	current  atomic.Pointer[loadedAllowlist]
+	// reloadMu serializes the reloads, so that a slow reload of an older
+	// version does not replace the snapshot stored by a later one.
+	reloadMu sync.Mutex
+
+	mu     sync.Mutex
+	status ReloadStatus
+}
+
+// NewReloadingAllowlistProvider returns a new ReloadingAllowlistProvider that
+// reads from the given source. If validate is nil, validateAllowlist is used.
+//
+// It does not load the file. Call Reload to load the initial snapshot and
+// Watch to reload on changes.
+func NewReloadingAllowlistProvider(
+	source *FileAllowlistProvider,
+	validate AllowlistValidator,
+) *ReloadingAllowlistProvider {
+	if validate == nil {
+		validate = validateAllowlist
+	}
+
+	prov := new(ReloadingAllowlistProvider)
+	prov.source = source
+	prov.validate = validate
+
+	return prov
+}
+
+// ============================================================================
+//  Methods
+// ============================================================================
+
+// Snapshot returns the last known good snapshot. If no snapshot has been
+// loaded yet, it tries to load one.
+func (prov *ReloadingAllowlistProvider) Snapshot(ctx context.Context) (AllowlistSnapshot, error) {
+	loaded, err := prov.served(ctx)
+	if err != nil {
+		return AllowlistSnapshot{}, err
+	}
+
+	return loaded.snap, nil
+}
+
+// served returns the last known good allowlist, so that its snapshot and its
+// entries are of the same version. If none has been loaded yet, it tries to
+// load one.
+func (prov *ReloadingAllowlistProvider) served(ctx context.Context) (*loadedAllowlist, error) {
+	if ctx.Err() != nil {
+		return nil, wrapError(ctx.Err(), "context retrieval failed")
+	}
+
+	if loaded := prov.current.Load(); loaded != nil {
+		return loaded, nil
+	}
+
+	err := prov.Reload(ctx)
+	if err != nil {
+		return nil, errors.Join(ErrNoValidAllowlist, err)
+	}
+
+	return prov.current.Load(), nil
+}
+
+// Path returns the path of the allowlist source file.
+func (prov *ReloadingAllowlistProvider) Path() string {
+	return prov.source.Path()
+}
+
+// Entries returns the parsed entries of the currently served snapshot. It
+// returns nil if no snapshot has been loaded yet.
+func (prov *ReloadingAllowlistProvider) Entries() []AllowlistEntry {
+	if loaded := prov.current.Load(); loaded != nil {
+		return loaded.parsed.Entries
+	}
+
+	return nil
+}
+
+// Reload reads and validates the source file and swaps the served snapshot
+// on success. On failure, the previous snapshot is kept. Concurrent calls
+// run one at a time.
+func (prov *ReloadingAllowlistProvider) Reload(ctx context.Context) error {
+	prov.reloadMu.Lock()
+	defer prov.reloadMu.Unlock()
+
+	loaded, err := prov.load(ctx)
+
+	prov.mu.Lock()
+	defer prov.mu.Unlock()
+
+	prov.status.LastAttempt = time.Now()
+	prov.status.LastError = err
+	prov.status.Diagnostics = loaded.parsed.Diagnostics
+
+	if err != nil {
+		slog.Error("failed to reload allowlist, keeping the last good one",
+			"path", prov.source.Path(), "error", err, "etag", prov.status.ETag)
+
+		return wrapError(err, "failed to reload allowlist")
+	}
+
+	if old := prov.current.Load(); old != nil && old.snap.ETag == loaded.snap.ETag {
+		return nil
+	}
+
+	prov.current.Store(&loaded)
+	prov.status.LastSuccess = prov.status.LastAttempt
+	prov.status.ETag = loaded.snap.ETag
+
+	for _, diag := range loaded.parsed.Diagnostics {
+		slog.Warn("allowlist entry problem", "path", prov.source.Path(), "diagnostic", diag.String())
+	}
+
+	slog.Info("allowlist loaded", "path", prov.source.Path(), "etag", loaded.snap.ETag,
+		"entries", len(loaded.parsed.Entries), "problems", len(loaded.parsed.Diagnostics))
+
+	return nil
+}
+
+// load reads, validates and parses the source file.
+func (prov *ReloadingAllowlistProvider) load(ctx context.Context) (loadedAllowlist, error) {
+	var loaded loadedAllowlist
+
+	raw, err := prov.source.Snapshot(ctx)
+	if err != nil {
+		return loaded, err
+	}
+
+	err = prov.validate(raw.Data)
+	if err != nil {
+		return loaded, err
+	}
+
+	loaded.parsed = ParseAllowlist(raw.Data)
+	if len(loaded.parsed.Entries) == 0 {
+		return loaded, ErrAllowlistEmpty
+	}
+
+	data := loaded.parsed.Bytes()
+	loaded.snap = AllowlistSnapshot{Data: data, ETag: fastHash(string(data))}
+
+	return loaded, nil
+}
+
+// Status returns the result of the latest reload attempts.
+func (prov *ReloadingAllowlistProvider) Status() ReloadStatus {
+	prov.mu.Lock()
+	defer prov.mu.Unlock()
+
+	return prov.status
+}
+
+// Watch reloads the allowlist whenever the source file changes. It blocks
+// until the context is canceled.
+//
+// It uses inotify where available and falls back to polling.
+func (prov *ReloadingAllowlistProvider) Watch(ctx context.Context) {
+	events := watchFile(ctx, prov.source.Path(), reloadPollInterval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-events:
+			if !ok {
+				if ctx.Err() == nil {
+					slog.Warn("file watcher stopped, falling back to polling", "path", prov.source.Path())
+
+					events = pollFile(ctx, prov.source.Path(), reloadPollInterval)
+
+					continue
+				}
+
+				return
+			}
+
+			debounce(ctx, events, reloadDebounce)
+
+			_ = prov.Reload(ctx) // failures are logged and kept in the status
+		}
+	}
+}
+
+// ============================================================================
+//  File Watching (common)
+// ============================================================================
+
+// fileStamp identifies a version of a file by its modification time and size.
+type fileStamp struct {
+	mtime  int64
+	size   int64
+	exists bool
+}
+
+func statFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{mtime: 0, size: 0, exists: false}
+	}
+
+	return fileStamp{mtime: info.ModTime().UnixNano(), size: info.Size(), exists: true}
+}
+
+// pollFile checks the file for changes at the given interval and sends an
+// event to the returned channel on change. The channel is closed when the
+// context is canceled.
+func pollFile(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	events := make(chan struct{}, 1)
+	last := statFile(path)
+
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if cur := statFile(path); cur != last {
+					last = cur
+
+					notify(events)
+				}
+			}
+		}
+	}()
+
+	return events
+}
+
+// notify sends an event without blocking. Pending events are coalesced.
+func notify(events chan<- struct{}) {
+	select {
+	case events <- struct{}{}:
+	default:
+	}
+}
+
+// debounce waits until no event is received for the given duration.
+func debounce(ctx context.Context, events <-chan struct{}, wait time.Duration) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+
+			timer.Reset(wait)
+		}
+	}
+}
+
+// ============================================================================
+//  Validation
+// ============================================================================
+
+// validateAllowlist is the default AllowlistValidator. It rejects data that is
+// likely a broken or half-written file.
+func validateAllowlist(data []byte) error {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return ErrAllowlistBinary
+	}
+
+	if !utf8.Valid(data) {
+		return ErrAllowlistInvalidUTF8
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return nil
+		}
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return wrapError(err, "failed to scan allowlist")
+	}
+
+	return ErrAllowlistEmpty
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for ReloadingAllowlistProvider
+// ============================================================================
+
+func TestReloadingAllowlistProvider_Snapshot(t *testing.T) {
+	t.Parallel()
+
+	data := "example.com\n"
+	prov := NewReloadingAllowlistProvider(NewFileAllowlistProvider(writeTempFile(t, data)), nil)
+
+	// Loads on first access
+	snap, err := prov.Snapshot(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, []byte(data), snap.Data)
+	assert.Equal(t, fastHash(data), snap.ETag)
+
+	status := prov.Status()
+
+	require.NoError(t, status.LastError)
+	assert.Equal(t, snap.ETag, status.ETag)
+	assert.False(t, status.LastSuccess.IsZero())
+}
+
+func TestReloadingAllowlistProvider_Snapshot_canonical_form(t *testing.T) {
+	t.Parallel()
+
+	data := "# comment\nGitHub.com\ngithub.com.\nbad..domain\nExample.com # note\n"
+	prov := NewReloadingAllowlistProvider(NewFileAllowlistProvider(writeTempFile(t, data)), nil)
+
+	snap, err := prov.Snapshot(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, "github.com\nexample.com\n", string(snap.Data))
+	assert.Equal(t, fastHash(string(snap.Data)), snap.ETag)
+
+	entries := prov.Entries()
+
+	require.Len(t, entries, 2)
+	assert.Equal(t, "note", entries[1].Comment)
+
+	diags := prov.Status().Diagnostics
+
+	require.Len(t, diags, 2)
+	require.ErrorIs(t, diags[0].Err, ErrDomainDuplicate)
+	require.ErrorIs(t, diags[1].Err, ErrLabelEmpty)
+}
+
+func TestReloadingAllowlistProvider_Entries_not_loaded(t *testing.T) {
+	t.Parallel()
+
+	prov := NewReloadingAllowlistProvider(NewFileAllowlistProvider(writeTempFile(t, "example.com\n")), nil)
+
+	assert.Nil(t, prov.Entries())
+}
+
+func TestReloadingAllowlistProvider_Snapshot_no_valid_allowlist(t *testing.T) {
+	t.Parallel()
+
+	prov := NewReloadingAllowlistProvider(NewFileAllowlistProvider(writeTempFile(t, "# empty\n")), nil)
+
+	_, err := prov.Snapshot(context.Background())
+
+	require.ErrorIs(t, err, ErrNoValidAllowlist)
+	require.ErrorIs(t, err, ErrAllowlistEmpty)
+}
+
+func TestReloadingAllowlistProvider_Snapshot_canceled_context(t *testing.T) {
+	t.Parallel()
+
+	prov := NewReloadingAllowlistProvider(NewFileAllowlistProvider(writeTempFile(t, "example.com\n")), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := prov.Snapshot(ctx)
+
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestReloadingAllowlistProvider_Reload_keeps_last_good(t *testing.T) {
+	t.Parallel()
+
+	good := "example.com\n"
+	path := writeTempFile(t, good)
+	prov := NewReloadingAllowlistProvider(NewFileAllowlistProvider(path), nil)
+
+	require.NoError(t, prov.Reload(context.Background()))
+
+	// Half-written (empty) file
+	updateFile(t, path, "")
+
+	err := prov.Reload(context.Background())
+
+	require.ErrorIs(t, err, ErrAllowlistEmpty)
+
+	snap, err := prov.Snapshot(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, []byte(good), snap.Data, "last good snapshot should be served")
+	require.ErrorIs(t, prov.Status().LastError, ErrAllowlistEmpty)
+
+	// Deleted file
+	require.NoError(t, os.Remove(path))
+	require.ErrorIs(t, prov.Reload(context.Background()), os.ErrNotExist)
+
+	snap, err = prov.Snapshot(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, []byte(good), snap.Data, "last good snapshot should be served")
+}
+
+func TestReloadingAllowlistProvider_Reload_custom_validator(t *testing.T) {
+	t.Parallel()
+
+	prov := NewReloadingAllowlistProvider(
+		NewFileAllowlistProvider(writeTempFile(t, "example.com\n")),
+		func([]byte) error { return errOriginal },
+	)
+
+	require.ErrorIs(t, prov.Reload(context.Background()), errOriginal)
+}
+
+func TestReloadingAllowlistProvider_Reload_concurrent(t *testing.T) {
+	t.Parallel()
+
+	path := writeTempFile(t, "old.example.com\n")
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	var once sync.Once
+
+	// The first reload reads the old version and stalls before storing it
+	prov := NewReloadingAllowlistProvider(NewFileAllowlistProvider(path), func(data []byte) error {
+		if string(data) == "old.example.com\n" {
+			once.Do(func() {
+				close(entered)
+				<-release
+			})
+		}
+
+		return validateAllowlist(data)
+	})
+
+	var wg sync.WaitGroup
+
+	wg.Go(func() { assert.NoError(t, prov.Reload(context.Background())) })
+
+	<-entered
+	updateFile(t, path, "new.example.com\n")
+
+	for range 4 {
+		wg.Go(func() { assert.NoError(t, prov.Reload(context.Background())) })
+	}
+
+	// Let the later reloads run before the stalled one stores its version
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	snap, err := prov.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "new.example.com\n", string(snap.Data), "a stale version must not be served")
+	assert.Equal(t, snap.ETag, prov.Status().ETag)
+}
+
+func TestReloadingAllowlistProvider_Watch(t *testing.T) {
+	t.Parallel()
+
+	path := writeTempFile(t, "example.com\n")
+	prov := NewReloadingAllowlistProvider(NewFileAllowlistProvider(path), nil)
+
+	require.NoError(t, prov.Reload(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+
+	go func() {
+		prov.Watch(ctx)
+		close(done)
+	}()
+
+	// Give the watcher time to start
+	time.Sleep(100 * time.Millisecond)
+
+	newData := "example.com\ngithub.com\n"
+	updateFile(t, path, newData)
+
+	require.Eventually(t, func() bool {
+		snap, err := prov.Snapshot(context.Background())
+
+		return err == nil && string(snap.Data) == newData
+	}, 5*time.Second, 50*time.Millisecond)
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not return after cancel")
+	}
+}
+
+// ============================================================================
+//  Tests for File Watching
+// ============================================================================
+
+func TestPollFile(t *testing.T) {
+	t.Parallel()
+
+	path := writeTempFile(t, "example.com\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := pollFile(ctx, path, 10*time.Millisecond)
+
+	updateFile(t, path, "example.com\ngithub.com\n")
+
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+
+	cancel()
+
+	require.Eventually(t, func() bool {
+		_, ok := <-events
+
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond, "channel should be closed on cancel")
+}
+
+func TestWatchFile_atomic_rename(t *testing.T) {
+	t.Parallel()
+
+	path := writeTempFile(t, "example.com\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := watchFile(ctx, path, 10*time.Millisecond)
+
+	// Give the watcher time to start
+	time.Sleep(50 * time.Millisecond)
+
+	tmpPath := filepath.Join(filepath.Dir(path), ".allowlist.txt.tmp")
+
+	require.NoError(t, os.WriteFile(tmpPath, []byte("github.com\n"), 0o600))
+	require.NoError(t, os.Rename(tmpPath, path))
+
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	t.Parallel()
+
+	events := make(chan struct{}, 1)
+	start := time.Now()
+
+	go func() {
+		for range 3 {
+			notify(events)
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+
+	debounce(context.Background(), events, 50*time.Millisecond)
+
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+// ============================================================================
+//  Tests for validateAllowlist
+// ============================================================================
+
+func TestValidateAllowlist(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr error
+	}{
+		{name: "valid", data: "# comment\nexample.com\n", wantErr: nil},
+		{name: "empty", data: "", wantErr: ErrAllowlistEmpty},
+		{name: "comments only", data: "# comment\n\n  \n", wantErr: ErrAllowlistEmpty},
+		{name: "NUL bytes", data: "example.com\n\x00\x00", wantErr: ErrAllowlistBinary},
+		{name: "invalid UTF-8", data: "example.com\n\xff\xfe", wantErr: ErrAllowlistInvalidUTF8},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validateAllowlist([]byte(test.data))
+			if test.wantErr == nil {
+				require.NoError(t, err)
+
+				return
+			}
+
+			require.ErrorIs(t, err, test.wantErr)
+		})
+	}
+}
+
+// ============================================================================
+//  Test Helpers
+// ============================================================================
+
+// updateFile overwrites the file and moves its mtime forward so that the change
+// is detected even on file systems with coarse time resolution.
+func updateFile(t *testing.T, path, data string) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, future, future))
+}
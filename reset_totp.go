@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// cmdResetTOTP is the name of the subcommand to rotate the TOTP seed.
+const cmdResetTOTP = "reset-totp"
+
+// Flags of the reset-totp subcommand.
+const (
+	flagUsername = "username"
+	flagClear    = "clear"
+)
+
+// ErrNoUsername is returned when reset-totp has no user to rotate the secret of.
+var ErrNoUsername = errors.New("no users enrolled. Set --" + flagUsername + " or use --" + flagClear)
+
+// ============================================================================
+//  Reset TOTP
+// ============================================================================
+
+// runResetTOTP runs "alotame reset-totp". It rotates the seed and the recovery
+// codes in the auth object of the config file, keeping the other settings, and
+// prints the new secret of each user to register in the authenticator app.
+// Since the secrets of all the users are derived from the seed, all of them
+// need to register again.
+//
+// With --username, the user is added as an admin if missing, to regain access
+// when no admin is left. With --clear, the seed and the users are removed
+// instead, so that the enrollment page is open again on the next start.
+// Either way, the saved sessions are removed.
+func runResetTOTP(args []string, getenv func(string) string, output io.Writer) error {
+	flagSet := flag.NewFlagSet("alotame "+cmdResetTOTP, flag.ContinueOnError)
+	flagSet.SetOutput(output)
+	flagSet.Usage = func() {
+		_, _ = fmt.Fprint(output, `Usage: alotame reset-totp [options]
+
+Rotates the TOTP seed and the recovery codes of all the users in the config file.
+Restart alotame to apply.
+
+Options:
+`)
+
+		flagSet.PrintDefaults()
+	}
+
+	configPath := flagSet.String(flagConfig, "", "path of the JSON config file (env: "+envConfigPath+")")
+	username := flagSet.String(flagUsername, "", "username to add as an admin if missing")
+	clearSeed := flagSet.Bool(flagClear, false, "remove the seed and the users to enroll again on the web page")
+
+	err := flagSet.Parse(args)
+	if err != nil {
+		return wrapError(err, "failed to parse flags")
+	}
+
+	if *configPath == "" {
+		*configPath = getenv(envConfigPath)
+	}
+
+	if *configPath == "" {
+		return ErrNoConfigPath
+	}
+
+	conf, err := LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	auth := conf.Auth
+
+	if *clearSeed {
+		auth.Seed = ""
+		auth.Users = nil
+
+		err = resetAuth(*configPath, auth)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(output, "The TOTP seed and the users are removed from %s.\n"+
+			"Restart alotame and enroll again at /admin/enroll.\n", *configPath)
+
+		return wrapError(err, "failed to print result")
+	}
+
+	if *username != "" {
+		auth, err = addAdminIfMissing(auth, *username)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(auth.Users) == 0 {
+		return ErrNoUsername
+	}
+
+	codes, err := rotateSeed(&auth)
+	if err != nil {
+		return err
+	}
+
+	err = resetAuth(*configPath, auth)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range auth.Users {
+		err = printEnrollment(output, user.Username, auth.Seed, codes[user.Username])
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(output, "The TOTP seed is rotated in %s.\n"+
+		"Restart alotame to apply. The old codes and sessions no longer work.\n", *configPath)
+
+	return wrapError(err, "failed to print result")
+}
+
+// addAdminIfMissing adds the user as an admin unless the user exists.
+func addAdminIfMissing(auth AuthConfig, username string) (AuthConfig, error) {
+	if slices.ContainsFunc(auth.Users, func(user UserConfig) bool { return user.Username == username }) {
+		return auth, nil
+	}
+
+	err := validateUsername(username)
+	if err != nil {
+		return auth, err
+	}
+
+	auth.Users = append(slices.Clone(auth.Users), UserConfig{Username: username, Role: RoleAdmin, RecoveryCodes: nil})
+
+	return auth, nil
+}
+
+// rotateSeed sets a new seed and new recovery codes of the users, and returns
+// the recovery codes by username.
+func rotateSeed(auth *AuthConfig) (map[string][]string, error) {
+	seed, err := generateSeed()
+	if err != nil {
+		return nil, err
+	}
+
+	users := slices.Clone(auth.Users)
+	codes := make(map[string][]string, len(users))
+
+	for idx, user := range users {
+		codes[user.Username], users[idx].RecoveryCodes, err = generateRecoveryCodes(user.Username, seed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	auth.Seed = seed
+	auth.Users = users
+
+	return codes, nil
+}
+
+// resetAuth saves the auth config and removes the saved sessions, which were
+// signed in with the old seed.
+func resetAuth(configPath string, auth AuthConfig) error {
+	err := SaveAuthConfig(configPath, auth)
+	if err != nil {
+		return err
+	}
+
+	if auth.SessionsPath == "" {
+		return nil
+	}
+
+	err = os.Remove(auth.SessionsPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return wrapError(err, "failed to remove sessions file")
+	}
+
+	return nil
+}
+
+// printEnrollment prints the new TOTP secret of the user as a QR code for the
+// terminal, the otpauth URI and the recovery codes.
+func printEnrollment(output io.Writer, username, seed string, codes []string) error {
+	secret := deriveTOTPSecret(username, seed)
+	uri := otpauthURI(username, secret)
+
+	code, err := qrcode.New(uri, qrcode.Medium)
+	if err != nil {
+		return wrapError(err, "failed to encode QR code")
+	}
+
+	_, err = fmt.Fprintf(output, "== %s ==\n\n"+
+		"Scan the QR code with the authenticator app:\n\n%s\n"+
+		"Or enter this secret manually: %s\n"+
+		"URI: %s\n\n"+
+		"Recovery codes (shown only once, each works once):\n\n",
+		username, code.ToSmallString(false), encodeTOTPSecret(secret), uri)
+	if err != nil {
+		return wrapError(err, "failed to print enrollment")
+	}
+
+	for _, recovery := range codes {
+		_, err = fmt.Fprintln(output, "  "+recovery)
+		if err != nil {
+			return wrapError(err, "failed to print enrollment")
+		}
+	}
+
+	_, err = fmt.Fprintln(output)
+
+	return wrapError(err, "failed to print enrollment")
+}
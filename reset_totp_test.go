@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recoveryCodePattern matches the recovery codes in the output.
+var recoveryCodePattern = regexp.MustCompile(`\b[a-z2-7]{5}-[a-z2-7]{5}\b`)
+
+// ============================================================================
+//  Tests for runResetTOTP
+// ============================================================================
+
+func TestRunResetTOTP(t *testing.T) {
+	t.Parallel()
+
+	sessionsPath := filepath.Join(t.TempDir(), "sessions.json")
+	require.NoError(t, os.WriteFile(sessionsPath, []byte(`[]`), 0o600))
+
+	// The username of older config files is migrated to the users
+	configPath := writeConfigFile(t, `{
+		"allowlistPath": "allowlist.txt",
+		"auth": {
+			"seed": "old-seed", "username": "alice", "sessionsPath": "`+sessionsPath+`",
+			"users": [{"username": "bob", "role": "viewer"}]
+		}
+	}`, 0o600)
+
+	output := new(bytes.Buffer)
+
+	require.NoError(t, runResetTOTP([]string{"--config", configPath}, fakeEnv(nil), output))
+
+	conf, err := LoadConfig(configPath)
+	require.NoError(t, err)
+
+	assert.Equal(t, "allowlist.txt", conf.AllowlistPath, "other settings are kept")
+	assert.Equal(t, sessionsPath, conf.Auth.SessionsPath)
+	assert.NotEqual(t, "old-seed", conf.Auth.Seed)
+	assert.Len(t, conf.Auth.Seed, 2*seedLength)
+	assert.NoFileExists(t, sessionsPath, "sessions of the old seed are removed")
+
+	require.Len(t, conf.Auth.Users, 2)
+	assert.Equal(t, "alice", conf.Auth.Users[0].Username)
+	assert.Equal(t, RoleAdmin, conf.Auth.Users[0].Role)
+	assert.Equal(t, "bob", conf.Auth.Users[1].Username)
+	assert.Equal(t, RoleViewer, conf.Auth.Users[1].Role)
+
+	codes := recoveryCodePattern.FindAllString(output.String(), -1)
+	require.Len(t, codes, 2*recoveryCodeCount)
+	assert.Contains(t, output.String(), "Restart alotame")
+
+	// The printed secrets and codes work with the new seed
+	authn := NewAuthenticator(configPath, conf.Auth)
+	now := time.Unix(1700000000, 0)
+
+	for idx, username := range []string{"alice", "bob"} {
+		secret := deriveTOTPSecret(username, conf.Auth.Seed)
+
+		assert.Contains(t, output.String(), encodeTOTPSecret(secret))
+		assert.Contains(t, output.String(), "otpauth://totp/Alotame:"+username+"?")
+		assert.Len(t, conf.Auth.Users[idx].RecoveryCodes, recoveryCodeCount)
+
+		require.NoError(t, authn.Verify(username, totpCode(secret, totpCounter(now)), now))
+		require.NoError(t, authn.Verify(username, codes[idx*recoveryCodeCount], now))
+	}
+}
+
+func TestRunResetTOTP_add_admin(t *testing.T) {
+	t.Parallel()
+
+	configPath := writeConfigFile(t,
+		`{"auth": {"seed": "old-seed", "users": [{"username": "alice", "role": "viewer"}]}}`, 0o600)
+	env := fakeEnv(map[string]string{envConfigPath: configPath})
+
+	require.NoError(t, runResetTOTP([]string{"--username", "bob"}, env, new(bytes.Buffer)))
+
+	conf, err := LoadConfig(configPath)
+	require.NoError(t, err)
+	require.Len(t, conf.Auth.Users, 2)
+	assert.Equal(t, UserConfig{Username: "alice", Role: RoleViewer, RecoveryCodes: conf.Auth.Users[0].RecoveryCodes},
+		conf.Auth.Users[0], "existing users are kept")
+	assert.Equal(t, "bob", conf.Auth.Users[1].Username)
+	assert.Equal(t, RoleAdmin, conf.Auth.Users[1].Role)
+
+	// An existing user is not added again
+	require.NoError(t, runResetTOTP([]string{"--username", "alice"}, env, new(bytes.Buffer)))
+
+	conf, err = LoadConfig(configPath)
+	require.NoError(t, err)
+	require.Len(t, conf.Auth.Users, 2)
+	assert.Equal(t, RoleViewer, conf.Auth.Users[0].Role)
+
+	err = runResetTOTP([]string{"--username", "a b"}, env, new(bytes.Buffer))
+	require.ErrorIs(t, err, ErrInvalidUsername)
+}
+
+func TestRunResetTOTP_clear(t *testing.T) {
+	t.Parallel()
+
+	configPath := writeConfigFile(t,
+		`{"auth": {"seed": "old-seed", "users": [{"username": "alice", "role": "admin", "recoveryCodes": ["hash"]}]}}`,
+		0o600)
+	output := new(bytes.Buffer)
+
+	require.NoError(t, runResetTOTP([]string{"--config", configPath, "--clear"}, fakeEnv(nil), output))
+
+	conf, err := LoadConfig(configPath)
+	require.NoError(t, err)
+
+	assert.Empty(t, conf.Auth.Seed)
+	assert.Empty(t, conf.Auth.Users)
+	assert.False(t, NewAuthenticator(configPath, conf.Auth).Enrolled())
+	assert.Contains(t, output.String(), "/admin/enroll")
+}
+
+func TestRunResetTOTP_errors(t *testing.T) {
+	t.Parallel()
+
+	notEnrolled := writeConfigFile(t, `{}`, 0o600)
+	missing := filepath.Join(t.TempDir(), "missing.json")
+
+	for name, test := range map[string]struct {
+		args   []string
+		expect error
+	}{
+		"no config path": {args: nil, expect: ErrNoConfigPath},
+		"no username":    {args: []string{"--config", notEnrolled}, expect: ErrNoUsername},
+		"missing config": {args: []string{"--config", missing}, expect: os.ErrNotExist},
+		"help":           {args: []string{"--help"}, expect: flag.ErrHelp},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := runResetTOTP(test.args, fakeEnv(nil), new(bytes.Buffer))
+
+			require.ErrorIs(t, err, test.expect)
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session defaults.
+const (
+	sessionIdleTimeoutDefault = 30 * time.Minute
+	sessionMaxAgeDefault      = 12 * time.Hour
+	// sessionTokenLength is the byte length of the session ID and the CSRF
+	// token before encoding.
+	sessionTokenLength = 32
+	// sessionSaveInterval limits how often the last access time is written to
+	// the sessions file.
+	sessionSaveInterval = time.Minute
+	// sessionsFileMode is the file mode of the sessions file.
+	sessionsFileMode os.FileMode = 0o600
+)
+
+// Names of the session cookie and the CSRF token fields.
+const (
+	sessionCookieName = "alotame_session"
+	csrfFormField     = "csrf_token"
+	csrfHeader        = "X-CSRF-Token"
+)
+
+// Errors of sessions.
+var (
+	ErrSessionNotFound = errors.New("session not found or expired")
+	ErrCSRFToken       = errors.New("invalid CSRF token")
+)
+
+// ============================================================================
+//  Session Store
+// ============================================================================
+
+// Session is a signed-in admin session.
+type Session struct {
+	Username string `json:"username"`
+	// CSRFToken must be sent with every state-changing request of the session.
+	CSRFToken string    `json:"csrfToken"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"lastSeen"`
+	// Role is the current role of the user, set by requireSession.
+	Role Role `json:"-"`
+
+	// key is the hash of the session ID. The ID itself is only in the cookie.
+	key string
+}
+
+// sessionRecord is the JSON form of a session in the sessions file.
+type sessionRecord struct {
+	Key string `json:"key"`
+	Session
+}
+
+// SessionStore keeps the server-side sessions. Sessions expire after the idle
+// timeout without access or after the max age since sign-in, whichever comes
+// first.
+//
+// If path is set, sessions are saved to the file so that they survive a
+// restart. Only the hashes of the session IDs are saved.
+type SessionStore struct {
+	path        string
+	idleTimeout time.Duration
+	maxAge      time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	lastSave time.Time
+}
+
+// NewSessionStore returns a new SessionStore. It loads the sessions file if
+// path is set and the file exists.
+func NewSessionStore(path string, idleTimeout, maxAge time.Duration) (*SessionStore, error) {
+	store := new(SessionStore)
+	store.path = path
+	store.idleTimeout = idleTimeout
+	store.maxAge = maxAge
+	store.sessions = make(map[string]*Session)
+
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+
+	if err != nil {
+		return nil, wrapError(err, "failed to read sessions file")
+	}
+
+	var records []sessionRecord
+
+	err = json.Unmarshal(data, &records)
+	if err != nil {
+		return nil, wrapError(err, "failed to parse sessions file "+path)
+	}
+
+	now := time.Now()
+
+	for _, record := range records {
+		sess := record.Session
+		sess.key = record.Key
+
+		if !store.expired(&sess, now) {
+			store.sessions[sess.key] = &sess
+		}
+	}
+
+	return store, nil
+}
+
+// Create starts a new session of the user and returns the session ID to set
+// in the cookie.
+func (s *SessionStore) Create(username string, now time.Time) (string, Session, error) {
+	sessionID, err := randomToken()
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	csrfToken, err := randomToken()
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	sess := &Session{
+		Username:  username,
+		CSRFToken: csrfToken,
+		Created:   now,
+		LastSeen:  now,
+		Role:      "",
+		key:       sessionKey(sessionID),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[sess.key] = sess
+	s.saveLocked(now)
+
+	return sessionID, *sess, nil
+}
+
+// Get returns the session of the ID and extends its idle timeout.
+func (s *SessionStore) Get(sessionID string, now time.Time) (Session, error) {
+	key := sessionKey(sessionID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[key]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+
+	if s.expired(sess, now) {
+		delete(s.sessions, key)
+		s.saveLocked(now)
+
+		return Session{}, ErrSessionNotFound
+	}
+
+	sess.LastSeen = now
+
+	if now.Sub(s.lastSave) >= sessionSaveInterval {
+		s.saveLocked(now)
+	}
+
+	return *sess, nil
+}
+
+// Revoke ends the session of the ID.
+func (s *SessionStore) Revoke(sessionID string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, sessionKey(sessionID))
+	s.saveLocked(now)
+}
+
+// RevokeUser ends all the sessions of the user and returns the number of
+// revoked sessions.
+func (s *SessionStore) RevokeUser(username string, now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+
+	for key, sess := range s.sessions {
+		if sess.Username == username {
+			delete(s.sessions, key)
+
+			count++
+		}
+	}
+
+	s.saveLocked(now)
+
+	return count
+}
+
+// expired returns true if the session is past its idle timeout or max age.
+func (s *SessionStore) expired(sess *Session, now time.Time) bool {
+	return now.Sub(sess.LastSeen) > s.idleTimeout || now.Sub(sess.Created) > s.maxAge
+}
+
+// saveLocked writes the sessions file, dropping expired sessions. Errors are
+// logged since the sessions stay valid in memory. The caller must hold mu.
+func (s *SessionStore) saveLocked(now time.Time) {
+	s.lastSave = now
+
+	if s.path == "" {
+		return
+	}
+
+	records := make([]sessionRecord, 0, len(s.sessions))
+
+	for key, sess := range s.sessions {
+		if s.expired(sess, now) {
+			delete(s.sessions, key)
+
+			continue
+		}
+
+		records = append(records, sessionRecord{Key: key, Session: *sess})
+	}
+
+	data, err := json.Marshal(records)
+	if err == nil {
+		err = writeFileAtomic(s.path, data, sessionsFileMode)
+	}
+
+	if err != nil {
+		slog.Error("failed to save sessions", "path", s.path, "error", err)
+	}
+}
+
+// sessionKey returns the key to store the session of the ID, so that the
+// sessions file does not hold usable session IDs.
+func sessionKey(sessionID string) string {
+	return secureHash(sessionID, 0)
+}
+
+// randomToken returns a random URL-safe token.
+func randomToken() (string, error) {
+	token := make([]byte, sessionTokenLength)
+
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", wrapError(err, "failed to generate token")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// ============================================================================
+//  Cookies and Middleware
+// ============================================================================
+
+// sessionContextKey is the context key of the current session.
+type sessionContextKey struct{}
+
+// setSessionCookie sets the session cookie. The cookie is not readable by
+// scripts, not sent on cross-site requests and only sent over HTTPS (or to
+// localhost, which browsers treat as secure).
+func setSessionCookie(resWriter http.ResponseWriter, sessionID string, maxAge time.Duration) {
+	http.SetCookie(resWriter, &http.Cookie{ //nolint:exhaustruct // defaults for the rest
+		Name:     sessionCookieName,
+		Value:    sessionID,
+		Path:     "/admin",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// clearSessionCookie removes the session cookie from the browser.
+func clearSessionCookie(resWriter http.ResponseWriter) {
+	http.SetCookie(resWriter, &http.Cookie{ //nolint:exhaustruct // defaults for the rest
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/admin",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// requireSession returns a handler that serves next only with a valid
+// session of a user with the required role. State-changing requests must also
+// have the CSRF token of the session in the csrf_token form field or the
+// X-CSRF-Token header.
+//
+// The role is looked up on each request, so that role changes and removed
+// users take effect at once. Without a session, HTML pages redirect to the
+// login page and the others respond with 401. Without the role, it responds
+// with 403.
+func (svc *adminServices) requireSession(required Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(resWriter http.ResponseWriter, req *http.Request) {
+		cookie, err := req.Cookie(sessionCookieName)
+		if err == nil {
+			var sess Session
+
+			sess, err = svc.sessions.Get(cookie.Value, svc.now())
+			if err == nil {
+				role, ok := svc.authn.Role(sess.Username)
+				if ok {
+					svc.serveSession(resWriter, req, sess, role, required, next)
+
+					return
+				}
+			}
+		}
+
+		if req.Method == http.MethodGet && acceptsHTML(req) {
+			http.Redirect(resWriter, req, "/admin/login", http.StatusSeeOther)
+
+			return
+		}
+
+		http.Error(resWriter, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+}
+
+// serveSession serves next with the session in the context if the role of the
+// user allows and the CSRF token is valid.
+func (svc *adminServices) serveSession(resWriter http.ResponseWriter, req *http.Request,
+	sess Session, role, required Role, next http.HandlerFunc,
+) {
+	if !isSafeMethod(req.Method) && !validCSRFToken(req, sess) {
+		http.Error(resWriter, ErrCSRFToken.Error(), http.StatusForbidden)
+
+		return
+	}
+
+	if !role.Allows(required) {
+		slog.Warn("access denied", "username", sess.Username, "role", role, "required", required,
+			"method", req.Method, "path", req.URL.Path)
+		http.Error(resWriter, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	sess.Role = role
+
+	next(resWriter, req.WithContext(context.WithValue(req.Context(), sessionContextKey{}, sess)))
+}
+
+// sessionFromContext returns the session set by requireSession.
+func sessionFromContext(ctx context.Context) (Session, bool) {
+	sess, ok := ctx.Value(sessionContextKey{}).(Session)
+
+	return sess, ok
+}
+
+// validCSRFToken checks the CSRF token of the request against the session.
+func validCSRFToken(req *http.Request, sess Session) bool {
+	token := req.Header.Get(csrfHeader)
+	if token == "" {
+		token = req.PostFormValue(csrfFormField)
+	}
+
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(sess.CSRFToken)) == 1
+}
+
+// isSafeMethod returns true for the methods that must not change the state.
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// acceptsHTML returns true if the request is from a browser navigation.
+func acceptsHTML(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), "text/html")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for SessionStore
+// ============================================================================
+
+func TestSessionStore_timeouts(t *testing.T) {
+	t.Parallel()
+
+	store, err := NewSessionStore("", 10*time.Minute, time.Hour)
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+
+	sessionID, created, err := store.Create("alice", now)
+	require.NoError(t, err)
+	assert.NotEmpty(t, created.CSRFToken)
+	assert.NotEqual(t, sessionID, created.CSRFToken)
+
+	// Each access extends the idle timeout up to the max age
+	for elapsed := 9 * time.Minute; elapsed < time.Hour; elapsed += 9 * time.Minute {
+		sess, err := store.Get(sessionID, now.Add(elapsed))
+		require.NoError(t, err, "elapsed %s", elapsed)
+		assert.Equal(t, "alice", sess.Username)
+	}
+
+	_, err = store.Get(sessionID, now.Add(time.Hour+time.Second))
+	require.ErrorIs(t, err, ErrSessionNotFound, "max age")
+
+	sessionID, _, err = store.Create("alice", now)
+	require.NoError(t, err)
+
+	_, err = store.Get(sessionID, now.Add(11*time.Minute))
+	require.ErrorIs(t, err, ErrSessionNotFound, "idle timeout")
+}
+
+func TestSessionStore_revoke(t *testing.T) {
+	t.Parallel()
+
+	store, err := NewSessionStore("", time.Hour, time.Hour)
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	first, _, err := store.Create("alice", now)
+	require.NoError(t, err)
+
+	second, _, err := store.Create("alice", now)
+	require.NoError(t, err)
+
+	store.Revoke(first, now)
+
+	_, err = store.Get(first, now)
+	require.ErrorIs(t, err, ErrSessionNotFound)
+
+	_, err = store.Get(second, now)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, store.RevokeUser("alice", now))
+	assert.Equal(t, 0, store.RevokeUser("alice", now))
+}
+
+func TestSessionStore_persistence(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "sessions.json")
+
+	store, err := NewSessionStore(path, time.Hour, 12*time.Hour)
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	kept, sess, err := store.Create("alice", now)
+	require.NoError(t, err)
+
+	revoked, _, err := store.Create("alice", now)
+	require.NoError(t, err)
+
+	store.Revoke(revoked, now)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, sessionsFileMode, info.Mode().Perm())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.False(t, strings.Contains(string(data), kept), "session IDs must not be saved")
+
+	// Restart
+	restarted, err := NewSessionStore(path, time.Hour, 12*time.Hour)
+	require.NoError(t, err)
+
+	restored, err := restarted.Get(kept, now)
+	require.NoError(t, err)
+	assert.Equal(t, sess.CSRFToken, restored.CSRFToken)
+
+	_, err = restarted.Get(revoked, now)
+	require.ErrorIs(t, err, ErrSessionNotFound)
+}
+
+func TestNewSessionStore_broken_file(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	require.NoError(t, os.WriteFile(path, []byte("{broken"), 0o600))
+
+	_, err := NewSessionStore(path, time.Hour, time.Hour)
+
+	require.Error(t, err)
+}
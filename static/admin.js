@@ -0,0 +1,134 @@
+// Live validation of the allowlist entry forms. The server validates the
+// entry again on preview, so the page works without this script too.
+(function () {
+  "use strict";
+
+  var delay = 300;
+
+  document.querySelectorAll("input[data-validate]").forEach(function (input) {
+    var output = document.getElementById(input.dataset.validate);
+    var line = input.form.elements.namedItem("line");
+    var list = input.form.elements.namedItem("list");
+    var timer;
+
+    input.addEventListener("input", function () {
+      clearTimeout(timer);
+      timer = setTimeout(function () {
+        if (input.value.trim() === "") {
+          output.textContent = "";
+          input.setCustomValidity("");
+
+          return;
+        }
+
+        var query = new URLSearchParams({
+          entry: input.value, line: line ? line.value : "", list: list ? list.value : ""
+        });
+
+        fetch("/admin/allowlist/validate?" + query, { credentials: "same-origin" })
+          .then(function (res) { return res.json(); })
+          .then(function (result) {
+            output.className = result.valid ? "notice" : "error";
+            output.textContent = result.valid ? result.kind + ": " + result.unicode : result.error;
+            input.setCustomValidity(result.valid ? "" : result.error);
+          })
+          .catch(function () {
+            output.textContent = "";
+            input.setCustomValidity("");
+          });
+      }, delay);
+    });
+  });
+})();
+
+// Live status of the Blocky list refresh after a change. The page shows the
+// state at the time it was rendered, so this only updates the pending ones.
+(function () {
+  "use strict";
+
+  var interval = 1000;
+  var maxPolls = 60;
+  var box = document.getElementById("blocky-status");
+
+  if (!box || !box.querySelector("li.pending")) {
+    return;
+  }
+
+  var polls = 0;
+
+  function time(value) {
+    return new Date(value).toTimeString().slice(0, 8);
+  }
+
+  function poll() {
+    fetch("/admin/status", { credentials: "same-origin" })
+      .then(function (res) { return res.json(); })
+      .then(function (status) {
+        var pending = false;
+
+        (status.blocky || []).forEach(function (instance) {
+          var item = box.querySelector('li[data-url="' + CSS.escape(instance.url) + '"]');
+          if (!item) {
+            return;
+          }
+
+          var text = item.querySelector("span");
+
+          if (instance.pending) {
+            pending = true;
+            item.className = "pending";
+            text.textContent = instance.state === "unreachable" ? "waiting for Blocky to be reachable" : "refreshing...";
+          } else if (instance.lastError) {
+            item.className = "error";
+            text.textContent = "failed: " + instance.lastError;
+          } else if (instance.confirmed) {
+            item.className = "notice";
+            text.textContent = "confirmed at " + time(instance.lastConfirmed);
+          }
+        });
+
+        if (pending && ++polls < maxPolls) {
+          setTimeout(poll, interval);
+        }
+      })
+      .catch(function () {});
+  }
+
+  setTimeout(poll, interval);
+})();
+
+// Countdown of a pause of blocking. Blocky resumes blocking by itself at the
+// time of the data-countdown attribute.
+(function () {
+  "use strict";
+
+  var items = document.querySelectorAll("span[data-countdown]");
+
+  if (items.length === 0) {
+    return;
+  }
+
+  function format(seconds) {
+    var hours = Math.floor(seconds / 3600);
+    var minutes = Math.floor(seconds % 3600 / 60);
+    var rest = seconds % 60;
+
+    if (hours > 0) {
+      return hours + "h" + minutes + "m" + rest + "s";
+    }
+
+    return minutes > 0 ? minutes + "m" + rest + "s" : rest + "s";
+  }
+
+  function tick() {
+    var now = Date.now();
+
+    items.forEach(function (item) {
+      var left = Math.max(Math.round((new Date(item.dataset.countdown).getTime() - now) / 1000), 0);
+
+      item.textContent = format(left);
+    });
+  }
+
+  setInterval(tick, 1000);
+})();
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Self-signed certificate settings.
+const (
+	selfSignedValidity = 365 * 24 * time.Hour
+	serialNumberBits   = 128
+	certFileMode       = 0o644
+	keyFileMode        = 0o600
+)
+
+// Errors of TLS configuration.
+var (
+	ErrTLSConfig = errors.New("both tlsCert and tlsKey must be set to enable TLS")
+)
+
+// ============================================================================
+//  TLS Configuration
+// ============================================================================
+
+// newTLSConfig returns the TLS configuration of the server. It requires TLS 1.3
+// and reloads the certificate from disk when the files change.
+//
+// If conf.TLSSelfSigned is true and the files do not exist, a self-signed
+// certificate is generated first.
+func newTLSConfig(conf ServerConfig) (*tls.Config, error) {
+	if conf.TLSCert == "" || conf.TLSKey == "" {
+		return nil, ErrTLSConfig
+	}
+
+	if conf.TLSSelfSigned && !fileExists(conf.TLSCert) && !fileExists(conf.TLSKey) {
+		err := generateSelfSignedCert(conf.TLSCert, conf.TLSKey, selfSignedHosts(conf.Host))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reloader := newCertReloader(conf.TLSCert, conf.TLSKey)
+
+	_, err := reloader.GetCertificate(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConf := new(tls.Config)
+	tlsConf.MinVersion = tls.VersionTLS13
+	tlsConf.GetCertificate = reloader.GetCertificate
+
+	return tlsConf, nil
+}
+
+// ============================================================================
+//  Certificate Reloader
+// ============================================================================
+
+// certReloader loads the certificate and key pair and reloads them when the
+// files change. If the new files fail to load, the previous certificate is
+// kept.
+type certReloader struct {
+	certPath string
+	keyPath  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	certStamp fileStamp
+	keyStamp  fileStamp
+}
+
+func newCertReloader(certPath, keyPath string) *certReloader {
+	reloader := new(certReloader)
+	reloader.certPath = certPath
+	reloader.keyPath = keyPath
+
+	return reloader
+}
+
+// GetCertificate returns the current certificate. It is used as the
+// tls.Config.GetCertificate callback and checks the files on each handshake.
+func (r *certReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certStamp, keyStamp := statFile(r.certPath), statFile(r.keyPath)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cert != nil && certStamp == r.certStamp && keyStamp == r.keyStamp {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		if r.cert != nil {
+			slog.Error("failed to reload TLS certificate, keeping the current one", "error", err)
+
+			return r.cert, nil
+		}
+
+		return nil, wrapError(err, "failed to load TLS certificate")
+	}
+
+	r.cert = &cert
+	r.certStamp = certStamp
+	r.keyStamp = keyStamp
+
+	slog.Info("TLS certificate loaded", "path", r.certPath, "sha256", certFingerprint(cert.Certificate[0]))
+
+	return r.cert, nil
+}
+
+// ============================================================================
+//  Self-signed Certificate
+// ============================================================================
+
+// generateSelfSignedCert generates a self-signed ECDSA certificate for the
+// given hosts and writes the PEM encoded certificate and key to the paths.
+func generateSelfSignedCert(certPath, keyPath string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return wrapError(err, "failed to generate key")
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), serialNumberBits))
+	if err != nil {
+		return wrapError(err, "failed to generate serial number")
+	}
+
+	now := time.Now()
+
+	template := new(x509.Certificate)
+	template.SerialNumber = serial
+	template.Subject = pkix.Name{CommonName: "Alotame self-signed"} //nolint:exhaustruct // only CN is needed
+	template.NotBefore = now.Add(-time.Hour)
+	template.NotAfter = now.Add(selfSignedValidity)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	template.BasicConstraintsValid = true
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return wrapError(err, "failed to create certificate")
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return wrapError(err, "failed to encode key")
+	}
+
+	err = writePEM(keyPath, "PRIVATE KEY", keyDER, keyFileMode)
+	if err == nil {
+		err = writePEM(certPath, "CERTIFICATE", der, certFileMode)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	slog.Info("generated self-signed TLS certificate. Verify this fingerprint in your browser",
+		"path", certPath, "hosts", hosts, "sha256", certFingerprint(der))
+
+	return nil
+}
+
+// selfSignedHosts returns the host names and IP addresses for the self-signed
+// certificate.
+func selfSignedHosts(host string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+
+	if name, err := os.Hostname(); err == nil && name != "" {
+		hosts = append(hosts, name)
+	}
+
+	if host != "" && host != hostDefault && host != "localhost" && host != "::" {
+		hosts = append(hosts, host)
+	}
+
+	return hosts
+}
+
+// writePEM writes the DER data as a PEM block. It fails if the file exists.
+func writePEM(path, blockType string, der []byte, mode os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return wrapError(err, "failed to create "+path)
+	}
+
+	err = pem.Encode(file, &pem.Block{Type: blockType, Headers: nil, Bytes: der})
+	if err != nil {
+		_ = file.Close()
+
+		return wrapError(err, "failed to write "+path)
+	}
+
+	return wrapError(file.Close(), "failed to close "+path)
+}
+
+// certFingerprint returns the SHA-256 fingerprint of the DER certificate in
+// "AB:CD:..." form as shown by browsers.
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	hexStr := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	pairs := make([]string, 0, len(sum))
+	for idx := 0; idx < len(hexStr); idx += 2 {
+		pairs = append(pairs, hexStr[idx:idx+2])
+	}
+
+	return strings.Join(pairs, ":")
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+
+	return err == nil
+}
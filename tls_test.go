@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for newTLSConfig
+// ============================================================================
+
+func TestNewTLSConfig_self_signed(t *testing.T) {
+	t.Parallel()
+
+	conf := tlsServerConfig(t)
+	conf.TLSSelfSigned = true
+	conf.Host = "alotame.lan"
+
+	tlsConf, err := newTLSConfig(conf)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConf.MinVersion)
+
+	cert, err := tlsConf.GetCertificate(nil)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	assert.Contains(t, leaf.DNSNames, "localhost")
+	assert.Contains(t, leaf.DNSNames, "alotame.lan")
+	require.NoError(t, leaf.VerifyHostname("127.0.0.1"))
+
+	info, err := os.Stat(conf.TLSKey)
+	require.NoError(t, err)
+
+	if checkFileModeSupported {
+		assert.Equal(t, os.FileMode(keyFileMode), info.Mode().Perm(), "key must be readable only by the owner")
+	}
+
+	// The existing files are used on the next start
+	certPEM, err := os.ReadFile(conf.TLSCert)
+	require.NoError(t, err)
+
+	_, err = newTLSConfig(conf)
+	require.NoError(t, err)
+
+	certPEM2, err := os.ReadFile(conf.TLSCert)
+	require.NoError(t, err)
+
+	assert.Equal(t, certPEM, certPEM2, "certificate should not be regenerated")
+}
+
+func TestNewTLSConfig_missing_files(t *testing.T) {
+	t.Parallel()
+
+	_, err := newTLSConfig(tlsServerConfig(t))
+
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestNewTLSConfig_no_paths(t *testing.T) {
+	t.Parallel()
+
+	conf := DefaultServerConfig()
+	conf.TLSCert = "cert.pem"
+
+	_, err := newTLSConfig(conf)
+
+	require.ErrorIs(t, err, ErrTLSConfig)
+}
+
+func TestServerConfig_Validate_tls(t *testing.T) {
+	t.Parallel()
+
+	conf := DefaultServerConfig()
+	conf.TLSCert = "cert.pem"
+
+	require.ErrorIs(t, conf.Validate(), ErrTLSConfig)
+
+	conf = DefaultServerConfig()
+	conf.TLSSelfSigned = true
+
+	require.ErrorIs(t, conf.Validate(), ErrConfigInvalid)
+}
+
+// ============================================================================
+//  Tests for certReloader
+// ============================================================================
+
+func TestCertReloader_reload_on_change(t *testing.T) {
+	t.Parallel()
+
+	conf := tlsServerConfig(t)
+	require.NoError(t, generateSelfSignedCert(conf.TLSCert, conf.TLSKey, []string{"localhost"}))
+
+	reloader := newCertReloader(conf.TLSCert, conf.TLSKey)
+
+	cert1, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+
+	// Renew the certificate
+	newConf := tlsServerConfig(t)
+	require.NoError(t, generateSelfSignedCert(newConf.TLSCert, newConf.TLSKey, []string{"localhost"}))
+	replaceFile(t, newConf.TLSKey, conf.TLSKey)
+	replaceFile(t, newConf.TLSCert, conf.TLSCert)
+
+	cert2, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+
+	assert.NotEqual(t, cert1.Certificate[0], cert2.Certificate[0])
+}
+
+func TestCertReloader_keeps_current_on_error(t *testing.T) {
+	t.Parallel()
+
+	conf := tlsServerConfig(t)
+	require.NoError(t, generateSelfSignedCert(conf.TLSCert, conf.TLSKey, []string{"localhost"}))
+
+	reloader := newCertReloader(conf.TLSCert, conf.TLSKey)
+
+	cert1, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+
+	updateFile(t, conf.TLSCert, "broken")
+
+	cert2, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, cert1, cert2)
+}
+
+// ============================================================================
+//  Tests for serving HTTPS
+// ============================================================================
+
+func TestStartServer_tls(t *testing.T) {
+	t.Parallel()
+
+	conf := tlsServerConfig(t)
+	conf.TLSSelfSigned = true
+
+	tlsConf, err := newTLSConfig(conf)
+	require.NoError(t, err)
+
+	listener, err := new(net.ListenConfig).Listen(context.Background(), "tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /allowlist.txt", newAllowlistHandler(new(StaticAllowlistProvider)))
+
+	server := newHTTPServer(conf, mux)
+	server.TLSConfig = tlsConf
+	errCh := make(chan error, 1)
+
+	go startServer(server, listener, "/allowlist.txt", errCh)
+
+	defer server.Close()
+
+	url := "https://" + listener.Addr().String() + "/allowlist.txt"
+
+	// TLS 1.3 is accepted
+	resp, err := tlsClient(t, conf.TLSCert, tls.VersionTLS13).Get(url) //nolint:noctx // test only
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	assert.Equal(t, uint16(tls.VersionTLS13), resp.TLS.Version)
+	assert.Equal(t, allowlist, string(body))
+
+	// TLS 1.2 is rejected
+	_, err = tlsClient(t, conf.TLSCert, tls.VersionTLS12).Get(url) //nolint:noctx // test only
+	require.Error(t, err)
+}
+
+// ============================================================================
+//  Tests for helpers
+// ============================================================================
+
+func TestCertFingerprint(t *testing.T) {
+	t.Parallel()
+
+	fingerprint := certFingerprint([]byte("dummy"))
+
+	assert.Regexp(t, regexp.MustCompile(`^([0-9A-F]{2}:){31}[0-9A-F]{2}$`), fingerprint)
+}
+
+func TestWritePEM_no_overwrite(t *testing.T) {
+	t.Parallel()
+
+	path := writeTempFile(t, "existing")
+
+	err := writePEM(path, "CERTIFICATE", []byte("dummy"), certFileMode)
+
+	require.ErrorIs(t, err, os.ErrExist)
+}
+
+// ============================================================================
+//  Test Helpers
+// ============================================================================
+
+// tlsServerConfig returns a server config with certificate paths in a
+// temporary directory. The files do not exist.
+func tlsServerConfig(t *testing.T) ServerConfig {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	conf := DefaultServerConfig()
+	conf.TLSCert = filepath.Join(dir, "cert.pem")
+	conf.TLSKey = filepath.Join(dir, "key.pem")
+
+	return conf
+}
+
+// tlsClient returns an HTTP client that trusts the certificate and uses only
+// the given TLS version.
+func tlsClient(t *testing.T, certPath string, version uint16) *http.Client {
+	t.Helper()
+
+	certPEM, err := os.ReadFile(certPath)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(certPEM))
+
+	tlsConf := new(tls.Config)
+	tlsConf.RootCAs = pool
+	tlsConf.MinVersion = version
+	tlsConf.MaxVersion = version
+
+	transport := new(http.Transport)
+	transport.TLSClientConfig = tlsConf
+
+	client := new(http.Client)
+	client.Transport = transport
+	client.Timeout = 5 * time.Second
+
+	return client
+}
+
+// replaceFile renames src to dst and moves the mtime of dst forward.
+func replaceFile(t *testing.T, src, dst string) {
+	t.Helper()
+
+	require.NoError(t, os.Rename(src, dst))
+
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(dst, future, future))
+}
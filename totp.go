@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // RFC 6238 default, supported by all authenticator apps
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// TOTP parameters (RFC 6238). The defaults of authenticator apps are used so
+// that the otpauth URI works with any of them.
+const (
+	totpDigits = 6
+	// totpModulo is 10^totpDigits.
+	totpModulo = 1_000_000
+	totpPeriod = 30 * time.Second
+	// totpSkew is the number of steps before and after the current one to
+	// accept, to tolerate clock drift of the authenticator.
+	totpSkew = 1
+	// totpSecretLength is the byte length of the derived TOTP secret.
+	totpSecretLength = 32
+	// seedLength is the byte length of the random seed in the config file.
+	seedLength = 32
+	// totpIssuer is the issuer shown in the authenticator app.
+	totpIssuer = "Alotame"
+)
+
+// QR code rendering.
+const (
+	qrPNGSize   = 256
+	qrSVGModule = 8 // pixels per module
+	qrQuietZone = 4 // modules of margin required around the code
+)
+
+// ============================================================================
+//  Secrets
+// ============================================================================
+
+// generateSeed returns a new random seed as a hex string.
+func generateSeed() (string, error) {
+	seed := make([]byte, seedLength)
+
+	_, err := rand.Read(seed)
+	if err != nil {
+		return "", wrapError(err, "failed to generate seed")
+	}
+
+	return hex.EncodeToString(seed), nil
+}
+
+// deriveTOTPSecret derives the TOTP secret of the user from the seed as
+// SHAKE256(<username><seed>, totpSecretLength).
+//
+// The hash is used only for deterministic secret derivation, so the same
+// secret is derived as long as the seed in the config file is kept.
+func deriveTOTPSecret(username, seed string) []byte {
+	secret, _ := hex.DecodeString(secureHash(username+seed, totpSecretLength)) // always valid hex
+
+	return secret
+}
+
+// ============================================================================
+//  TOTP
+// ============================================================================
+
+// totpCounter returns the time step counter at the given time.
+func totpCounter(now time.Time) uint64 {
+	return uint64(now.Unix()) / uint64(totpPeriod.Seconds()) //nolint:gosec // unix time is positive
+}
+
+// totpCode returns the code of the secret at the time step counter (HOTP,
+// RFC 4226).
+func totpCode(secret []byte, counter uint64) string {
+	msg := make([]byte, 8) //nolint:mnd // size of uint64
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, secret)
+	_, _ = mac.Write(msg) // never fails
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0x0f                            //nolint:mnd // low 4 bits
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff //nolint:mnd // drop the sign bit
+
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulo)
+}
+
+// verifyTOTP checks the code against the secret at the given time, accepting
+// totpSkew steps before and after. It returns the matched time step counter.
+func verifyTOTP(secret []byte, code string, now time.Time) (uint64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	if _, err := strconv.ParseUint(code, 10, 32); err != nil {
+		return 0, false
+	}
+
+	current := totpCounter(now)
+
+	for delta := -totpSkew; delta <= totpSkew; delta++ {
+		counter := current + uint64(delta) //nolint:gosec // wraps only before 1970
+		expect := totpCode(secret, counter)
+
+		if subtle.ConstantTimeCompare([]byte(expect), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+
+	return 0, false
+}
+
+// ============================================================================
+//  Enrollment
+// ============================================================================
+
+// otpauthURI returns the Key URI to register the secret in an authenticator
+// app.
+func otpauthURI(username string, secret []byte) string {
+	query := url.Values{}
+	query.Set("secret", encodeTOTPSecret(secret))
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(totpDigits))
+	query.Set("period", strconv.Itoa(int(totpPeriod.Seconds())))
+
+	uri := url.URL{ //nolint:exhaustruct // only the needed parts
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + totpIssuer + ":" + username,
+		RawQuery: query.Encode(),
+	}
+
+	return uri.String()
+}
+
+// encodeTOTPSecret returns the secret in unpadded base32 as used by the
+// otpauth URI and for manual entry.
+func encodeTOTPSecret(secret []byte) string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+}
+
+// qrPNG returns the QR code of the content as PNG.
+func qrPNG(content string) ([]byte, error) {
+	png, err := qrcode.Encode(content, qrcode.Medium, qrPNGSize)
+
+	return png, wrapError(err, "failed to encode QR code")
+}
+
+// qrSVG returns the QR code of the content as SVG.
+func qrSVG(content string) ([]byte, error) {
+	code, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return nil, wrapError(err, "failed to encode QR code")
+	}
+
+	code.DisableBorder = true
+	bitmap := code.Bitmap()
+	size := (len(bitmap) + 2*qrQuietZone) * qrSVGModule
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`,
+		size, size, size, size)
+	fmt.Fprintf(buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+
+	for row, line := range bitmap {
+		for col, black := range line {
+			if black {
+				fmt.Fprintf(buf, "M%d %dh%dv%dh-%dz",
+					(col+qrQuietZone)*qrSVGModule, (row+qrQuietZone)*qrSVGModule,
+					qrSVGModule, qrSVGModule, qrSVGModule)
+			}
+		}
+	}
+
+	buf.WriteString(`"/></svg>`)
+
+	return buf.Bytes(), nil
+}
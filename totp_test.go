@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for TOTP
+// ============================================================================
+
+func TestTOTPCode_rfc6238(t *testing.T) {
+	t.Parallel()
+
+	// Test vectors of RFC 6238 Appendix B (SHA1), truncated to 6 digits
+	secret := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix   int64
+		expect string
+	}{
+		{unix: 59, expect: "287082"},
+		{unix: 1111111109, expect: "081804"},
+		{unix: 1111111111, expect: "050471"},
+		{unix: 1234567890, expect: "005924"},
+		{unix: 2000000000, expect: "279037"},
+	}
+
+	for _, test := range tests {
+		counter := totpCounter(time.Unix(test.unix, 0))
+
+		assert.Equal(t, test.expect, totpCode(secret, counter), "time %d", test.unix)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	t.Parallel()
+
+	secret := deriveTOTPSecret("alice", "seed")
+	now := time.Unix(1700000000, 0)
+	current := totpCounter(now)
+
+	tests := []struct {
+		name   string
+		code   string
+		expect bool
+	}{
+		{name: "current step", code: totpCode(secret, current), expect: true},
+		{name: "previous step", code: totpCode(secret, current-1), expect: true},
+		{name: "next step", code: totpCode(secret, current+1), expect: true},
+		{name: "two steps ago", code: totpCode(secret, current-2), expect: false},
+		{name: "two steps ahead", code: totpCode(secret, current+2), expect: false},
+		{name: "empty", code: "", expect: false},
+		{name: "too short", code: "12345", expect: false},
+		{name: "not a number", code: "12345a", expect: false},
+		{name: "signed", code: "+12345", expect: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, ok := verifyTOTP(secret, test.code, now)
+
+			assert.Equal(t, test.expect, ok)
+		})
+	}
+}
+
+func TestDeriveTOTPSecret(t *testing.T) {
+	t.Parallel()
+
+	secret := deriveTOTPSecret("alice", "seed")
+
+	assert.Len(t, secret, totpSecretLength)
+	assert.Equal(t, secret, deriveTOTPSecret("alice", "seed"), "must be deterministic")
+	assert.NotEqual(t, secret, deriveTOTPSecret("bob", "seed"), "must differ by username")
+	assert.NotEqual(t, secret, deriveTOTPSecret("alice", "other"), "must differ by seed")
+}
+
+func TestGenerateSeed(t *testing.T) {
+	t.Parallel()
+
+	seed1, err := generateSeed()
+	require.NoError(t, err)
+
+	seed2, err := generateSeed()
+	require.NoError(t, err)
+
+	assert.Len(t, seed1, seedLength*2)
+	assert.NotEqual(t, seed1, seed2)
+}
+
+// ============================================================================
+//  Tests for enrollment
+// ============================================================================
+
+func TestOtpauthURI(t *testing.T) {
+	t.Parallel()
+
+	secret := deriveTOTPSecret("alice@example.com", "seed")
+
+	parsed, err := url.Parse(otpauthURI("alice@example.com", secret))
+	require.NoError(t, err)
+
+	assert.Equal(t, "otpauth", parsed.Scheme)
+	assert.Equal(t, "totp", parsed.Host)
+	assert.Equal(t, "/Alotame:alice@example.com", parsed.Path)
+	assert.Equal(t, encodeTOTPSecret(secret), parsed.Query().Get("secret"))
+	assert.Equal(t, "Alotame", parsed.Query().Get("issuer"))
+	assert.Equal(t, "6", parsed.Query().Get("digits"))
+	assert.Equal(t, "30", parsed.Query().Get("period"))
+	assert.NotContains(t, parsed.Query().Get("secret"), "=", "secret must be unpadded")
+}
+
+func TestQRCode(t *testing.T) {
+	t.Parallel()
+
+	png, err := qrPNG("otpauth://totp/Alotame:alice?secret=ABC")
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(png, []byte("\x89PNG")), "not a PNG")
+
+	svg, err := qrSVG("otpauth://totp/Alotame:alice?secret=ABC")
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(svg, []byte("<svg ")), "not an SVG")
+	assert.True(t, bytes.HasSuffix(svg, []byte("</svg>")))
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// Role is the set of permissions of an admin user. Each role has the
+// permissions of the roles below it.
+type Role string
+
+// Roles of the admin users, from the most to the least privileged.
+const (
+	// RoleAdmin edits the lists, the settings and the users.
+	RoleAdmin Role = "admin"
+	// RoleApprover allows blocked domains and accepts pending requests.
+	RoleApprover Role = "approver"
+	// RoleViewer has read-only access.
+	RoleViewer Role = "viewer"
+)
+
+// roles are the valid roles from the least privileged.
+var roles = []Role{RoleViewer, RoleApprover, RoleAdmin}
+
+// Errors of user management.
+var (
+	ErrInvalidRole  = errors.New("invalid role: must be one of admin, approver or viewer")
+	ErrUserExists   = errors.New("user already exists")
+	ErrUserNotFound = errors.New("user not found")
+	ErrLastAdmin    = errors.New("at least one admin user is required")
+)
+
+// Valid returns true if the role is one of the defined roles.
+func (r Role) Valid() bool {
+	return slices.Contains(roles, r)
+}
+
+// Allows returns true if the role has the permissions of the required role.
+func (r Role) Allows(required Role) bool {
+	return r.Valid() && slices.Index(roles, r) >= slices.Index(roles, required)
+}
+
+// ============================================================================
+//  User Management
+// ============================================================================
+
+// UserInfo is an admin user as listed on the users page.
+type UserInfo struct {
+	Username          string
+	Role              Role
+	RecoveryCodesLeft int
+}
+
+// UserSetup is what a new user needs to register in the authenticator app.
+// It is shown once to the admin who added the user.
+type UserSetup struct {
+	Username string
+	Role     Role
+	// URI is the otpauth:// URI to register in the authenticator app.
+	URI string
+	// Secret is the base32 encoded secret for manual entry.
+	Secret        string
+	RecoveryCodes []string
+}
+
+// Role returns the role of the user. A config without users (seed set by
+// hand) gives the admin role to anyone who signs in.
+func (a *Authenticator) Role(username string) (Role, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.auth.Users) == 0 {
+		return RoleAdmin, a.auth.Seed != ""
+	}
+
+	idx := a.userIndexLocked(username)
+	if idx < 0 {
+		return "", false
+	}
+
+	return a.auth.Users[idx].Role, true
+}
+
+// Users returns the users sorted by username.
+func (a *Authenticator) Users() []UserInfo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	users := make([]UserInfo, 0, len(a.auth.Users))
+
+	for _, user := range a.auth.Users {
+		users = append(users, UserInfo{
+			Username: user.Username, Role: user.Role, RecoveryCodesLeft: len(user.RecoveryCodes),
+		})
+	}
+
+	slices.SortFunc(users, func(a, b UserInfo) int { return strings.Compare(a.Username, b.Username) })
+
+	return users
+}
+
+// AddUser adds a user with the role and new recovery codes, and returns what
+// the user needs to sign in.
+func (a *Authenticator) AddUser(username string, role Role) (UserSetup, error) {
+	err := validateUsername(username)
+	if err != nil {
+		return UserSetup{}, err
+	}
+
+	if !role.Valid() {
+		return UserSetup{}, ErrInvalidRole
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.auth.Seed == "" {
+		return UserSetup{}, ErrNotEnrolled
+	}
+
+	if a.userIndexLocked(username) >= 0 {
+		return UserSetup{}, ErrUserExists
+	}
+
+	codes, hashes, err := generateRecoveryCodes(username, a.auth.Seed)
+	if err != nil {
+		return UserSetup{}, err
+	}
+
+	users := append(slices.Clone(a.auth.Users), UserConfig{Username: username, Role: role, RecoveryCodes: hashes})
+
+	err = a.saveUsersLocked(users)
+	if err != nil {
+		return UserSetup{}, err
+	}
+
+	slog.Info("user added", "username", username, "role", role)
+
+	secret := deriveTOTPSecret(username, a.auth.Seed)
+
+	return UserSetup{
+		Username: username, Role: role, URI: otpauthURI(username, secret), Secret: encodeTOTPSecret(secret),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// SetRole changes the role of the user. The last admin cannot be demoted.
+func (a *Authenticator) SetRole(username string, role Role) error {
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	idx := a.userIndexLocked(username)
+	if idx < 0 {
+		return ErrUserNotFound
+	}
+
+	users := slices.Clone(a.auth.Users)
+	users[idx].Role = role
+
+	if !hasAdmin(users) {
+		return ErrLastAdmin
+	}
+
+	err := a.saveUsersLocked(users)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("user role changed", "username", username, "role", role)
+
+	return nil
+}
+
+// RemoveUser removes the user. The last admin cannot be removed.
+func (a *Authenticator) RemoveUser(username string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	idx := a.userIndexLocked(username)
+	if idx < 0 {
+		return ErrUserNotFound
+	}
+
+	users := slices.Delete(slices.Clone(a.auth.Users), idx, idx+1)
+
+	if !hasAdmin(users) {
+		return ErrLastAdmin
+	}
+
+	err := a.saveUsersLocked(users)
+	if err != nil {
+		return err
+	}
+
+	delete(a.lastCounters, username)
+
+	slog.Info("user removed", "username", username)
+
+	return nil
+}
+
+// userIndexLocked returns the index of the user in the config or -1. The
+// caller must hold mu.
+func (a *Authenticator) userIndexLocked(username string) int {
+	return slices.IndexFunc(a.auth.Users, func(user UserConfig) bool { return user.Username == username })
+}
+
+// saveUsersLocked saves the users to the config file and applies them. The
+// caller must hold mu.
+func (a *Authenticator) saveUsersLocked(users []UserConfig) error {
+	auth := a.auth
+	auth.Users = users
+
+	err := SaveAuthConfig(a.configPath, auth)
+	if err != nil {
+		return err
+	}
+
+	a.auth = auth
+
+	return nil
+}
+
+// hasAdmin returns true if any of the users is an admin.
+func hasAdmin(users []UserConfig) bool {
+	return slices.ContainsFunc(users, func(user UserConfig) bool { return user.Role == RoleAdmin })
+}
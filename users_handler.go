@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Titles of the user management pages.
+const (
+	titleUsers     = "Users"
+	titleUserSetup = "New user"
+)
+
+// ============================================================================
+//  User Handlers
+// ============================================================================
+
+// userHandlers serves the user management pages. Only admins can use them.
+type userHandlers struct {
+	authn    *Authenticator
+	sessions *SessionStore
+	pages    *pageRenderer
+	now      func() time.Time
+}
+
+// registerUserHandlers registers the user management pages to the mux.
+func registerUserHandlers(mux *http.ServeMux, svc *adminServices, pages *pageRenderer) {
+	handlers := &userHandlers{authn: svc.authn, sessions: svc.sessions, pages: pages, now: svc.now}
+
+	mux.HandleFunc("GET /admin/users", svc.requireSession(RoleAdmin, handlers.getUsers))
+	mux.HandleFunc("POST /admin/users", svc.requireSession(RoleAdmin, handlers.postUsers))
+	mux.HandleFunc("POST /admin/users/role", svc.requireSession(RoleAdmin, handlers.postRole))
+	mux.HandleFunc("POST /admin/users/delete", svc.requireSession(RoleAdmin, handlers.postDelete))
+}
+
+func (h *userHandlers) getUsers(resWriter http.ResponseWriter, req *http.Request) {
+	h.renderUsers(resWriter, req, http.StatusOK, "")
+}
+
+// postUsers adds a user and shows the QR code and the recovery codes to hand
+// over to the new user.
+func (h *userHandlers) postUsers(resWriter http.ResponseWriter, req *http.Request) {
+	setup, err := h.authn.AddUser(req.PostFormValue("username"), Role(req.PostFormValue("role")))
+	if err != nil {
+		h.renderUserError(resWriter, req, err)
+
+		return
+	}
+
+	qrCode, err := qrSVG(setup.URI)
+	if err != nil {
+		slog.Error("failed to render QR code", "error", err)
+	}
+
+	sess, _ := sessionFromContext(req.Context())
+
+	h.pages.render(resWriter, http.StatusOK, "user_setup.html", page{ //nolint:exhaustruct // optional
+		Title: titleUserSetup, Username: setup.Username, Role: setup.Role, CSRFToken: sess.CSRFToken,
+		RecoveryCodesLeft: len(setup.RecoveryCodes), Secret: setup.Secret, URI: setup.URI,
+		RecoveryCodes: setup.RecoveryCodes,
+		QRCode:        template.HTML(qrCode), //nolint:gosec // generated SVG without user input
+	})
+}
+
+func (h *userHandlers) postRole(resWriter http.ResponseWriter, req *http.Request) {
+	err := h.authn.SetRole(req.PostFormValue("username"), Role(req.PostFormValue("role")))
+	if err != nil {
+		h.renderUserError(resWriter, req, err)
+
+		return
+	}
+
+	http.Redirect(resWriter, req, "/admin/users", http.StatusSeeOther)
+}
+
+// postDelete removes the user and ends the sessions of the user.
+func (h *userHandlers) postDelete(resWriter http.ResponseWriter, req *http.Request) {
+	username := req.PostFormValue("username")
+
+	err := h.authn.RemoveUser(username)
+	if err != nil {
+		h.renderUserError(resWriter, req, err)
+
+		return
+	}
+
+	h.sessions.RevokeUser(username, h.now())
+
+	http.Redirect(resWriter, req, "/admin/users", http.StatusSeeOther)
+}
+
+// renderUserError renders the users page with the error of a user change.
+func (h *userHandlers) renderUserError(resWriter http.ResponseWriter, req *http.Request, err error) {
+	status := http.StatusBadRequest
+
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrUserExists), errors.Is(err, ErrLastAdmin):
+		status = http.StatusConflict
+	case !errors.Is(err, ErrInvalidUsername) && !errors.Is(err, ErrInvalidRole):
+		slog.Error("failed to change users", "error", err)
+
+		status = http.StatusInternalServerError
+	}
+
+	h.renderUsers(resWriter, req, status, err.Error())
+}
+
+func (h *userHandlers) renderUsers(resWriter http.ResponseWriter, req *http.Request, status int, errMsg string) {
+	sess, _ := sessionFromContext(req.Context())
+
+	h.pages.render(resWriter, status, "users.html", page{ //nolint:exhaustruct // optional
+		Title: titleUsers, Error: errMsg, Username: sess.Username, Role: sess.Role, CSRFToken: sess.CSRFToken,
+		Users: h.authn.Users(), Roles: []Role{RoleAdmin, RoleApprover, RoleViewer},
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for User Handlers
+// ============================================================================
+
+func TestUserHandlers(t *testing.T) {
+	t.Parallel()
+
+	conf := testAdminConfig("parent")
+	path := writeConfigFile(t, `{}`, 0o600)
+	require.NoError(t, SaveAuthConfig(path, conf.Auth))
+
+	svc := testAdminServices(t, path, conf)
+	handler := newAdminHandler(new(StaticAllowlistProvider), svc)
+
+	admin := testSignIn(t, svc, "parent")
+	csrf := testCSRFToken(t, svc, admin)
+
+	rec := serveAdmin(handler, http.MethodGet, "/admin/users", nil, admin)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<td>parent</td>")
+
+	// Add a user
+	rec = serveAdmin(handler, http.MethodPost, "/admin/users",
+		url.Values{csrfFormField: {csrf}, "username": {"teen"}, "role": {"viewer"}}, admin)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<svg")
+	assert.Contains(t, rec.Body.String(), encodeTOTPSecret(deriveTOTPSecret("teen", "seed")))
+	assert.Regexp(t, `<code>[a-z2-7]{5}-[a-z2-7]{5}</code>`, rec.Body.String())
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/users",
+		url.Values{csrfFormField: {csrf}, "username": {"teen"}, "role": {"viewer"}}, admin)
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/users",
+		url.Values{csrfFormField: {csrf}, "username": {"guest"}, "role": {"root"}}, admin)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// The last admin cannot be demoted
+	rec = serveAdmin(handler, http.MethodPost, "/admin/users/role",
+		url.Values{csrfFormField: {csrf}, "username": {"parent"}, "role": {"viewer"}}, admin)
+	require.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrLastAdmin.Error())
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/users/role",
+		url.Values{csrfFormField: {csrf}, "username": {"teen"}, "role": {"approver"}}, admin)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+
+	role, _ := svc.authn.Role("teen")
+	assert.Equal(t, RoleApprover, role)
+
+	// Removing a user ends the sessions of the user
+	teen := testSignIn(t, svc, "teen")
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/users/delete",
+		url.Values{csrfFormField: {csrf}, "username": {"teen"}}, admin)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+
+	_, err := svc.sessions.Get(teen.Value, time.Now())
+	require.ErrorIs(t, err, ErrSessionNotFound)
+
+	rec = serveAdmin(handler, http.MethodPost, "/admin/users/delete",
+		url.Values{csrfFormField: {csrf}, "username": {"teen"}}, admin)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestUserHandlers_authorization(t *testing.T) {
+	t.Parallel()
+
+	conf := testAdminConfig("parent")
+	conf.Auth.Users = append(conf.Auth.Users,
+		UserConfig{Username: "approver", Role: RoleApprover, RecoveryCodes: nil},
+		UserConfig{Username: "teen", Role: RoleViewer, RecoveryCodes: nil})
+
+	svc := testAdminServices(t, "", conf)
+	handler := newAdminHandler(new(StaticAllowlistProvider), svc)
+
+	routes := []struct {
+		method string
+		path   string
+		role   Role
+	}{
+		{method: http.MethodGet, path: "/admin/", role: RoleViewer},
+		{method: http.MethodGet, path: "/admin/status", role: RoleViewer},
+		{method: http.MethodGet, path: "/admin/users", role: RoleAdmin},
+		{method: http.MethodPost, path: "/admin/users", role: RoleAdmin},
+		{method: http.MethodPost, path: "/admin/users/role", role: RoleAdmin},
+		{method: http.MethodPost, path: "/admin/users/delete", role: RoleAdmin},
+	}
+
+	for _, user := range conf.Auth.Users {
+		cookie := testSignIn(t, svc, user.Username)
+		form := url.Values{csrfFormField: {testCSRFToken(t, svc, cookie)}}
+
+		for _, route := range routes {
+			rec := serveAdmin(handler, route.method, route.path, form, cookie)
+
+			if user.Role.Allows(route.role) {
+				assert.NotEqual(t, http.StatusForbidden, rec.Code, "%s %s by %s", route.method, route.path, user.Role)
+			} else {
+				assert.Equal(t, http.StatusForbidden, rec.Code, "%s %s by %s", route.method, route.path, user.Role)
+			}
+		}
+	}
+
+	// A session of a removed user is no longer valid
+	cookie := testSignIn(t, svc, "stranger")
+	rec := serveAdmin(handler, http.MethodGet, "/admin/status", nil, cookie)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestUserHandlers_home_by_role(t *testing.T) {
+	t.Parallel()
+
+	conf := testAdminConfig("parent")
+	conf.Auth.Users = append(conf.Auth.Users, UserConfig{Username: "teen", Role: RoleViewer, RecoveryCodes: nil})
+
+	svc := testAdminServices(t, "", conf)
+	handler := newAdminHandler(new(StaticAllowlistProvider), svc)
+
+	rec := serveAdmin(handler, http.MethodGet, "/admin/", nil, testSignIn(t, svc, "parent"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `href="/admin/users"`)
+
+	rec = serveAdmin(handler, http.MethodGet, "/admin/", nil, testSignIn(t, svc, "teen"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Signed in as <strong>teen</strong> (viewer).")
+	assert.NotContains(t, rec.Body.String(), `href="/admin/users"`)
+}
+
+// testCSRFToken returns the CSRF token of the session of the cookie.
+func testCSRFToken(t *testing.T, svc *adminServices, cookie *http.Cookie) string {
+	t.Helper()
+
+	sess, err := svc.sessions.Get(cookie.Value, svc.now())
+	require.NoError(t, err)
+
+	return sess.CSRFToken
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+//  Tests for Role
+// ============================================================================
+
+func TestRole_Allows(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		role     Role
+		required Role
+		expect   bool
+	}{
+		{role: RoleAdmin, required: RoleAdmin, expect: true},
+		{role: RoleAdmin, required: RoleApprover, expect: true},
+		{role: RoleAdmin, required: RoleViewer, expect: true},
+		{role: RoleApprover, required: RoleAdmin, expect: false},
+		{role: RoleApprover, required: RoleApprover, expect: true},
+		{role: RoleApprover, required: RoleViewer, expect: true},
+		{role: RoleViewer, required: RoleAdmin, expect: false},
+		{role: RoleViewer, required: RoleApprover, expect: false},
+		{role: RoleViewer, required: RoleViewer, expect: true},
+		{role: "", required: RoleViewer, expect: false},
+		{role: "root", required: RoleViewer, expect: false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expect, test.role.Allows(test.required), "%q allows %q", test.role, test.required)
+	}
+}
+
+// ============================================================================
+//  Tests for User Management
+// ============================================================================
+
+func TestAuthenticator_users(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfigFile(t, `{"allowlistPath": "allowlist.txt"}`, 0o600)
+	auth := testAuthConfig("seed", "parent")
+	require.NoError(t, SaveAuthConfig(path, auth))
+
+	authn := NewAuthenticator(path, auth)
+	now := time.Unix(1700000000, 0)
+
+	setup, err := authn.AddUser("teen", RoleViewer)
+	require.NoError(t, err)
+
+	assert.Equal(t, "teen", setup.Username)
+	assert.Equal(t, RoleViewer, setup.Role)
+	assert.Contains(t, setup.URI, "otpauth://totp/Alotame:teen?")
+	assert.Len(t, setup.RecoveryCodes, recoveryCodeCount)
+
+	// Each user has its own secret derived from the username and the seed
+	secret := deriveTOTPSecret("teen", "seed")
+	assert.Equal(t, encodeTOTPSecret(secret), setup.Secret)
+	assert.NotEqual(t, deriveTOTPSecret("parent", "seed"), secret)
+
+	require.NoError(t, authn.Verify("teen", totpCode(secret, totpCounter(now)), now))
+	require.ErrorIs(t, authn.Verify("parent", totpCode(secret, totpCounter(now)), now), ErrInvalidCode)
+	require.ErrorIs(t, authn.Verify("stranger", totpCode(deriveTOTPSecret("stranger", "seed"), totpCounter(now)), now),
+		ErrInvalidCode, "unknown users cannot sign in")
+	require.NoError(t, authn.Verify("teen", setup.RecoveryCodes[0], now))
+
+	role, ok := authn.Role("teen")
+	require.True(t, ok)
+	assert.Equal(t, RoleViewer, role)
+
+	_, ok = authn.Role("stranger")
+	assert.False(t, ok)
+
+	_, err = authn.AddUser("teen", RoleAdmin)
+	require.ErrorIs(t, err, ErrUserExists)
+
+	_, err = authn.AddUser("guest", "root")
+	require.ErrorIs(t, err, ErrInvalidRole)
+
+	require.NoError(t, authn.SetRole("teen", RoleApprover))
+	require.ErrorIs(t, authn.SetRole("stranger", RoleViewer), ErrUserNotFound)
+	require.ErrorIs(t, authn.SetRole("parent", RoleViewer), ErrLastAdmin)
+	require.ErrorIs(t, authn.RemoveUser("parent"), ErrLastAdmin)
+
+	assert.Equal(t, []UserInfo{
+		{Username: "parent", Role: RoleAdmin, RecoveryCodesLeft: 0},
+		{Username: "teen", Role: RoleApprover, RecoveryCodesLeft: recoveryCodeCount - 1},
+	}, authn.Users())
+
+	// The changes are saved to the config file
+	conf, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, "allowlist.txt", conf.AllowlistPath, "other settings are kept")
+	require.Len(t, conf.Auth.Users, 2)
+	assert.Equal(t, RoleApprover, conf.Auth.Users[1].Role)
+
+	require.NoError(t, authn.RemoveUser("teen"))
+	require.ErrorIs(t, authn.RemoveUser("teen"), ErrUserNotFound)
+
+	_, ok = authn.Role("teen")
+	assert.False(t, ok)
+}
+
+func TestAuthenticator_AddUser_not_enrolled(t *testing.T) {
+	t.Parallel()
+
+	authn := NewAuthenticator(writeConfigFile(t, `{}`, 0o600), DefaultConfig().Auth)
+
+	_, err := authn.AddUser("alice", RoleAdmin)
+
+	require.ErrorIs(t, err, ErrNotEnrolled)
+}
+
+func TestAuthenticator_Role_without_users(t *testing.T) {
+	t.Parallel()
+
+	role, ok := NewAuthenticator("", testAuthConfig("hand-written-seed")).Role("anyone")
+	require.True(t, ok, "a seed set by hand without users accepts anyone as before")
+	assert.Equal(t, RoleAdmin, role)
+
+	_, ok = NewAuthenticator("", DefaultConfig().Auth).Role("anyone")
+	assert.False(t, ok, "nobody before enrollment")
+}
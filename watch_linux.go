@@ -0,0 +1,100 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// inotifyMask is the set of inotify events on the parent directory that may
+// change the watched file. The directory is watched instead of the file so
+// that atomic saves (write to temp file and rename) are detected.
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_CREATE |
+	syscall.IN_DELETE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM
+
+// inotifyBufSize is the buffer size to read inotify events.
+const inotifyBufSize = 64 * (syscall.SizeofInotifyEvent + syscall.NAME_MAX + 1)
+
+// watchFile sends an event to the returned channel when the file changes. It
+// uses inotify and falls back to polling at the given interval if inotify is
+// not available. The channel is closed when the context is canceled.
+func watchFile(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	events, err := inotifyFile(ctx, path)
+	if err != nil {
+		slog.Warn("inotify not available, falling back to polling", "path", path, "error", err)
+
+		return pollFile(ctx, path, interval)
+	}
+
+	return events
+}
+
+func inotifyFile(ctx context.Context, path string) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, wrapError(err, "inotify init failed")
+	}
+
+	_, err = syscall.InotifyAddWatch(fd, filepath.Dir(path), inotifyMask)
+	if err != nil {
+		_ = syscall.Close(fd)
+
+		return nil, wrapError(err, "inotify add watch failed")
+	}
+
+	// The fd is non-blocking, so the read is handled by the runtime poller and
+	// closing the file unblocks it.
+	file := os.NewFile(uintptr(fd), "inotify")
+	name := filepath.Base(path)
+	events := make(chan struct{}, 1)
+
+	go func() {
+		<-ctx.Done()
+
+		_ = file.Close()
+	}()
+
+	go func() {
+		defer close(events)
+
+		buf := make([]byte, inotifyBufSize)
+
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				return
+			}
+
+			if inotifyHasName(buf[:n], name) {
+				notify(events)
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// inotifyHasName reports whether the raw inotify events contain an event for
+// the given file name.
+func inotifyHasName(buf []byte, name string) bool {
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset])) //nolint:gosec // layout defined by the kernel
+		start := offset + syscall.SizeofInotifyEvent
+		end := min(start+int(event.Len), len(buf))
+
+		if string(bytes.TrimRight(buf[start:end], "\x00")) == name {
+			return true
+		}
+
+		offset = end
+	}
+
+	return false
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// watchFile sends an event to the returned channel when the file changes. On
+// this platform it polls the file at the given interval. The channel is
+// closed when the context is canceled.
+func watchFile(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	return pollFile(ctx, path, interval)
+}